@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/activity"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/handlers"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/ldapsync"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/metrics"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/scim"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -25,26 +36,92 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize Kratos client
-	kratosClient := kratos.NewClient(cfg.KratosAdminURL)
-	kratosClient.SetPublicURL(cfg.KratosPublicURL)
+	// Initialize Prometheus metrics
+	promMetrics := metrics.New()
+
+	// Initialize outbound webhooks
+	dataStore, err := store.Open(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to open data directory: %v", err)
+	}
+	dispatcher, err := webhooks.NewDispatcher(dataStore, cfg.WebhookMaxAttempts, cfg.WebhookRetryBackoff, cfg.WebhookTimeout)
+	if err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+	go dispatcher.Run(context.Background())
+
+	// Initialize four-eyes approval of destructive operations. Approved requests are
+	// replayed through the router with a short-lived token of the requester.
+	for _, entry := range cfg.ApprovalPolicy {
+		if !approvals.IsPolicy(entry) {
+			log.Fatalf("Unknown APPROVAL_POLICY entry %q", entry)
+		}
+	}
+	if len(cfg.ApprovalPolicy) > 0 && len(cfg.Admins) == 0 {
+		log.Println("APPROVAL_POLICY is set but no other admin is configured in ADMINS_FILE, change requests cannot be approved")
+	}
+	approvalsManager, err := approvals.NewManager(dataStore, cfg.ApprovalPolicy, cfg.ApprovalExpiry, func(username string) (string, error) {
+		return auth.IssueToken(cfg.JWTSecret, username, time.Now().Add(time.Minute))
+	})
+	if err != nil {
+		log.Fatalf("Failed to load change requests: %v", err)
+	}
+
+	// Initialize Kratos targets
+	targets := map[string]*targetServer{}
+	clients := map[string]*kratos.Client{}
+	feeds := map[string]*activity.Feed{}
+	for _, target := range cfg.KratosTargets {
+		server, err := newTargetServer(cfg, target, promMetrics.Target(target.Name), dispatcher, approvalsManager)
+		if err != nil {
+			log.Fatalf("Failed to initialize Kratos target %q: %v", target.Name, err)
+		}
+		targets[target.Name] = server
+		clients[target.Name] = server.client
+		feeds[target.Name] = server.activityFeed
+
+		// Start background refresh of the target's Prometheus gauges
+		go metrics.NewRefresher(promMetrics, target.Name, server.schemaUsage, server.dashboardStats, server.securityReport, cfg.MetricsRefreshInterval).Run(context.Background())
+	}
+	defaultTarget := targets[cfg.DefaultTarget]
 
 	// Initialize handlers
 	authHandler := auth.NewHandler(cfg)
-	identitiesHandler := handlers.NewIdentitiesHandler(kratosClient)
-	sessionsHandler := handlers.NewSessionsHandler(kratosClient)
-	schemasHandler := handlers.NewSchemasHandler(kratosClient)
-	statsHandler := handlers.NewStatsHandler(kratosClient)
+	healthHandler := handlers.NewHealthHandler(clients, cfg.ReadinessRequiredTargets, cfg.ReadinessTimeout, cfg.ReadinessRequirePublic)
+	targetsHandler := handlers.NewTargetsHandler(cfg)
+	transfersHandler := handlers.NewTransfersHandler(cfg, clients, jobs.NewManager(), dispatcher)
+	webhooksHandler := handlers.NewWebhooksHandler(cfg, dispatcher)
+	approvalsHandler := handlers.NewApprovalsHandler(cfg, approvalsManager)
+
+	// LDAP directory sync, enabled when an LDAP URL is configured
+	var ldapSyncHandler *handlers.LDAPSyncHandler
+	if cfg.LDAPSync.URL != "" {
+		mapping := cfg.LDAPSync.AttributeMapping
+		if mapping == nil {
+			mapping = ldapsync.DefaultMapping
+		}
+		syncer, err := ldapsync.NewSyncer(ldapsync.NewLDAPDirectory(cfg.LDAPSync), targets[cfg.LDAPSync.Target].client, dispatcher.Publisher(cfg.LDAPSync.Target), cfg.LDAPSync.SchemaID, cfg.LDAPSync.IDAttribute, mapping)
+		if err != nil {
+			log.Fatalf("Invalid LDAP sync configuration: %v", err)
+		}
+		syncJobs := jobs.NewManager()
+		scheduler := ldapsync.NewScheduler(syncer, syncJobs, cfg.LDAPSync.Interval)
+		go scheduler.Run(context.Background())
+		ldapSyncHandler = handlers.NewLDAPSyncHandler(scheduler, syncJobs)
+	}
 
 	// Initialize Gin router
 	router := gin.Default()
+	router.Use(promMetrics.Middleware())
+	router.NoRoute(unknownTarget(cfg))
+	approvalsManager.SetHandler(router)
 
 	// Configure CORS
 	log.Printf("CORS allowed origins: %v", cfg.CORSOrigins)
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     cfg.CORSOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", targetHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
@@ -54,33 +131,93 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Readiness endpoint, fails when the Kratos admin API of a target is unreachable
+	router.GET("/api/ready", healthHandler.Ready)
+
+	// Prometheus metrics endpoint
+	router.GET("/metrics", promMetrics.Handler())
+
 	// Public routes
 	router.POST("/api/auth/login", authHandler.Login)
 
+	// Kratos web hooks, enabled when hook tokens are configured
+	if len(cfg.KratosHookTokens) > 0 {
+		hooksHandler := handlers.NewKratosHooksHandler(feeds, cfg.DefaultTarget)
+		router.POST("/api/hooks/kratos", auth.BearerTokens(cfg.KratosHookTokens, nil), hooksHandler.Receive)
+	}
+
 	// Protected routes
 	protected := router.Group("/api")
 	protected.Use(auth.JWTMiddleware(cfg.JWTSecret))
 	{
-		// Identities
-		protected.GET("/identities", identitiesHandler.List)
-		protected.GET("/identities/:id", identitiesHandler.Get)
-		protected.GET("/identities/:id/credentials", identitiesHandler.GetWithCredentials)
-		protected.POST("/identities", identitiesHandler.Create)
-		protected.PUT("/identities/:id", identitiesHandler.Update)
-		protected.DELETE("/identities/:id", identitiesHandler.Delete)
-		protected.GET("/identities/:id/sessions", identitiesHandler.GetSessions)
-		protected.POST("/identities/:id/reset-password", identitiesHandler.ResetPassword)
-		protected.DELETE("/identities/:id/credentials/:type", identitiesHandler.DeleteCredential)
-
-		// Sessions
-		protected.GET("/sessions", sessionsHandler.List)
-		protected.DELETE("/sessions/:id", sessionsHandler.Revoke)
-
-		// Schemas
-		protected.GET("/schemas", schemasHandler.List)
-
-		// Stats
-		protected.GET("/stats", statsHandler.Get)
+		// Kratos targets
+		protected.GET("/targets", targetsHandler.List)
+
+		// Identity transfers between targets
+		protected.GET("/transfers", transfersHandler.List)
+		protected.POST("/transfers", approvalsManager.Require(approvals.OperationTransfer, "", true), transfersHandler.Create)
+		protected.GET("/transfers/:id", transfersHandler.Get)
+		protected.DELETE("/transfers/:id", transfersHandler.Cancel)
+
+		// Outbound webhooks, which receive events of every target
+		webhookRoutes := protected.Group("/webhooks", auth.AllTargetsAccess(cfg))
+		webhookRoutes.GET("", webhooksHandler.List)
+		webhookRoutes.POST("", webhooksHandler.Create)
+		webhookRoutes.GET("/deliveries", webhooksHandler.Deliveries)
+		webhookRoutes.GET("/deliveries/:id", webhooksHandler.Delivery)
+		webhookRoutes.POST("/deliveries/:id/redeliver", webhooksHandler.Redeliver)
+		webhookRoutes.GET("/:id", webhooksHandler.Get)
+		webhookRoutes.PUT("/:id", webhooksHandler.Update)
+		webhookRoutes.DELETE("/:id", webhooksHandler.Delete)
+
+		// Change requests of operations that need a second admin's approval
+		protected.GET("/approvals", approvalsHandler.List)
+		protected.GET("/approvals/:id", approvalsHandler.Get)
+		protected.POST("/approvals/:id/approve", approvalsHandler.Approve)
+		protected.POST("/approvals/:id/reject", approvalsHandler.Reject)
+
+		// LDAP sync runs, restricted to admins of the synchronized target
+		if ldapSyncHandler != nil {
+			ldapSync := protected.Group("/ldap-sync", auth.TargetAccess(cfg, cfg.LDAPSync.Target))
+			ldapSync.GET("/runs", ldapSyncHandler.List)
+			ldapSync.POST("/runs", ldapSyncHandler.Create)
+			ldapSync.GET("/runs/:id", ldapSyncHandler.Get)
+			ldapSync.DELETE("/runs/:id", ldapSyncHandler.Cancel)
+		}
+
+		// Routes without a target prefix serve the default target. Requests with the
+		// X-Kratos-Target header are routed to the prefixed routes by targetRouter.
+		defaultTarget.registerRoutes(protected.Group("", auth.TargetAccess(cfg, cfg.DefaultTarget)))
+
+		for name, server := range targets {
+			server.registerRoutes(protected.Group("/targets/"+name, auth.TargetAccess(cfg, name)))
+		}
+	}
+
+	// SCIM provisioning, enabled when SCIM tokens are configured
+	if len(cfg.SCIMTokens) > 0 {
+		mapping := cfg.SCIMAttributeMapping
+		if mapping == nil {
+			mapping = scim.DefaultMapping
+		}
+		mapper, err := scim.NewMapper(cfg.SCIMSchemaID, mapping)
+		if err != nil {
+			log.Fatalf("Invalid SCIM attribute mapping: %v", err)
+		}
+		scimHandler := handlers.NewSCIMHandler(targets[cfg.SCIMTarget].client, mapper, dispatcher.Publisher(cfg.SCIMTarget), targets[cfg.SCIMTarget].trash)
+
+		scimRoutes := router.Group("/scim/v2", auth.BearerTokens(cfg.SCIMTokens, scim.Unauthorized))
+		scimRoutes.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+		scimRoutes.GET("/ResourceTypes", scimHandler.ResourceTypes)
+		scimRoutes.GET("/ResourceTypes/:id", scimHandler.ResourceType)
+		scimRoutes.GET("/Schemas", scimHandler.Schemas)
+		scimRoutes.GET("/Schemas/:id", scimHandler.Schema)
+		scimRoutes.GET("/Users", scimHandler.ListUsers)
+		scimRoutes.POST("/Users", scimHandler.CreateUser)
+		scimRoutes.GET("/Users/:id", scimHandler.GetUser)
+		scimRoutes.PUT("/Users/:id", scimHandler.ReplaceUser)
+		scimRoutes.PATCH("/Users/:id", scimHandler.PatchUser)
+		scimRoutes.DELETE("/Users/:id", scimHandler.DeleteUser)
 	}
 
 	// Get port from config or default
@@ -90,7 +227,7 @@ func main() {
 	}
 
 	log.Printf("Starting server on port %s", port)
-	if err := router.Run(":" + port); err != nil {
+	if err := http.ListenAndServe(":"+port, newTargetRouter(router, cfg)); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 		os.Exit(1)
 	}
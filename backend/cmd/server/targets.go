@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/activity"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/erasure"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/handlers"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/history"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/stats"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// targetHeader selects a Kratos target on routes without the /api/targets/:name prefix
+const targetHeader = "X-Kratos-Target"
+
+// targetServer holds the Kratos client, caches and handlers of one Kratos target
+type targetServer struct {
+	name      string
+	client    *kratos.Client
+	approvals *approvals.Manager
+
+	dashboardStats *stats.DashboardCache
+	schemaUsage    *stats.UsageCache
+	securityReport *stats.SecurityCache
+	activityFeed   *activity.Feed
+	// trash is nil unless soft delete is enabled
+	trash *trash.Bin
+
+	identitiesHandler *handlers.IdentitiesHandler
+	sessionsHandler   *handlers.SessionsHandler
+	schemasHandler    *handlers.SchemasHandler
+	statsHandler      *handlers.StatsHandler
+	migrationsHandler *handlers.MigrationsHandler
+	courierHandler    *handlers.CourierHandler
+	activityHandler   *handlers.ActivityHandler
+	historyHandler    *handlers.HistoryHandler
+	dsarHandler       *handlers.DSARHandler
+	erasureHandler    *handlers.ErasureHandler
+	duplicatesHandler *handlers.DuplicatesHandler
+	// trashHandler is nil unless soft delete is enabled
+	trashHandler *handlers.TrashHandler
+}
+
+// newTargetServer initializes a Kratos target and starts its background stats collection.
+// Local data of targets other than the default one is kept under DATA_DIR/targets/<name>.
+func newTargetServer(cfg *config.Config, target config.KratosTarget, observer kratos.Observer, dispatcher *webhooks.Dispatcher, approvalsManager *approvals.Manager) (*targetServer, error) {
+	client := kratos.NewClient(target.AdminURL)
+	client.SetPublicURL(target.PublicURL)
+	if target.AdminToken != "" {
+		client.SetAdminToken(target.AdminToken)
+	}
+	client.SetObserver(observer)
+
+	dataDir := cfg.DataDir
+	if target.Name != cfg.DefaultTarget {
+		dataDir = filepath.Join(cfg.DataDir, "targets", target.Name)
+	}
+	dataStore, err := store.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	statsHistory, err := stats.NewHistory(dataStore, cfg.StatsRetention)
+	if err != nil {
+		return nil, err
+	}
+	go stats.NewCollector(client, statsHistory, cfg.StatsSnapshotInterval).Run(context.Background())
+
+	dashboardStats := stats.NewDashboardCache(client, statsHistory, cfg.StatsRefreshInterval)
+	schemaUsage := stats.NewUsageCache(client, cfg.SchemaUsageCacheTTL)
+	securityReport := stats.NewSecurityCache(client, cfg.SecurityReportCacheTTL)
+
+	activityFeed, err := activity.NewFeed(dataStore, cfg.ActivityRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	jobManager := jobs.NewManager()
+	events := dispatcher.Publisher(target.Name)
+
+	historyRecorder, err := history.NewRecorder(dataStore, cfg.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	go historyRecorder.Run(context.Background())
+	go history.NewScanner(client, historyRecorder, cfg.HistoryScanInterval).Run(context.Background())
+
+	var bin *trash.Bin
+	var trashHandler *handlers.TrashHandler
+	if cfg.SoftDelete {
+		bin, err = trash.NewBin(client, dataStore, cfg.SoftDeleteRetention, cfg.SoftDeletePurgeInterval)
+		if err != nil {
+			return nil, err
+		}
+		go bin.Run(context.Background())
+		trashHandler = handlers.NewTrashHandler(bin, events)
+	}
+
+	// Anonymization erases the local copies of an identity's data along with the identity itself
+	forgetters := []erasure.Forgetter{historyRecorder, activityFeed, events, approvalsManager.Forgetter(target.Name)}
+	if bin != nil {
+		forgetters = append(forgetters, bin)
+	}
+	anonymizer, err := erasure.NewAnonymizer(client, dataStore, forgetters...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &targetServer{
+		name:      target.Name,
+		client:    client,
+		approvals: approvalsManager,
+
+		dashboardStats: dashboardStats,
+		schemaUsage:    schemaUsage,
+		securityReport: securityReport,
+		activityFeed:   activityFeed,
+		trash:          bin,
+
+		identitiesHandler: handlers.NewIdentitiesHandler(client, events, bin, historyRecorder),
+		sessionsHandler:   handlers.NewSessionsHandler(client, events),
+		schemasHandler:    handlers.NewSchemasHandler(client, schemaUsage),
+		statsHandler:      handlers.NewStatsHandler(client, dashboardStats, statsHistory, securityReport),
+		migrationsHandler: handlers.NewMigrationsHandler(client, jobManager, events),
+		courierHandler:    handlers.NewCourierHandler(client),
+		activityHandler:   handlers.NewActivityHandler(activityFeed),
+		historyHandler:    handlers.NewHistoryHandler(historyRecorder),
+		dsarHandler:       handlers.NewDSARHandler(client, target.Name, activityFeed, historyRecorder, approvalsManager, bin),
+		erasureHandler:    handlers.NewErasureHandler(anonymizer, events, historyRecorder),
+		duplicatesHandler: handlers.NewDuplicatesHandler(client, events, historyRecorder, bin),
+		trashHandler:      trashHandler,
+	}, nil
+}
+
+// registerRoutes registers the Kratos-backed API routes of the target on rg
+func (s *targetServer) registerRoutes(rg *gin.RouterGroup) {
+	// Identities
+	rg.GET("/identities", s.identitiesHandler.List)
+	rg.GET("/identities/duplicates", s.duplicatesHandler.Find)
+	rg.POST("/identities/merge", s.approvals.Require(approvals.OperationMergeIdentities, s.name, true), s.duplicatesHandler.Merge)
+	rg.GET("/identities/:id", s.identitiesHandler.Get)
+	rg.GET("/identities/:id/credentials", s.identitiesHandler.GetWithCredentials)
+	rg.POST("/identities", s.identitiesHandler.Create)
+	rg.PUT("/identities/:id", s.identitiesHandler.Update)
+	rg.DELETE("/identities/:id", s.approvals.Require(approvals.OperationDeleteIdentity, s.name, false), s.identitiesHandler.Delete)
+	rg.GET("/identities/:id/sessions", s.identitiesHandler.GetSessions)
+	rg.POST("/identities/:id/reset-password", s.identitiesHandler.ResetPassword)
+	rg.DELETE("/identities/:id/credentials/:type", s.approvals.Require(approvals.OperationDeleteCredential, s.name, false), s.identitiesHandler.DeleteCredential)
+	rg.GET("/identities/:id/courier-messages", s.courierHandler.ForIdentity)
+	rg.GET("/identities/:id/activity", s.activityHandler.ForIdentity)
+	rg.GET("/identities/:id/history", s.historyHandler.List)
+	rg.GET("/identities/:id/history/:version", s.historyHandler.Get)
+	rg.GET("/identities/:id/dsar", s.dsarHandler.Export)
+	rg.POST("/identities/:id/anonymize", s.approvals.Require(approvals.OperationAnonymizeIdentity, s.name, true), s.erasureHandler.Anonymize)
+
+	// Tombstones of anonymized identities
+	rg.GET("/anonymized-identities", s.erasureHandler.List)
+	rg.GET("/anonymized-identities/:id", s.erasureHandler.Get)
+
+	// Soft-deleted identities
+	if s.trashHandler != nil {
+		rg.GET("/deleted-identities", s.trashHandler.List)
+		rg.GET("/deleted-identities/:id", s.trashHandler.Get)
+		rg.POST("/deleted-identities/:id/restore", s.trashHandler.Restore)
+		rg.DELETE("/deleted-identities/:id", s.approvals.Require(approvals.OperationDeleteIdentity, s.name, false), s.trashHandler.Purge)
+	}
+
+	// Activity reported by Kratos web hooks
+	rg.GET("/activity", s.activityHandler.List)
+
+	// Sessions
+	rg.GET("/sessions", s.sessionsHandler.List)
+	rg.DELETE("/sessions/:id", s.sessionsHandler.Revoke)
+
+	// Courier messages
+	rg.GET("/courier/messages", s.courierHandler.List)
+	rg.GET("/courier/messages/:id", s.courierHandler.Get)
+
+	// Schemas
+	rg.GET("/schemas", s.schemasHandler.List)
+	rg.GET("/schemas/diff", s.schemasHandler.Diff)
+	rg.POST("/schemas/lint", s.schemasHandler.Lint)
+	rg.GET("/schemas/:id/form", s.schemasHandler.Form)
+	rg.GET("/schemas/:id/usage", s.schemasHandler.Usage)
+	rg.POST("/schemas/:id/validate", s.schemasHandler.Validate)
+
+	// Schema migrations
+	rg.GET("/migrations", s.migrationsHandler.List)
+	rg.POST("/migrations", s.approvals.Require(approvals.OperationMigration, s.name, true), s.migrationsHandler.Create)
+	rg.GET("/migrations/:id", s.migrationsHandler.Get)
+	rg.DELETE("/migrations/:id", s.migrationsHandler.Cancel)
+
+	// Stats
+	rg.GET("/stats", s.statsHandler.Get)
+	rg.GET("/stats/history", s.statsHandler.History)
+	rg.GET("/stats/trends", s.statsHandler.Trends)
+	rg.GET("/stats/security", s.statsHandler.Security)
+	rg.GET("/stats/courier", s.statsHandler.Courier)
+}
+
+// targetRouter serves requests carrying the X-Kratos-Target header from the /api/targets/:name
+// routes of that target. The path is rewritten before routing, so that the requests go through
+// the middlewares once.
+type targetRouter struct {
+	engine        *gin.Engine
+	defaultTarget string
+	// routes are the path templates of the target routes, relative to /api
+	routes []string
+}
+
+// newTargetRouter wraps the engine once all routes are registered
+func newTargetRouter(engine *gin.Engine, cfg *config.Config) *targetRouter {
+	prefix := "/api/targets/" + cfg.DefaultTarget
+	seen := map[string]bool{}
+	var routes []string
+	for _, route := range engine.Routes() {
+		if path := strings.TrimPrefix(route.Path, prefix); path != route.Path && !seen[path] {
+			seen[path] = true
+			routes = append(routes, path)
+		}
+	}
+	return &targetRouter{engine: engine, defaultTarget: cfg.DefaultTarget, routes: routes}
+}
+
+// ServeHTTP implements http.Handler
+func (t *targetRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.Header.Get(targetHeader)
+	if name != "" && name != t.defaultTarget && strings.HasPrefix(r.URL.Path, "/api/") {
+		path := strings.TrimPrefix(r.URL.Path, "/api")
+		for _, route := range t.routes {
+			if matchRoute(route, path) {
+				r.URL.Path = "/api/targets/" + name + path
+				r.URL.RawPath = ""
+				break
+			}
+		}
+	}
+	t.engine.ServeHTTP(w, r)
+}
+
+// matchRoute reports whether a path matches a gin path template
+func matchRoute(template, path string) bool {
+	parts := strings.Split(strings.Trim(template, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, "*"):
+			return true
+		case i >= len(segments):
+			return false
+		case !strings.HasPrefix(part, ":") && part != segments[i]:
+			return false
+		}
+	}
+	return len(parts) == len(segments)
+}
+
+// unknownTarget answers requests for the routes of targets that are not configured. Other
+// unmatched requests get gin's default 404 response.
+func unknownTarget(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rest, ok := strings.CutPrefix(c.Request.URL.Path, "/api/targets/")
+		if !ok {
+			return
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		if _, ok := cfg.Target(name); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown Kratos target", "target": name})
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package activity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+)
+
+// eventsLog is the store log holding activity events
+const eventsLog = "activity.jsonl"
+
+// pruneSlack lets expired events accumulate for a while so the log is not rewritten on every event
+const pruneSlack = 24 * time.Hour
+
+// Kinds of self-service flows reported by Kratos web hooks
+const (
+	KindRegistration = "registration"
+	KindLogin        = "login"
+	KindSettings     = "settings"
+	KindRecovery     = "recovery"
+	KindVerification = "verification"
+)
+
+// IsKind reports whether a flow kind is supported
+func IsKind(kind string) bool {
+	switch kind {
+	case KindRegistration, KindLogin, KindSettings, KindRecovery, KindVerification:
+		return true
+	}
+	return false
+}
+
+// Event is a completed self-service flow of an identity
+type Event struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	IdentityID string    `json:"identity_id"`
+	FlowID     string    `json:"flow_id,omitempty"`
+	FlowType   string    `json:"flow_type,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	SessionID  string    `json:"session_id,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Query selects events of the feed. Empty fields match every event.
+type Query struct {
+	IdentityID string
+	Kind       string
+	// Before only returns events received before it, to page through the feed
+	Before time.Time
+	// Limit is the maximum number of events returned, 0 for every event
+	Limit int
+}
+
+// Feed keeps activity events in memory and persists them in the local store
+type Feed struct {
+	store     *store.Store
+	retention time.Duration
+
+	mu     sync.RWMutex
+	events []Event
+}
+
+// NewFeed loads previously stored events
+func NewFeed(st *store.Store, retention time.Duration) (*Feed, error) {
+	f := &Feed{store: st, retention: retention}
+
+	err := st.ReadLines(eventsLog, func(line []byte) error {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			// Skip entries that were only partially written
+			return nil
+		}
+		f.events = append(f.events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Add records an event, setting its ID and reception time, and drops events older than the retention period
+func (f *Feed) Add(e Event) (Event, error) {
+	e.ID = newID()
+	e.ReceivedAt = time.Now().UTC()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, e)
+
+	cutoff := e.ReceivedAt.Add(-f.retention)
+	if f.events[0].ReceivedAt.After(cutoff.Add(-pruneSlack)) {
+		return e, f.store.Append(eventsLog, e)
+	}
+
+	keep := 0
+	for keep < len(f.events) && f.events[keep].ReceivedAt.Before(cutoff) {
+		keep++
+	}
+	f.events = append([]Event(nil), f.events[keep:]...)
+	entries := make([]interface{}, len(f.events))
+	for i, event := range f.events {
+		entries[i] = event
+	}
+	return e, f.store.Rewrite(eventsLog, entries)
+}
+
+// Forget drops every event of an identity, e.g. when its personal data is erased
+func (f *Feed) Forget(identityID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	kept := make([]Event, 0, len(f.events))
+	for _, e := range f.events {
+		if e.IdentityID != identityID {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(f.events) {
+		return nil
+	}
+
+	f.events = kept
+	entries := make([]interface{}, len(f.events))
+	for i, event := range f.events {
+		entries[i] = event
+	}
+	return f.store.Rewrite(eventsLog, entries)
+}
+
+// List returns the events matching the query, newest first
+func (f *Feed) List(q Query) []Event {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	result := []Event{}
+	for i := len(f.events) - 1; i >= 0 && (q.Limit == 0 || len(result) < q.Limit); i-- {
+		e := f.events[i]
+		if !q.Before.IsZero() && !e.ReceivedAt.Before(q.Before) {
+			continue
+		}
+		if q.IdentityID != "" && e.IdentityID != q.IdentityID {
+			continue
+		}
+		if q.Kind != "" && e.Kind != q.Kind {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// newID returns a random event identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,427 @@
+package approvals
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+)
+
+// requestsDocument is the store document holding change requests
+const requestsDocument = "approvals.json"
+
+// maxFinishedRequests is the number of decided or expired requests kept
+const maxFinishedRequests = 1000
+
+// Operations that can require approval
+const (
+	OperationDeleteIdentity    = "identity.delete"
+	OperationAnonymizeIdentity = "identity.anonymize"
+	OperationMergeIdentities   = "identity.merge"
+	OperationDeleteCredential  = "credential.delete"
+	OperationMigration         = "migration.create"
+	OperationTransfer          = "transfer.create"
+)
+
+// PolicyBulk is a policy entry covering every bulk operation
+const PolicyBulk = "bulk"
+
+// bulkOperations are the operations covered by PolicyBulk
+var bulkOperations = []string{OperationMigration, OperationTransfer}
+
+// Change request statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+	StatusExecuted = "executed"
+	StatusFailed   = "failed"
+)
+
+var (
+	// ErrNotFound is returned when a change request does not exist
+	ErrNotFound = errors.New("change request not found")
+	// ErrNotPending is returned when deciding on a request that is no longer pending
+	ErrNotPending = errors.New("change request is not pending")
+	// ErrSelfApproval is returned when the requester tries to approve their own request
+	ErrSelfApproval = errors.New("change requests must be approved by another admin")
+)
+
+// Result is the response of an executed change request
+type Result struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+// Request is an operation waiting for, or decided by, a second admin
+type Request struct {
+	ID        string `json:"id"`
+	Operation string `json:"operation"`
+	// Target is the Kratos target the operation applies to, empty for operations spanning targets
+	Target      string          `json:"target,omitempty"`
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Query       string          `json:"query,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	RequestedBy string          `json:"requested_by"`
+	Reason      string          `json:"reason,omitempty"`
+	Status      string          `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	DecidedBy   string          `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time      `json:"decided_at,omitempty"`
+	Comment     string          `json:"comment,omitempty"`
+	Result      *Result         `json:"result,omitempty"`
+	// Redacted is set once the body, reason and result were erased with the identity's data
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Concerns reports whether the request is about an identity: its path names the identity, also
+// in the trash, or it merges the identity with another one
+func (r Request) Concerns(identityID string) bool {
+	if strings.HasSuffix(r.Path, "identities/"+identityID) || strings.Contains(r.Path, "identities/"+identityID+"/") {
+		return true
+	}
+	if r.Operation != OperationMergeIdentities || len(r.Body) == 0 {
+		return false
+	}
+	var merge struct {
+		WinnerID string `json:"winner_id"`
+		LoserID  string `json:"loser_id"`
+	}
+	if err := json.Unmarshal(r.Body, &merge); err != nil {
+		return false
+	}
+	return merge.WinnerID == identityID || merge.LoserID == identityID
+}
+
+// TokenIssuer returns a short-lived API token authenticating username
+type TokenIssuer func(username string) (string, error)
+
+// Manager turns operations covered by the policy into change requests and runs them once approved
+type Manager struct {
+	store  *store.Store
+	policy map[string]bool
+	expiry time.Duration
+	issue  TokenIssuer
+
+	handler http.Handler
+
+	mu       sync.Mutex
+	requests []*Request
+}
+
+// NewManager loads stored change requests. policy lists the operations requiring approval,
+// PolicyBulk standing for every bulk operation.
+func NewManager(s *store.Store, policy []string, expiry time.Duration, issue TokenIssuer) (*Manager, error) {
+	m := &Manager{store: s, policy: map[string]bool{}, expiry: expiry, issue: issue}
+	for _, operation := range policy {
+		if operation == PolicyBulk {
+			for _, bulk := range bulkOperations {
+				m.policy[bulk] = true
+			}
+			continue
+		}
+		m.policy[operation] = true
+	}
+
+	if err := s.Load(requestsDocument, &m.requests); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// IsPolicy reports whether a policy entry names a known operation
+func IsPolicy(entry string) bool {
+	switch entry {
+	case PolicyBulk, OperationDeleteIdentity, OperationAnonymizeIdentity, OperationMergeIdentities, OperationDeleteCredential, OperationMigration, OperationTransfer:
+		return true
+	}
+	return false
+}
+
+// SetHandler sets the HTTP handler approved requests are replayed against, usually the router
+func (m *Manager) SetHandler(handler http.Handler) {
+	m.handler = handler
+}
+
+// Requires reports whether an operation needs approval
+func (m *Manager) Requires(operation string) bool {
+	return m.policy[operation]
+}
+
+// Submit stores a new pending change request
+func (m *Manager) Submit(req Request) (Request, error) {
+	now := time.Now().UTC()
+	req.ID = newID()
+	req.Status = StatusPending
+	req.CreatedAt = now
+	req.ExpiresAt = now.Add(m.expiry)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests = append(m.requests, &req)
+	if err := m.save(); err != nil {
+		m.requests = m.requests[:len(m.requests)-1]
+		return Request{}, err
+	}
+	return req, nil
+}
+
+// List returns the change requests with the given status, or all of them, newest first
+func (m *Manager) List(status string) []Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	result := []Request{}
+	for i := len(m.requests) - 1; i >= 0; i-- {
+		if status == "" || m.requests[i].Status == status {
+			result = append(result, *m.requests[i])
+		}
+	}
+	return result
+}
+
+// Get returns a change request
+func (m *Manager) Get(id string) (Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	req, ok := m.find(id)
+	if !ok {
+		return Request{}, ErrNotFound
+	}
+	return *req, nil
+}
+
+// Reject rejects a pending request. Requesters may reject their own request to withdraw it.
+func (m *Manager) Reject(id, admin, comment string) (Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req, err := m.decide(id, admin, comment, false)
+	if err != nil {
+		return Request{}, err
+	}
+	req.Status = StatusRejected
+	return *req, m.save()
+}
+
+// Approve approves a pending request of another admin and runs it on behalf of the requester.
+// The requester's access is checked again when the operation runs.
+func (m *Manager) Approve(ctx context.Context, id, admin, comment string) (Request, error) {
+	m.mu.Lock()
+	req, err := m.decide(id, admin, comment, true)
+	if err != nil {
+		m.mu.Unlock()
+		return Request{}, err
+	}
+	req.Status = StatusApproved
+	if err := m.save(); err != nil {
+		m.mu.Unlock()
+		return Request{}, err
+	}
+	snapshot := *req
+	m.mu.Unlock()
+
+	// Finish the operation even if the approving client goes away
+	result, err := m.replay(context.WithoutCancel(ctx), snapshot)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	req.Result = result
+	switch {
+	case err != nil:
+		req.Status = StatusFailed
+		req.Result = &Result{Body: errorBody(err)}
+	case result.StatusCode >= 200 && result.StatusCode < 300:
+		req.Status = StatusExecuted
+	default:
+		req.Status = StatusFailed
+	}
+	return *req, m.save()
+}
+
+// Forgetter returns an erasure forgetter for the change requests of a Kratos target
+func (m *Manager) Forgetter(target string) *Forgetter {
+	return &Forgetter{manager: m, target: target}
+}
+
+// Forgetter redacts the change requests about an identity of a Kratos target. The requests are
+// kept for the audit trail, without the bodies and reasons that may hold personal data.
+type Forgetter struct {
+	manager *Manager
+	target  string
+}
+
+// Forget redacts the change requests about an identity. Pending ones are rejected, as they
+// cannot be replayed without their body.
+func (f *Forgetter) Forget(identityID string) error {
+	m := f.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := false
+	for _, req := range m.requests {
+		if req.Target != f.target || !req.Concerns(identityID) {
+			continue
+		}
+		if req.Status == StatusPending {
+			now := time.Now().UTC()
+			req.Status = StatusRejected
+			req.DecidedAt = &now
+			req.Comment = "The identity was anonymized"
+		}
+		req.Body = nil
+		req.Reason = ""
+		if req.Result != nil {
+			req.Result.Body = nil
+		}
+		req.Redacted = true
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return m.save()
+}
+
+// decide records the decision on a pending request. Callers must hold m.mu.
+func (m *Manager) decide(id, admin, comment string, approve bool) (*Request, error) {
+	m.expire()
+
+	req, ok := m.find(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if req.Status != StatusPending {
+		return nil, ErrNotPending
+	}
+	if approve && req.RequestedBy == admin {
+		return nil, ErrSelfApproval
+	}
+
+	now := time.Now().UTC()
+	req.DecidedBy = admin
+	req.DecidedAt = &now
+	req.Comment = comment
+	return req, nil
+}
+
+// replay sends the original request to the handler, authenticated as the requester
+func (m *Manager) replay(ctx context.Context, req Request) (*Result, error) {
+	if m.handler == nil {
+		return nil, errors.New("no handler to run approved requests")
+	}
+
+	token, err := m.issue(req.RequestedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate as %s: %w", req.RequestedBy, err)
+	}
+
+	url := req.Path
+	if req.Query != "" {
+		url += "?" + req.Query
+	}
+	httpReq, err := http.NewRequestWithContext(withApproval(ctx, req.ID), req.Method, url, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	if len(req.Body) > 0 {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	recorder := httptest.NewRecorder()
+	m.handler.ServeHTTP(recorder, httpReq)
+
+	result := &Result{StatusCode: recorder.Code}
+	if body := recorder.Body.Bytes(); json.Valid(body) {
+		result.Body = body
+	}
+	return result, nil
+}
+
+// expire marks pending requests past their expiry and drops the oldest finished ones.
+// Callers must hold m.mu.
+func (m *Manager) expire() {
+	now := time.Now()
+	changed := false
+	finished := 0
+	for _, req := range m.requests {
+		if req.Status == StatusPending && now.After(req.ExpiresAt) {
+			req.Status = StatusExpired
+			changed = true
+		}
+		if req.Status != StatusPending && req.Status != StatusApproved {
+			finished++
+		}
+	}
+
+	if finished > maxFinishedRequests {
+		kept := make([]*Request, 0, len(m.requests))
+		for _, req := range m.requests {
+			if req.Status != StatusPending && req.Status != StatusApproved && finished > maxFinishedRequests {
+				finished--
+				continue
+			}
+			kept = append(kept, req)
+		}
+		m.requests = kept
+		changed = true
+	}
+
+	if changed {
+		if err := m.save(); err != nil {
+			log.Printf("Failed to save change requests: %v", err)
+		}
+	}
+}
+
+// find returns a change request. Callers must hold m.mu.
+func (m *Manager) find(id string) (*Request, bool) {
+	for _, req := range m.requests {
+		if req.ID == id {
+			return req, true
+		}
+	}
+	return nil, false
+}
+
+// save persists the change requests in creation order. Callers must hold m.mu.
+func (m *Manager) save() error {
+	sort.SliceStable(m.requests, func(i, j int) bool { return m.requests[i].CreatedAt.Before(m.requests[j].CreatedAt) })
+	return m.store.Save(requestsDocument, m.requests)
+}
+
+// errorBody encodes an error as a JSON response body
+func errorBody(err error) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return body
+}
+
+// newID returns a random change request identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,78 @@
+package approvals
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+)
+
+func TestRequestConcerns(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want bool
+	}{
+		{"identity route", Request{Path: "/api/identities/abc"}, true},
+		{"identity sub-route", Request{Path: "/api/targets/eu/identities/abc/credentials/password"}, true},
+		{"trash route", Request{Path: "/api/deleted-identities/abc"}, true},
+		{"other identity", Request{Path: "/api/identities/abcd"}, false},
+		{"merge winner", Request{Operation: OperationMergeIdentities, Path: "/api/identities/merge", Body: json.RawMessage(`{"winner_id":"abc","loser_id":"def"}`)}, true},
+		{"merge loser", Request{Operation: OperationMergeIdentities, Path: "/api/identities/merge", Body: json.RawMessage(`{"winner_id":"def","loser_id":"abc"}`)}, true},
+		{"other merge", Request{Operation: OperationMergeIdentities, Path: "/api/identities/merge", Body: json.RawMessage(`{"winner_id":"def","loser_id":"ghi"}`)}, false},
+		{"migration mentioning the identity", Request{Operation: OperationMigration, Path: "/api/migrations", Body: json.RawMessage(`{"winner_id":"abc"}`)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.Concerns("abc"); got != tt.want {
+				t.Errorf("Concerns = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForgetterForget(t *testing.T) {
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(s, []string{OperationDeleteIdentity, OperationMergeIdentities}, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submit := func(target, operation, path, body string) Request {
+		req, err := m.Submit(Request{Target: target, Operation: operation, Path: path, Body: json.RawMessage(body), Reason: "Asked by jane@example.org", RequestedBy: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	merge := submit("default", OperationMergeIdentities, "/api/identities/merge", `{"winner_id":"abc","loser_id":"def"}`)
+	other := submit("default", OperationDeleteIdentity, "/api/identities/ghi", "")
+	otherTarget := submit("eu", OperationDeleteIdentity, "/api/targets/eu/identities/abc", "")
+
+	if err := m.Forgetter("default").Forget("abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := m.Get(merge.ID)
+	if !got.Redacted || got.Body != nil || got.Reason != "" || got.Status != StatusRejected || got.RequestedBy != "alice" {
+		t.Errorf("merge request = %+v, want it rejected and redacted", got)
+	}
+	for _, id := range []string{other.ID, otherTarget.ID} {
+		if got, _ := m.Get(id); got.Redacted || got.Reason == "" || got.Status != StatusPending {
+			t.Errorf("request %s = %+v, want it untouched", id, got)
+		}
+	}
+
+	// The redaction is saved
+	reloaded, err := NewManager(s, nil, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reloaded.Get(merge.ID); !got.Redacted || got.Body != nil {
+		t.Errorf("reloaded merge request = %+v, want it redacted", got)
+	}
+}
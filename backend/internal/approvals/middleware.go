@@ -0,0 +1,98 @@
+package approvals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// approvalKey is the request context key marking requests replayed after approval
+type approvalKey struct{}
+
+// withApproval marks a request context as carrying an approved change request
+func withApproval(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, approvalKey{}, id)
+}
+
+// ApprovalID returns the ID of the change request being run, or an empty string
+func ApprovalID(ctx context.Context) string {
+	id, _ := ctx.Value(approvalKey{}).(string)
+	return id
+}
+
+// dryRunKey is the gin context key holding the dry_run flag parsed by Require
+const dryRunKey = "approvals.dry_run"
+
+// DryRun reports whether Require let the request through as a dry run. Handlers of operations
+// allowing dry runs use it instead of their own copy of the flag, so a request cannot skip
+// approval with a body parsed differently by the handler.
+func DryRun(c *gin.Context) bool {
+	return c.GetBool(dryRunKey)
+}
+
+// Require creates a middleware that turns requests for an operation on target into pending
+// change requests when the policy covers it. Requests replayed after approval pass through, and
+// so do requests with "dry_run": true in their body when allowDryRun is set, that is when the
+// handler supports dry runs and reads the flag with DryRun.
+func (m *Manager) Require(operation, target string, allowDryRun bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body", "details": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var options struct {
+			DryRun bool   `json:"dry_run"`
+			Reason string `json:"reason"`
+		}
+		if len(body) > 0 {
+			json.Unmarshal(body, &options)
+		}
+		dryRun := allowDryRun && options.DryRun
+		c.Set(dryRunKey, dryRun)
+
+		if dryRun || !m.Requires(operation) || ApprovalID(c.Request.Context()) != "" {
+			c.Next()
+			return
+		}
+		if reason := c.Query("reason"); reason != "" {
+			options.Reason = reason
+		}
+
+		req := Request{
+			Operation:   operation,
+			Target:      target,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			Query:       c.Request.URL.RawQuery,
+			RequestedBy: auth.Admin(c),
+			Reason:      options.Reason,
+		}
+		if len(body) > 0 {
+			if !json.Valid(body) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": "body must be JSON"})
+				c.Abort()
+				return
+			}
+			req.Body = body
+		}
+
+		created, err := m.Submit(req)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create change request", "details": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Approval by another admin required", "approval": created})
+		c.Abort()
+	}
+}
@@ -0,0 +1,93 @@
+package approvals
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(t *testing.T, ran *bool, dryRun *bool) (*gin.Engine, *Manager) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(s, []string{OperationDeleteIdentity, OperationMergeIdentities}, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(c *gin.Context) {
+		*ran = true
+		*dryRun = DryRun(c)
+		c.Status(http.StatusOK)
+	}
+	router := gin.New()
+	router.DELETE("/identities/:id", m.Require(OperationDeleteIdentity, "default", false), handler)
+	router.POST("/identities/merge", m.Require(OperationMergeIdentities, "default", true), handler)
+	router.POST("/migrations", m.Require(OperationMigration, "default", true), handler)
+	return router, m
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantRan    bool
+		wantDryRun bool
+	}{
+		{"delete needs approval", http.MethodDelete, "/identities/abc", "", http.StatusAccepted, false, false},
+		{"delete ignores dry_run", http.MethodDelete, "/identities/abc", `{"dry_run":true}`, http.StatusAccepted, false, false},
+		{"merge needs approval", http.MethodPost, "/identities/merge", `{"winner_id":"a","loser_id":"b"}`, http.StatusAccepted, false, false},
+		{"merge dry run skips approval", http.MethodPost, "/identities/merge", `{"winner_id":"a","loser_id":"b","dry_run":true}`, http.StatusOK, true, true},
+		{"operation outside policy", http.MethodPost, "/migrations", `{"dry_run":false}`, http.StatusOK, true, false},
+		{"dry run outside policy", http.MethodPost, "/migrations", `{"dry_run":true}`, http.StatusOK, true, true},
+		{"invalid body", http.MethodPost, "/identities/merge", `{"dry_run":`, http.StatusBadRequest, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran, dryRun bool
+			router, m := newTestRouter(t, &ran, &dryRun)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if ran != tt.wantRan {
+				t.Errorf("handler ran = %v, want %v", ran, tt.wantRan)
+			}
+			if dryRun != tt.wantDryRun {
+				t.Errorf("DryRun = %v, want %v", dryRun, tt.wantDryRun)
+			}
+			if pending := len(m.List(StatusPending)); tt.wantStatus == http.StatusAccepted && pending != 1 {
+				t.Errorf("pending requests = %d, want 1", pending)
+			}
+		})
+	}
+}
+
+func TestRequireReplay(t *testing.T) {
+	var ran, dryRun bool
+	router, _ := newTestRouter(t, &ran, &dryRun)
+
+	req := httptest.NewRequest(http.MethodDelete, "/identities/abc", nil)
+	req = req.WithContext(withApproval(req.Context(), "request-id"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !ran {
+		t.Errorf("replayed request: status = %d, handler ran = %v, want 200 and true", w.Code, ran)
+	}
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"time"
 
@@ -20,7 +21,9 @@ func NewHandler(cfg *config.Config) *Handler {
 }
 
 // LoginRequest represents the login request body
+// Username is optional and defaults to the built-in "admin" user.
 type LoginRequest struct {
+	Username string `json:"username"`
 	Password string `json:"password" binding:"required"`
 }
 
@@ -30,7 +33,7 @@ type LoginResponse struct {
 	ExpiresAt int64  `json:"expires_at"`
 }
 
-// Login authenticates the admin user with a static password
+// Login authenticates the built-in admin user or a named admin with a static password
 func (h *Handler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -38,21 +41,20 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	// Validate password against the configured admin password
-	if req.Password != h.config.AdminPassword {
+	username := req.Username
+	if username == "" {
+		username = "admin"
+	}
+
+	// Validate password against the configured admin passwords
+	if !h.checkPassword(username, req.Password) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
 		return
 	}
 
 	// Generate JWT token
 	expiresAt := time.Now().Add(24 * time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": "admin",
-		"exp": expiresAt.Unix(),
-		"iat": time.Now().Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(h.config.JWTSecret))
+	tokenString, err := IssueToken(h.config.JWTSecret, username, expiresAt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -64,6 +66,28 @@ func (h *Handler) Login(c *gin.Context) {
 	})
 }
 
+// IssueToken signs a JWT authenticating username until expiresAt
+func IssueToken(jwtSecret, username string, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": username,
+		"exp": expiresAt.Unix(),
+		"iat": time.Now().Unix(),
+	})
+	return token.SignedString([]byte(jwtSecret))
+}
 
+// checkPassword compares a password with the one configured for username
+func (h *Handler) checkPassword(username, password string) bool {
+	expected := ""
+	if username == "admin" {
+		expected = h.config.AdminPassword
+	} else {
+		for _, admin := range h.config.Admins {
+			if admin.Username == username {
+				expected = admin.Password
+			}
+		}
+	}
 
-
+	return expected != "" && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
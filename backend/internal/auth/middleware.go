@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"strings"
 
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -43,11 +45,88 @@ func JWTMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		// Token is valid, continue
+		// Token is valid, remember who is calling and continue
+		subject, _ := token.Claims.GetSubject()
+		c.Set(adminKey, subject)
 		c.Next()
 	}
 }
 
+// BearerTokens creates a middleware for machine-to-machine endpoints that only accepts
+// requests carrying one of the static bearer tokens. Rejected requests get a 401 written by
+// unauthorized, or a JSON error when it is nil.
+func BearerTokens(tokens []string, unauthorized gin.HandlerFunc) gin.HandlerFunc {
+	if unauthorized == nil {
+		unauthorized = func(c *gin.Context) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing bearer token"})
+		}
+	}
+
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		for _, expected := range tokens {
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+				c.Next()
+				return
+			}
+		}
+
+		unauthorized(c)
+		c.Abort()
+	}
+}
+
+// adminKey is the gin context key holding the authenticated admin username
+const adminKey = "admin"
+
+// Admin returns the username of the admin authenticated by JWTMiddleware
+func Admin(c *gin.Context) string {
+	return c.GetString(adminKey)
+}
+
+// TargetAccess creates a middleware that checks the admin's role on a Kratos target.
+// The read role only allows safe methods.
+func TargetAccess(cfg *config.Config, target string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkRole(c, cfg, target) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// AllTargetsAccess creates a middleware that checks the admin's role on every Kratos target,
+// for settings that apply to all of them
+func AllTargetsAccess(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, target := range cfg.KratosTargets {
+			if !checkRole(c, cfg, target.Name) {
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// checkRole aborts the request with 403 if the admin's role on a target does not allow it
+func checkRole(c *gin.Context, cfg *config.Config, target string) bool {
+	switch cfg.Role(Admin(c), target) {
+	case config.RoleWrite:
+	case config.RoleRead:
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Read-only access to Kratos target", "target": target})
+			c.Abort()
+			return false
+		}
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to Kratos target", "target": target})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
 
 
 
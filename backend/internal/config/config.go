@@ -1,9 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds the application configuration
@@ -14,6 +18,155 @@ type Config struct {
 	KratosPublicURL string
 	Port            string
 	CORSOrigins     []string
+
+	// KratosTargets lists the Kratos deployments managed by this backend
+	KratosTargets []KratosTarget
+	// DefaultTarget is the name of the target used when a request does not select one
+	DefaultTarget string
+	// Admins lists named administrators in addition to the built-in "admin" user
+	Admins []Admin
+
+	// SchemaUsageCacheTTL controls how long per-schema identity counts are cached
+	SchemaUsageCacheTTL time.Duration
+	// SecurityReportCacheTTL controls how long the MFA and verification report is cached
+	SecurityReportCacheTTL time.Duration
+
+	// StatsRefreshInterval is the age from which dashboard counts are refreshed on read
+	StatsRefreshInterval time.Duration
+
+	// DataDir is the directory for locally stored data such as stats snapshots
+	DataDir string
+	// StatsSnapshotInterval controls how often identity and session counts are snapshotted
+	StatsSnapshotInterval time.Duration
+	// StatsRetention controls how long stats snapshots are kept
+	StatsRetention time.Duration
+
+	// MetricsRefreshInterval controls how often the Prometheus identity and session gauges are recomputed
+	MetricsRefreshInterval time.Duration
+
+	// SCIMTokens are the bearer tokens accepted by the SCIM endpoints, which are disabled when empty
+	SCIMTokens []string
+	// SCIMTarget is the Kratos target provisioned through SCIM
+	SCIMTarget string
+	// SCIMSchemaID is the identity schema of users provisioned through SCIM
+	SCIMSchemaID string
+	// SCIMAttributeMapping maps SCIM User attribute paths to trait JSON pointers, nil for the default mapping
+	SCIMAttributeMapping map[string]string
+
+	// KratosHookTokens are the bearer tokens accepted from Kratos web hooks, which are disabled when empty
+	KratosHookTokens []string
+	// ActivityRetention controls how long activity events reported by Kratos are kept
+	ActivityRetention time.Duration
+
+	// WebhookMaxAttempts is the number of times a webhook delivery is attempted
+	WebhookMaxAttempts int
+	// WebhookRetryBackoff is the delay before the first retry, doubled for each further one
+	WebhookRetryBackoff time.Duration
+	// WebhookTimeout bounds each webhook request
+	WebhookTimeout time.Duration
+
+	// HistoryRetention controls how long superseded identity versions are kept
+	HistoryRetention time.Duration
+	// HistoryScanInterval controls how often identities are scanned for changes made outside of the admin API
+	HistoryScanInterval time.Duration
+
+	// SoftDelete makes identity deletion deactivate and snapshot identities instead of deleting them
+	SoftDelete bool
+	// SoftDeleteRetention controls how long soft-deleted identities can be restored before they are purged
+	SoftDeleteRetention time.Duration
+	// SoftDeletePurgeInterval controls how often expired soft-deleted identities are purged
+	SoftDeletePurgeInterval time.Duration
+
+	// ApprovalPolicy lists the operations that need a second admin's approval, none when empty
+	ApprovalPolicy []string
+	// ApprovalExpiry controls how long change requests wait for a decision
+	ApprovalExpiry time.Duration
+
+	// LDAPSync configures the LDAP directory synchronization, which is disabled without a URL
+	LDAPSync LDAPSyncConfig
+
+	// ReadinessTimeout bounds each dependency check of /api/ready
+	ReadinessTimeout time.Duration
+	// ReadinessRequirePublic makes /api/ready fail when the Kratos public API is down
+	ReadinessRequirePublic bool
+	// ReadinessRequiredTargets are the targets whose checks make /api/ready fail
+	ReadinessRequiredTargets []string
+}
+
+// DefaultTargetName is the name of the target built from KRATOS_ADMIN_URL and KRATOS_PUBLIC_URL
+const DefaultTargetName = "default"
+
+// Admin roles on a Kratos target
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+)
+
+// KratosTarget describes a Kratos deployment
+type KratosTarget struct {
+	Name      string `json:"name"`
+	AdminURL  string `json:"admin_url"`
+	PublicURL string `json:"public_url"`
+	// AdminToken is sent as a bearer token to the admin API, e.g. an Ory Network API key
+	AdminToken string `json:"admin_token"`
+}
+
+// Admin is a named administrator with per-target roles.
+// Permissions maps target names, or "*" for all targets, to RoleRead or RoleWrite.
+type Admin struct {
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	Permissions map[string]string `json:"permissions"`
+}
+
+// LDAPSyncConfig describes the LDAP directory synchronized into Kratos identities
+type LDAPSyncConfig struct {
+	URL                string
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	Filter             string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// IDAttribute holds the stable identifier of a directory user, e.g. entryUUID
+	IDAttribute string
+	// AttributeMapping maps LDAP attribute names to trait JSON pointers, nil for the default mapping
+	AttributeMapping map[string]string
+
+	// Target and SchemaID select the Kratos target and identity schema of synchronized users
+	Target   string
+	SchemaID string
+	// Interval controls how often the sync runs, 0 to only run it on demand
+	Interval time.Duration
+}
+
+// Target returns the target with the given name
+func (c *Config) Target(name string) (KratosTarget, bool) {
+	for _, target := range c.KratosTargets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return KratosTarget{}, false
+}
+
+// Role returns the role of an administrator on a target, or an empty string without access.
+// The built-in "admin" user has write access to every target.
+func (c *Config) Role(username, target string) string {
+	if username == "admin" {
+		return RoleWrite
+	}
+
+	for _, admin := range c.Admins {
+		if admin.Username != username {
+			continue
+		}
+		if role, ok := admin.Permissions[target]; ok {
+			return role
+		}
+		return admin.Permissions["*"]
+	}
+	return ""
 }
 
 // Load loads the configuration from environment variables
@@ -43,9 +196,156 @@ func Load() (*Config, error) {
 		port = "8080"
 	}
 
+	targets, defaultTarget, err := loadTargets(kratosAdminURL, kratosPublicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	admins, err := loadAdmins(targets)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse CORS origins from comma-separated list
 	corsOrigins := parseCORSOrigins(os.Getenv("CORS_ORIGINS"))
 
+	schemaUsageCacheTTL, err := parseDuration("SCHEMA_USAGE_CACHE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	securityReportCacheTTL, err := parseDuration("SECURITY_REPORT_CACHE_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	statsRefreshInterval, err := parseDuration("STATS_REFRESH_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	statsSnapshotInterval, err := parseInterval("STATS_SNAPSHOT_INTERVAL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	statsRetention, err := parseDuration("STATS_RETENTION", 90*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsRefreshInterval, err := parseInterval("METRICS_REFRESH_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	scimTarget := os.Getenv("SCIM_TARGET")
+	if scimTarget == "" {
+		scimTarget = defaultTarget
+	}
+	if !hasTarget(targets, scimTarget) {
+		return nil, fmt.Errorf("SCIM_TARGET %q is not a configured target", scimTarget)
+	}
+
+	scimSchemaID := os.Getenv("SCIM_SCHEMA_ID")
+	if scimSchemaID == "" {
+		scimSchemaID = "default"
+	}
+
+	var scimMapping map[string]string
+	if path := os.Getenv("SCIM_ATTRIBUTE_MAPPING_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SCIM_ATTRIBUTE_MAPPING_FILE: %w", err)
+		}
+		if err := json.Unmarshal(data, &scimMapping); err != nil {
+			return nil, fmt.Errorf("failed to parse SCIM_ATTRIBUTE_MAPPING_FILE: %w", err)
+		}
+	}
+
+	activityRetention, err := parseDuration("ACTIVITY_RETENTION", 90*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookMaxAttempts := 8
+	if value := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); value != "" {
+		webhookMaxAttempts, err = strconv.Atoi(value)
+		if err != nil || webhookMaxAttempts < 1 {
+			return nil, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be a positive integer")
+		}
+	}
+
+	webhookRetryBackoff, err := parseDuration("WEBHOOK_RETRY_BACKOFF", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookTimeout, err := parseDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	historyRetention, err := parseDuration("HISTORY_RETENTION", 365*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	historyScanInterval, err := parseDuration("HISTORY_SCAN_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	softDelete, err := parseBool("SOFT_DELETE", false)
+	if err != nil {
+		return nil, err
+	}
+
+	softDeleteRetention, err := parseDuration("SOFT_DELETE_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	softDeletePurgeInterval, err := parseInterval("SOFT_DELETE_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	approvalExpiry, err := parseDuration("APPROVAL_EXPIRY", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	ldapSync, err := loadLDAPSync(targets, defaultTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	readinessTimeout, err := parseDuration("READINESS_TIMEOUT", 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	readinessRequirePublic, err := parseBool("READINESS_REQUIRE_PUBLIC", true)
+	if err != nil {
+		return nil, err
+	}
+
+	readinessRequiredTargets := splitList(os.Getenv("READINESS_REQUIRED_TARGETS"))
+	if len(readinessRequiredTargets) == 0 {
+		readinessRequiredTargets = []string{defaultTarget}
+	}
+	for _, name := range readinessRequiredTargets {
+		if !hasTarget(targets, name) {
+			return nil, fmt.Errorf("READINESS_REQUIRED_TARGETS: %q is not a configured target", name)
+		}
+	}
+
 	return &Config{
 		AdminPassword:   adminPassword,
 		JWTSecret:       jwtSecret,
@@ -53,9 +353,217 @@ func Load() (*Config, error) {
 		KratosPublicURL: kratosPublicURL,
 		Port:            port,
 		CORSOrigins:     corsOrigins,
+
+		KratosTargets: targets,
+		DefaultTarget: defaultTarget,
+		Admins:        admins,
+
+		SchemaUsageCacheTTL:    schemaUsageCacheTTL,
+		SecurityReportCacheTTL: securityReportCacheTTL,
+
+		StatsRefreshInterval: statsRefreshInterval,
+
+		DataDir:               dataDir,
+		StatsSnapshotInterval: statsSnapshotInterval,
+		StatsRetention:        statsRetention,
+
+		MetricsRefreshInterval: metricsRefreshInterval,
+
+		SCIMTokens:           splitList(os.Getenv("SCIM_TOKENS")),
+		SCIMTarget:           scimTarget,
+		SCIMSchemaID:         scimSchemaID,
+		SCIMAttributeMapping: scimMapping,
+
+		KratosHookTokens:  splitList(os.Getenv("KRATOS_HOOK_TOKENS")),
+		ActivityRetention: activityRetention,
+
+		WebhookMaxAttempts:  webhookMaxAttempts,
+		WebhookRetryBackoff: webhookRetryBackoff,
+		WebhookTimeout:      webhookTimeout,
+
+		HistoryRetention:    historyRetention,
+		HistoryScanInterval: historyScanInterval,
+
+		SoftDelete:              softDelete,
+		SoftDeleteRetention:     softDeleteRetention,
+		SoftDeletePurgeInterval: softDeletePurgeInterval,
+
+		ApprovalPolicy: splitList(os.Getenv("APPROVAL_POLICY")),
+		ApprovalExpiry: approvalExpiry,
+
+		LDAPSync: ldapSync,
+
+		ReadinessTimeout:         readinessTimeout,
+		ReadinessRequirePublic:   readinessRequirePublic,
+		ReadinessRequiredTargets: readinessRequiredTargets,
 	}, nil
 }
 
+// loadTargets reads the Kratos targets from the JSON file named by KRATOS_TARGETS_FILE.
+// Without it, a single "default" target is built from the Kratos URLs and KRATOS_ADMIN_TOKEN.
+func loadTargets(adminURL, publicURL string) ([]KratosTarget, string, error) {
+	path := os.Getenv("KRATOS_TARGETS_FILE")
+	if path == "" {
+		return []KratosTarget{{
+			Name:       DefaultTargetName,
+			AdminURL:   adminURL,
+			PublicURL:  publicURL,
+			AdminToken: os.Getenv("KRATOS_ADMIN_TOKEN"),
+		}}, DefaultTargetName, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read KRATOS_TARGETS_FILE: %w", err)
+	}
+
+	var targets []KratosTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, "", fmt.Errorf("failed to parse KRATOS_TARGETS_FILE: %w", err)
+	}
+	if len(targets) == 0 {
+		return nil, "", errors.New("KRATOS_TARGETS_FILE must list at least one target")
+	}
+
+	seen := map[string]bool{}
+	for _, target := range targets {
+		if target.Name == "" || strings.ContainsAny(target.Name, "/ ") {
+			return nil, "", fmt.Errorf("invalid Kratos target name %q", target.Name)
+		}
+		if seen[target.Name] {
+			return nil, "", fmt.Errorf("duplicate Kratos target %q", target.Name)
+		}
+		if target.AdminURL == "" {
+			return nil, "", fmt.Errorf("Kratos target %q has no admin_url", target.Name)
+		}
+		seen[target.Name] = true
+	}
+
+	defaultTarget := os.Getenv("KRATOS_DEFAULT_TARGET")
+	if defaultTarget == "" {
+		defaultTarget = targets[0].Name
+	}
+	if !seen[defaultTarget] {
+		return nil, "", fmt.Errorf("KRATOS_DEFAULT_TARGET %q is not a configured target", defaultTarget)
+	}
+
+	return targets, defaultTarget, nil
+}
+
+// hasTarget reports whether a target with the given name is configured
+func hasTarget(targets []KratosTarget, name string) bool {
+	for _, target := range targets {
+		if target.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadLDAPSync reads the LDAP synchronization settings from LDAP_* variables
+func loadLDAPSync(targets []KratosTarget, defaultTarget string) (LDAPSyncConfig, error) {
+	cfg := LDAPSyncConfig{
+		URL:          os.Getenv("LDAP_URL"),
+		BindDN:       os.Getenv("LDAP_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_BASE_DN"),
+		Filter:       os.Getenv("LDAP_USER_FILTER"),
+		IDAttribute:  os.Getenv("LDAP_ID_ATTRIBUTE"),
+		Target:       os.Getenv("LDAP_SYNC_TARGET"),
+		SchemaID:     os.Getenv("LDAP_SYNC_SCHEMA_ID"),
+	}
+	if cfg.URL == "" {
+		return cfg, nil
+	}
+
+	if cfg.BaseDN == "" {
+		return cfg, errors.New("LDAP_BASE_DN is required when LDAP_URL is set")
+	}
+	if cfg.Filter == "" {
+		cfg.Filter = "(objectClass=inetOrgPerson)"
+	}
+	if cfg.IDAttribute == "" {
+		cfg.IDAttribute = "entryUUID"
+	}
+	if cfg.SchemaID == "" {
+		cfg.SchemaID = "default"
+	}
+	if cfg.Target == "" {
+		cfg.Target = defaultTarget
+	}
+	if !hasTarget(targets, cfg.Target) {
+		return cfg, fmt.Errorf("LDAP_SYNC_TARGET %q is not a configured target", cfg.Target)
+	}
+
+	var err error
+	if cfg.StartTLS, err = parseBool("LDAP_START_TLS", false); err != nil {
+		return cfg, err
+	}
+	if cfg.InsecureSkipVerify, err = parseBool("LDAP_INSECURE_SKIP_VERIFY", false); err != nil {
+		return cfg, err
+	}
+	if cfg.Interval, err = parseDuration("LDAP_SYNC_INTERVAL", time.Hour); err != nil {
+		return cfg, err
+	}
+
+	if path := os.Getenv("LDAP_ATTRIBUTE_MAPPING_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read LDAP_ATTRIBUTE_MAPPING_FILE: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg.AttributeMapping); err != nil {
+			return cfg, fmt.Errorf("failed to parse LDAP_ATTRIBUTE_MAPPING_FILE: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadAdmins reads the named administrators from the JSON file named by ADMINS_FILE, if set
+func loadAdmins(targets []KratosTarget) ([]Admin, error) {
+	path := os.Getenv("ADMINS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ADMINS_FILE: %w", err)
+	}
+
+	var admins []Admin
+	if err := json.Unmarshal(data, &admins); err != nil {
+		return nil, fmt.Errorf("failed to parse ADMINS_FILE: %w", err)
+	}
+
+	known := map[string]bool{"*": true}
+	for _, target := range targets {
+		known[target.Name] = true
+	}
+
+	seen := map[string]bool{}
+	for _, admin := range admins {
+		if admin.Username == "" || admin.Username == "admin" || admin.Password == "" {
+			return nil, fmt.Errorf("invalid admin %q: a username other than \"admin\" and a password are required", admin.Username)
+		}
+		if seen[admin.Username] {
+			return nil, fmt.Errorf("duplicate admin %q", admin.Username)
+		}
+		seen[admin.Username] = true
+
+		for target, role := range admin.Permissions {
+			if !known[target] {
+				return nil, fmt.Errorf("admin %q has permissions on unknown target %q", admin.Username, target)
+			}
+			if role != RoleRead && role != RoleWrite {
+				return nil, fmt.Errorf("invalid role %q for admin %q on target %q", role, admin.Username, target)
+			}
+		}
+	}
+
+	return admins, nil
+}
+
 // parseCORSOrigins parses a comma-separated list of origins
 // Returns wildcard "*" if empty (allow all origins)
 func parseCORSOrigins(origins string) []string {
@@ -64,8 +572,12 @@ func parseCORSOrigins(origins string) []string {
 		return []string{"*"}
 	}
 
-	// Split by comma and trim whitespace
-	parts := strings.Split(origins, ",")
+	return splitList(origins)
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping empty entries
+func splitList(value string) []string {
+	parts := strings.Split(value, ",")
 	result := make([]string, 0, len(parts))
 	for _, part := range parts {
 		trimmed := strings.TrimSpace(part)
@@ -76,3 +588,57 @@ func parseCORSOrigins(origins string) []string {
 	return result
 }
 
+// parseDuration reads a duration such as "30s", "5m" or "90d" from an environment variable
+// Returns the fallback if the variable is empty
+func parseDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration such as 30s, 5m or 90d: %w", name, err)
+	}
+	return d, nil
+}
+
+// parseInterval reads a duration like parseDuration, for the period of a background loop,
+// which must be positive
+func parseInterval(name string, fallback time.Duration) (time.Duration, error) {
+	d, err := parseDuration(name, fallback)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration", name)
+	}
+	return d, nil
+}
+
+// ParseDuration parses a duration such as "30s", "5m" or "90d"
+func ParseDuration(value string) (time.Duration, error) {
+	// time.ParseDuration has no unit for days
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// parseBool reads a boolean environment variable
+func parseBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false: %w", name, err)
+	}
+	return b, nil
+}
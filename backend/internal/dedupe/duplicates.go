@@ -0,0 +1,384 @@
+package dedupe
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	ory "github.com/ory/kratos-client-go"
+)
+
+// Kinds of matches between duplicate candidates
+const (
+	MatchEmail = "email"
+	MatchPhone = "phone"
+	MatchName  = "name"
+)
+
+// DefaultMinNameScore is the name similarity from which two identities are candidates
+const DefaultMinNameScore = 0.9
+
+// minPhoneDigits skips values too short to be phone numbers
+const minPhoneDigits = 7
+
+// MaxNameBucket is the most names starting alike that are compared pairwise. Larger buckets
+// are skipped, as comparing them takes quadratic time.
+const MaxNameBucket = 1000
+
+// nameKeys are the trait names holding a person's name or a part of it
+var nameKeys = map[string]bool{
+	"name": true, "full_name": true, "fullname": true, "display_name": true,
+	"first": true, "first_name": true, "firstname": true, "given_name": true, "given": true,
+	"last": true, "last_name": true, "lastname": true, "family_name": true, "family": true, "surname": true,
+	"middle": true, "middle_name": true,
+}
+
+// phoneKeys are substrings of the trait names holding phone numbers
+var phoneKeys = []string{"phone", "mobile", "tel"}
+
+// Options select how duplicates are detected
+type Options struct {
+	// By lists the kinds of matches to look for, every kind when empty
+	By []string
+	// MinNameScore is the similarity between 0 and 1 from which names match
+	MinNameScore float64
+}
+
+// Candidate is an identity of a duplicate group
+type Candidate struct {
+	ID          string      `json:"id"`
+	SchemaID    string      `json:"schema_id"`
+	State       string      `json:"state,omitempty"`
+	CreatedAt   *time.Time  `json:"created_at,omitempty"`
+	Traits      interface{} `json:"traits"`
+	Credentials []string    `json:"credentials"`
+}
+
+// Match explains why two identities are duplicate candidates
+type Match struct {
+	IdentityIDs [2]string `json:"identity_ids"`
+	Kind        string    `json:"kind"`
+	// Value is the normalized email address, phone number or name both identities share
+	Value string `json:"value"`
+	// Score is the similarity of the names, 1 for emails and phone numbers
+	Score float64 `json:"score"`
+}
+
+// Group is a set of identities that likely belong to the same person
+type Group struct {
+	Identities []Candidate `json:"identities"`
+	Matches    []Match     `json:"matches"`
+}
+
+// profile holds the normalized values of an identity used for matching
+type profile struct {
+	index  int
+	emails []string
+	phones []string
+	name   string
+}
+
+// Find groups identities sharing an email address or phone number, or with similar names.
+// Emails are compared case-insensitively and without +tags, phone numbers by their digits.
+// Groups with the most identities come first. It also returns the first two letters of the
+// names skipped because more than MaxNameBucket names start with them.
+func Find(identities []ory.Identity, opts Options) ([]Group, []string) {
+	by := map[string]bool{}
+	for _, kind := range opts.By {
+		by[kind] = true
+	}
+	all := len(by) == 0
+	if opts.MinNameScore <= 0 {
+		opts.MinNameScore = DefaultMinNameScore
+	}
+
+	profiles := make([]profile, len(identities))
+	for i := range identities {
+		profiles[i] = profileOf(i, &identities[i])
+	}
+
+	var matches []Match
+	skipped := []string{}
+	pair := func(a, b int, kind, value string, score float64) {
+		matches = append(matches, Match{
+			IdentityIDs: [2]string{identities[a].Id, identities[b].Id},
+			Kind:        kind,
+			Value:       value,
+			Score:       score,
+		})
+	}
+
+	if all || by[MatchEmail] {
+		for value, indexes := range index(profiles, func(p profile) []string { return p.emails }) {
+			for _, other := range indexes[1:] {
+				pair(indexes[0], other, MatchEmail, value, 1)
+			}
+		}
+	}
+	if all || by[MatchPhone] {
+		for value, indexes := range index(profiles, func(p profile) []string { return p.phones }) {
+			for _, other := range indexes[1:] {
+				pair(indexes[0], other, MatchPhone, value, 1)
+			}
+		}
+	}
+	if all || by[MatchName] {
+		// Only names starting alike are compared, to avoid comparing every pair of identities
+		buckets := map[string][]int{}
+		for _, p := range profiles {
+			if runes := []rune(p.name); len(runes) >= 2 {
+				buckets[string(runes[:2])] = append(buckets[string(runes[:2])], p.index)
+			}
+		}
+		for prefix, bucket := range buckets {
+			if len(bucket) > MaxNameBucket {
+				skipped = append(skipped, prefix)
+				continue
+			}
+			for i := 0; i < len(bucket); i++ {
+				for j := i + 1; j < len(bucket); j++ {
+					a, b := profiles[bucket[i]].name, profiles[bucket[j]].name
+					if score := similarity(a, b); score >= opts.MinNameScore {
+						pair(bucket[i], bucket[j], MatchName, a, score)
+					}
+				}
+			}
+		}
+	}
+
+	sort.Strings(skipped)
+	return group(identities, matches), skipped
+}
+
+// group joins the matched identities into groups
+func group(identities []ory.Identity, matches []Match) []Group {
+	positions := make(map[string]int, len(identities))
+	parent := make([]int, len(identities))
+	for i := range identities {
+		positions[identities[i].Id] = i
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for _, m := range matches {
+		a, b := root(positions[m.IdentityIDs[0]]), root(positions[m.IdentityIDs[1]])
+		if a != b {
+			parent[b] = a
+		}
+	}
+
+	byRoot := map[int]*Group{}
+	var groups []*Group
+	for _, m := range matches {
+		r := root(positions[m.IdentityIDs[0]])
+		g, ok := byRoot[r]
+		if !ok {
+			g = &Group{}
+			byRoot[r] = g
+			groups = append(groups, g)
+		}
+		g.Matches = append(g.Matches, m)
+	}
+	for i := range identities {
+		if g, ok := byRoot[root(i)]; ok {
+			g.Identities = append(g.Identities, candidateOf(&identities[i]))
+		}
+	}
+
+	result := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		sort.Slice(g.Identities, func(i, j int) bool { return g.Identities[i].ID < g.Identities[j].ID })
+		sort.Slice(g.Matches, func(i, j int) bool {
+			if g.Matches[i].Kind != g.Matches[j].Kind {
+				return g.Matches[i].Kind < g.Matches[j].Kind
+			}
+			return g.Matches[i].Value < g.Matches[j].Value
+		})
+		result = append(result, *g)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if len(result[i].Identities) != len(result[j].Identities) {
+			return len(result[i].Identities) > len(result[j].Identities)
+		}
+		return result[i].Identities[0].ID < result[j].Identities[0].ID
+	})
+	return result
+}
+
+// index maps every value to the profiles having it, in profile order
+func index(profiles []profile, values func(profile) []string) map[string][]int {
+	result := map[string][]int{}
+	for _, p := range profiles {
+		for _, value := range values(p) {
+			result[value] = append(result[value], p.index)
+		}
+	}
+	for value, indexes := range result {
+		if len(indexes) < 2 {
+			delete(result, value)
+		}
+	}
+	return result
+}
+
+// profileOf extracts the normalized email addresses, phone numbers and name from the traits.
+// Name parts are joined in the order of their trait names.
+func profileOf(i int, identity *ory.Identity) profile {
+	p := profile{index: i}
+	traits, _ := identity.Traits.(map[string]interface{})
+
+	var nameParts []string
+	seen := map[string]bool{}
+	walk("", traits, func(key, value string) {
+		if email := normalizeEmail(value); email != "" {
+			if !seen[email] {
+				seen[email] = true
+				p.emails = append(p.emails, email)
+			}
+			return
+		}
+		lower := strings.ToLower(key)
+		for _, k := range phoneKeys {
+			if strings.Contains(lower, k) {
+				if phone := normalizePhone(value); phone != "" && !seen[phone] {
+					seen[phone] = true
+					p.phones = append(p.phones, phone)
+				}
+				return
+			}
+		}
+		if nameKeys[lower] {
+			nameParts = append(nameParts, value)
+		}
+	})
+	p.name = normalizeName(strings.Join(nameParts, " "))
+	return p
+}
+
+// walk calls fn with every string of the traits and the name of the trait holding it, in key order
+func walk(key string, value interface{}, fn func(key, value string)) {
+	switch v := value.(type) {
+	case string:
+		fn(key, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walk(k, v[k], fn)
+		}
+	case []interface{}:
+		for _, item := range v {
+			walk(key, item, fn)
+		}
+	}
+}
+
+// normalizeEmail lowercases an email address and drops its +tag, or returns "" if value is not one
+func normalizeEmail(value string) string {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "@") {
+		return ""
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return ""
+	}
+
+	at := strings.LastIndex(value, "@")
+	local, domain := strings.ToLower(value[:at]), strings.ToLower(value[at+1:])
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	return local + "@" + domain
+}
+
+// normalizePhone keeps the digits of a phone number, with international prefixes as "+"
+func normalizePhone(value string) string {
+	var b strings.Builder
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "+") {
+		b.WriteByte('+')
+	} else if strings.HasPrefix(value, "00") {
+		b.WriteByte('+')
+		value = value[2:]
+	}
+	digits := 0
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			digits++
+		}
+	}
+	if digits < minPhoneDigits {
+		return ""
+	}
+	return b.String()
+}
+
+// normalizeName lowercases a name, keeps its letters and sorts its words, so "Doe, Jane" matches "jane doe"
+func normalizeName(value string) string {
+	words := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool { return !unicode.IsLetter(r) })
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// similarity returns 1 minus the Levenshtein distance of two strings relative to the longest one
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(rb)])/float64(longest)
+}
+
+// candidateOf summarizes an identity of a duplicate group
+func candidateOf(identity *ory.Identity) Candidate {
+	c := Candidate{
+		ID:          identity.Id,
+		SchemaID:    identity.SchemaId,
+		CreatedAt:   identity.CreatedAt,
+		Traits:      identity.Traits,
+		Credentials: []string{},
+	}
+	if identity.State != nil {
+		c.State = string(*identity.State)
+	}
+	if identity.Credentials != nil {
+		for credType := range *identity.Credentials {
+			c.Credentials = append(c.Credentials, credType)
+		}
+		sort.Strings(c.Credentials)
+	}
+	return c
+}
@@ -0,0 +1,178 @@
+package dedupe
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	ory "github.com/ory/kratos-client-go"
+)
+
+// identity returns an identity of the default schema with traits given as JSON
+func identity(t *testing.T, id, traits string) ory.Identity {
+	t.Helper()
+	var decoded map[string]interface{}
+	decode(t, traits, &decoded)
+	return ory.Identity{Id: id, SchemaId: "default", Traits: decoded}
+}
+
+// groupIDs returns the identity IDs of each group
+func groupIDs(groups []Group) [][]string {
+	ids := [][]string{}
+	for _, g := range groups {
+		var group []string
+		for _, c := range g.Identities {
+			group = append(group, c.ID)
+		}
+		ids = append(ids, group)
+	}
+	return ids
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name       string
+		identities map[string]string
+		opts       Options
+		want       [][]string
+		wantKind   string
+		wantValue  string
+	}{
+		{
+			name: "emails ignore case and +tags",
+			identities: map[string]string{
+				"a": `{"email":"Jane.Doe+news@Example.org"}`,
+				"b": `{"email":"jane.doe@example.org"}`,
+				"c": `{"email":"john@example.org"}`,
+			},
+			want:      [][]string{{"a", "b"}},
+			wantKind:  MatchEmail,
+			wantValue: "jane.doe@example.org",
+		},
+		{
+			name: "emails in nested traits and lists",
+			identities: map[string]string{
+				"a": `{"contact":{"emails":["x@example.org","jane@example.org"]}}`,
+				"b": `{"email":"jane@example.org"}`,
+			},
+			want:      [][]string{{"a", "b"}},
+			wantKind:  MatchEmail,
+			wantValue: "jane@example.org",
+		},
+		{
+			name: "phone numbers by their digits",
+			identities: map[string]string{
+				"a": `{"phone":"+33 6 12 34 56 78"}`,
+				"b": `{"mobile_number":"0033612345678"}`,
+				"c": `{"phone":"06 12 34 56 78"}`,
+			},
+			want:      [][]string{{"a", "b"}},
+			wantKind:  MatchPhone,
+			wantValue: "+33612345678",
+		},
+		{
+			name: "short numbers and numbers outside phone traits are ignored",
+			identities: map[string]string{
+				"a": `{"phone":"12345","employee_id":"33612345678"}`,
+				"b": `{"phone":"12345","employee_id":"33612345678"}`,
+			},
+			want: [][]string{},
+		},
+		{
+			name: "names in any order",
+			identities: map[string]string{
+				"a": `{"name":"Doe, Jane"}`,
+				"b": `{"name":{"first":"Jane","last":"Doe"}}`,
+			},
+			want:      [][]string{{"a", "b"}},
+			wantKind:  MatchName,
+			wantValue: "doe jane",
+		},
+		{
+			name: "similar names",
+			identities: map[string]string{
+				"a": `{"name":{"first":"Jonathan","last":"Smithson"}}`,
+				"b": `{"name":{"first":"Jonathan","last":"Smithsen"}}`,
+				"c": `{"name":{"first":"Jonathan","last":"Simpson"}}`,
+			},
+			want:      [][]string{{"a", "b"}},
+			wantKind:  MatchName,
+			wantValue: "jonathan smithson",
+		},
+		{
+			name: "names are only compared within their bucket",
+			identities: map[string]string{
+				"a": `{"name":"Jane Doe"}`,
+				"b": `{"name":"Jane Poe"}`,
+			},
+			opts: Options{MinNameScore: 0.1},
+			want: [][]string{},
+		},
+		{
+			name: "only the selected kinds",
+			identities: map[string]string{
+				"a": `{"email":"jane@example.org","name":"Jane Doe"}`,
+				"b": `{"email":"j.doe@example.org","name":"Jane Doe"}`,
+			},
+			opts: Options{By: []string{MatchEmail, MatchPhone}},
+			want: [][]string{},
+		},
+		{
+			name: "matches join groups and larger groups come first",
+			identities: map[string]string{
+				"a": `{"email":"jane@example.org"}`,
+				"b": `{"email":"jane@example.org","phone":"+33612345678"}`,
+				"c": `{"phone":"+33612345678"}`,
+				"d": `{"email":"john@example.org"}`,
+				"e": `{"email":"John@example.org"}`,
+			},
+			want: [][]string{{"a", "b", "c"}, {"d", "e"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var identities []ory.Identity
+			for _, id := range []string{"a", "b", "c", "d", "e"} {
+				if traits, ok := tt.identities[id]; ok {
+					identities = append(identities, identity(t, id, traits))
+				}
+			}
+
+			groups, skipped := Find(identities, tt.opts)
+			if len(skipped) != 0 {
+				t.Errorf("skipped = %v, want none", skipped)
+			}
+			if got := groupIDs(groups); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("groups = %v, want %v", got, tt.want)
+			}
+			if tt.wantKind == "" {
+				return
+			}
+			match := groups[0].Matches[0]
+			if len(groups[0].Matches) != 1 || match.Kind != tt.wantKind || match.Value != tt.wantValue {
+				t.Errorf("matches = %+v, want one %s match on %q", groups[0].Matches, tt.wantKind, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestFindSkipsLargeNameBuckets(t *testing.T) {
+	var identities []ory.Identity
+	for i := 0; i <= MaxNameBucket; i++ {
+		identities = append(identities, identity(t, fmt.Sprintf("aa-%d", i), `{"name":"Aaron Doe"}`))
+	}
+	identities = append(identities,
+		identity(t, "b1", `{"name":"Jane Doe"}`),
+		identity(t, "b2", `{"name":"Jane Doe"}`),
+		identity(t, "c1", `{"name":"Aaron Doe","email":"aaron@example.org"}`),
+		identity(t, "c2", `{"email":"aaron@example.org"}`),
+	)
+
+	groups, skipped := Find(identities, Options{})
+	if !reflect.DeepEqual(skipped, []string{"aa"}) {
+		t.Errorf("skipped = %v, want [aa]", skipped)
+	}
+	if got := groupIDs(groups); !reflect.DeepEqual(got, [][]string{{"b1", "b2"}, {"c1", "c2"}}) {
+		t.Errorf("groups = %v, want the Jane Doe name match and the email match", got)
+	}
+}
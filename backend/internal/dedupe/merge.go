@@ -0,0 +1,486 @@
+package dedupe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// Trait rules of a merge
+const (
+	// RuleWinner keeps the winner's value and only takes the loser's where the winner has none
+	RuleWinner = "winner"
+	// RuleLoser takes the loser's value and keeps the winner's where the loser has none
+	RuleLoser = "loser"
+	// RuleUnion combines the items of lists from both identities, other values follow RuleWinner
+	RuleUnion = "union"
+)
+
+// What happens to the loser of a merge
+const (
+	LoserDelete     = "delete"
+	LoserDeactivate = "deactivate"
+)
+
+// ErrInvalidPlan is returned for merges that cannot be prepared as requested, such as merging an
+// identity with itself, unknown rules or traits that cannot be combined
+var ErrInvalidPlan = errors.New("invalid merge")
+
+// Rules decide which identity each trait is taken from
+type Rules struct {
+	// Default is the rule of traits without their own rule, RuleWinner when empty
+	Default string `json:"default"`
+	// Fields sets the rule of the traits at JSON pointers relative to the traits, e.g. "/name".
+	// A rule applies to the values nested below its pointer.
+	Fields map[string]string `json:"fields"`
+}
+
+// rule returns the rule of the trait at pointer
+func (r Rules) rule(pointer string) string {
+	for p := pointer; p != ""; p = p[:strings.LastIndex(p, "/")] {
+		if rule, ok := r.Fields[p]; ok {
+			return rule
+		}
+	}
+	if r.Default == "" {
+		return RuleWinner
+	}
+	return r.Default
+}
+
+// validate checks the rule names
+func (r Rules) validate() error {
+	for pointer, rule := range r.Fields {
+		if !isRule(rule) {
+			return fmt.Errorf("unknown rule %q for %s", rule, pointer)
+		}
+		if !strings.HasPrefix(pointer, "/") {
+			return fmt.Errorf("%q is not a JSON pointer", pointer)
+		}
+	}
+	if r.Default != "" && !isRule(r.Default) {
+		return fmt.Errorf("unknown default rule %q", r.Default)
+	}
+	return nil
+}
+
+func isRule(rule string) bool {
+	return rule == RuleWinner || rule == RuleLoser || rule == RuleUnion
+}
+
+// CombineTraits merges the traits of two identities leaf by leaf following the rules.
+// Lists are handled as a whole. Neither input is modified.
+func CombineTraits(winner, loser map[string]interface{}, rules Rules) (map[string]interface{}, error) {
+	if err := rules.validate(); err != nil {
+		return nil, err
+	}
+
+	from, to := map[string]interface{}{}, map[string]interface{}{}
+	leaves("", winner, from)
+	leaves("", loser, to)
+
+	pointers := make([]string, 0, len(from)+len(to))
+	for pointer := range from {
+		pointers = append(pointers, pointer)
+	}
+	for pointer := range to {
+		if _, ok := from[pointer]; !ok {
+			pointers = append(pointers, pointer)
+		}
+	}
+	sort.Strings(pointers)
+
+	combined := map[string]interface{}{}
+	for _, pointer := range pointers {
+		w, l := from[pointer], to[pointer]
+		value := w
+		switch rules.rule(pointer) {
+		case RuleLoser:
+			value = l
+			if isEmpty(l) {
+				value = w
+			}
+		case RuleUnion:
+			wl, wok := w.([]interface{})
+			ll, lok := l.([]interface{})
+			if wok && lok {
+				value = union(wl, ll)
+				break
+			}
+			fallthrough
+		default:
+			if isEmpty(w) {
+				value = l
+			}
+		}
+		if err := schema.SetPointer(combined, pointer, value); err != nil {
+			return nil, fmt.Errorf("traits of the identities have different shapes: %w", err)
+		}
+	}
+
+	// Round-trip through JSON so the result shares nothing with the inputs
+	data, err := json.Marshal(combined)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	return result, json.Unmarshal(data, &result)
+}
+
+// leaves collects the non-object values of traits keyed by JSON pointer
+func leaves(pointer string, traits map[string]interface{}, out map[string]interface{}) {
+	for key, value := range traits {
+		child := schema.JoinPointer(pointer, key)
+		if obj, ok := value.(map[string]interface{}); ok && len(obj) > 0 {
+			leaves(child, obj, out)
+			continue
+		}
+		out[child] = value
+	}
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// union returns the items of a followed by the items of b that a does not have
+func union(a, b []interface{}) []interface{} {
+	result := append([]interface{}{}, a...)
+	for _, item := range b {
+		found := false
+		for _, existing := range result {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Plan describes a merge of the loser into the winner
+type Plan struct {
+	WinnerID string
+	LoserID  string
+	Rules    Rules
+	// LoserAction is LoserDelete or LoserDeactivate
+	LoserAction string
+}
+
+// Merge is a prepared merge, ready to be checked and executed
+type Merge struct {
+	Plan     Plan                   `json:"-"`
+	SchemaID string                 `json:"schema_id"`
+	Traits   map[string]interface{} `json:"traits"`
+	// Changes lists the traits of the winner the merge changes
+	Changes []schema.FieldChange `json:"changes"`
+	// MovedCredentials are the loser's credential types the winner gets. The others stay with the
+	// loser, and are lost if it is deleted.
+	MovedCredentials    []string `json:"moved_credentials"`
+	NotMovedCredentials []string `json:"not_moved_credentials"`
+	LoserAction         string   `json:"loser_action"`
+
+	winner, loser  *ory.Identity
+	metadataPublic interface{}
+	metadataAdmin  map[string]interface{}
+	credentials    *ory.IdentityWithCredentials
+}
+
+// Result is the outcome of a merge
+type Result struct {
+	*Merge
+	// Winner is the updated winner, nil for dry runs
+	Winner *ory.Identity `json:"winner,omitempty"`
+	// Loser is the deactivated loser, nil when it was deleted
+	Loser *ory.Identity `json:"loser,omitempty"`
+	// PurgeAt is when a loser deleted into the trash is purged, nil when there is no trash
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+	DryRun  bool       `json:"dry_run,omitempty"`
+}
+
+// Merger merges duplicate identities of a Kratos target
+type Merger struct {
+	client *kratos.Client
+	trash  *trash.Bin
+}
+
+// NewMerger creates a new merger. Deleted losers go to bin when it is not nil.
+func NewMerger(client *kratos.Client, bin *trash.Bin) *Merger {
+	return &Merger{client: client, trash: bin}
+}
+
+// Prepare fetches both identities and computes the merged traits, metadata and credentials.
+// Kratos identifiers are unique, so credentials only move when the loser is deleted: the
+// password hash when the winner has no password, and OIDC links.
+func (m *Merger) Prepare(ctx context.Context, plan Plan) (*Merge, error) {
+	if plan.WinnerID == plan.LoserID {
+		return nil, fmt.Errorf("%w: cannot merge an identity with itself", ErrInvalidPlan)
+	}
+	if plan.LoserAction == "" {
+		plan.LoserAction = LoserDelete
+	}
+	if plan.LoserAction != LoserDelete && plan.LoserAction != LoserDeactivate {
+		return nil, fmt.Errorf("%w: unknown loser action %q", ErrInvalidPlan, plan.LoserAction)
+	}
+
+	winner, err := m.client.GetIdentityWithCredentials(ctx, plan.WinnerID)
+	if err != nil {
+		return nil, fmt.Errorf("winner: %w", err)
+	}
+	loser, err := m.client.GetIdentityWithCredentials(ctx, plan.LoserID)
+	if err != nil {
+		return nil, fmt.Errorf("loser: %w", err)
+	}
+
+	winnerTraits, _ := winner.Traits.(map[string]interface{})
+	loserTraits, _ := loser.Traits.(map[string]interface{})
+	traits, err := CombineTraits(winnerTraits, loserTraits, plan.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPlan, err)
+	}
+
+	merge := &Merge{
+		Plan:                plan,
+		SchemaID:            winner.SchemaId,
+		Traits:              traits,
+		Changes:             schema.DiffTraits(winnerTraits, traits),
+		MovedCredentials:    []string{},
+		NotMovedCredentials: []string{},
+		LoserAction:         plan.LoserAction,
+		winner:              winner,
+		loser:               loser,
+		metadataPublic:      combineMetadata(winner.MetadataPublic, loser.MetadataPublic),
+	}
+
+	// The winner records which identities were merged into it, for systems referencing them
+	admin, _ := combineMetadata(winner.MetadataAdmin, loser.MetadataAdmin).(map[string]interface{})
+	if admin == nil {
+		admin = map[string]interface{}{}
+	}
+	merged, _ := admin["merged_identities"].([]interface{})
+	admin["merged_identities"] = union(merged, []interface{}{loser.Id})
+	merge.metadataAdmin = admin
+
+	m.planCredentials(merge)
+	return merge, nil
+}
+
+// planCredentials decides which credentials of the loser move to the winner
+func (m *Merger) planCredentials(merge *Merge) {
+	loserCreds, exported, unsupported := kratos.ExportCredentials(merge.loser)
+	if merge.LoserAction != LoserDelete {
+		merge.NotMovedCredentials = append(append(merge.NotMovedCredentials, exported...), unsupported...)
+		sort.Strings(merge.NotMovedCredentials)
+		return
+	}
+	merge.NotMovedCredentials = append(merge.NotMovedCredentials, unsupported...)
+
+	winnerCreds, _, _ := kratos.ExportCredentials(merge.winner)
+	creds := &ory.IdentityWithCredentials{}
+	for _, credType := range exported {
+		switch credType {
+		case "password":
+			if hasCredential(merge.winner, "password") {
+				merge.NotMovedCredentials = append(merge.NotMovedCredentials, credType)
+				continue
+			}
+			creds.Password = loserCreds.Password
+		case "oidc":
+			// Send the winner's links along in case Kratos replaces them
+			var providers []ory.IdentityWithCredentialsOidcConfigProvider
+			if winnerCreds != nil && winnerCreds.Oidc != nil {
+				providers = append(providers, winnerCreds.Oidc.Config.Providers...)
+			}
+			for _, provider := range loserCreds.Oidc.Config.Providers {
+				if !containsProvider(providers, provider) {
+					providers = append(providers, provider)
+				}
+			}
+			creds.Oidc = &ory.IdentityWithCredentialsOidc{
+				Config: &ory.IdentityWithCredentialsOidcConfig{Providers: providers},
+			}
+		}
+		merge.MovedCredentials = append(merge.MovedCredentials, credType)
+	}
+	sort.Strings(merge.NotMovedCredentials)
+
+	if len(merge.MovedCredentials) > 0 {
+		merge.credentials = creds
+	}
+}
+
+// Execute revokes the loser's sessions, deletes or deactivates it and then updates the winner.
+// The loser goes first so its identifiers are free for the winner. If the winner cannot be
+// updated, a deleted loser is created again, with a new ID, and a deactivated one reactivated.
+// With a trash, the deleted loser is kept there and can be restored until it is purged.
+func (m *Merger) Execute(ctx context.Context, merge *Merge, admin string) (*Result, error) {
+	loser := merge.loser
+	result := &Result{Merge: merge}
+	toTrash := merge.LoserAction == LoserDelete && m.trash != nil
+
+	// The trash snapshots the sessions, which Kratos deletes along with the identity.
+	// Kratos answers 404 when the identity has no session to revoke.
+	if !toTrash {
+		if err := m.client.RevokeIdentitySessions(ctx, loser.Id); err != nil && kratos.StatusCode(err) != http.StatusNotFound {
+			return nil, fmt.Errorf("failed to revoke the loser's sessions: %w", err)
+		}
+	}
+
+	loserTraits, _ := loser.Traits.(map[string]interface{})
+	var err error
+	switch {
+	case toTrash:
+		var entry trash.Entry
+		if entry, err = m.trash.Discard(ctx, loser.Id, admin); err == nil {
+			result.PurgeAt = &entry.PurgeAt
+		}
+	case merge.LoserAction == LoserDelete:
+		err = m.client.DeleteIdentity(ctx, loser.Id)
+	case merge.LoserAction == LoserDeactivate:
+		result.Loser, err = m.client.UpdateIdentity(ctx, loser.Id, ory.UpdateIdentityBody{
+			SchemaId:       loser.SchemaId,
+			Traits:         loserTraits,
+			State:          ory.IDENTITYSTATE_INACTIVE,
+			MetadataPublic: loser.MetadataPublic,
+			MetadataAdmin:  loser.MetadataAdmin,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s the loser: %w", merge.LoserAction, err)
+	}
+
+	winner := merge.winner
+	result.Winner, err = m.client.UpdateIdentity(ctx, winner.Id, ory.UpdateIdentityBody{
+		SchemaId:       winner.SchemaId,
+		Traits:         merge.Traits,
+		State:          stateOf(winner),
+		MetadataPublic: merge.metadataPublic,
+		MetadataAdmin:  merge.metadataAdmin,
+		Credentials:    merge.credentials,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update the winner: %w; %s", err, m.rollback(ctx, merge))
+	}
+	kratos.RedactCredentials(result.Winner)
+	if result.Loser != nil {
+		kratos.RedactCredentials(result.Loser)
+	}
+
+	return result, nil
+}
+
+// rollback brings back the loser after the winner could not be updated and describes the outcome
+func (m *Merger) rollback(ctx context.Context, merge *Merge) string {
+	loser := merge.loser
+	traits, _ := loser.Traits.(map[string]interface{})
+	state := stateOf(loser)
+
+	if merge.LoserAction == LoserDeactivate {
+		_, err := m.client.UpdateIdentity(ctx, loser.Id, ory.UpdateIdentityBody{
+			SchemaId:       loser.SchemaId,
+			Traits:         traits,
+			State:          state,
+			MetadataPublic: loser.MetadataPublic,
+			MetadataAdmin:  loser.MetadataAdmin,
+		})
+		if err != nil {
+			return fmt.Sprintf("the loser stays deactivated: %v", err)
+		}
+		return "the loser was reactivated"
+	}
+
+	if m.trash != nil {
+		restored, err := m.trash.Restore(ctx, loser.Id)
+		if err != nil {
+			return fmt.Sprintf("the loser stays in the trash: %v", err)
+		}
+		return fmt.Sprintf("the loser was restored as %s", restored.Identity.Id)
+	}
+
+	creds, _, _ := kratos.ExportCredentials(loser)
+	recreated, err := m.client.CreateIdentity(ctx, ory.CreateIdentityBody{
+		SchemaId:            loser.SchemaId,
+		Traits:              traits,
+		State:               &state,
+		MetadataPublic:      loser.MetadataPublic,
+		MetadataAdmin:       loser.MetadataAdmin,
+		VerifiableAddresses: kratos.VerifiableAddresses(loser),
+		Credentials:         creds,
+	})
+	if err != nil {
+		return fmt.Sprintf("the loser could not be recreated: %v", err)
+	}
+	return fmt.Sprintf("the loser was recreated as %s", recreated.Id)
+}
+
+// combineMetadata adds the top-level keys of the loser's metadata the winner's does not have
+func combineMetadata(winner, loser interface{}) interface{} {
+	w, wok := winner.(map[string]interface{})
+	l, lok := loser.(map[string]interface{})
+	if !lok || len(l) == 0 {
+		return winner
+	}
+	if !wok {
+		if winner != nil {
+			return winner
+		}
+		w = map[string]interface{}{}
+	}
+
+	result := make(map[string]interface{}, len(w)+len(l))
+	for key, value := range l {
+		result[key] = value
+	}
+	for key, value := range w {
+		result[key] = value
+	}
+	return result
+}
+
+func hasCredential(identity *ory.Identity, credType string) bool {
+	if identity.Credentials == nil {
+		return false
+	}
+	_, ok := (*identity.Credentials)[credType]
+	return ok
+}
+
+func containsProvider(providers []ory.IdentityWithCredentialsOidcConfigProvider, provider ory.IdentityWithCredentialsOidcConfigProvider) bool {
+	for _, p := range providers {
+		if p.Provider == provider.Provider && p.Subject == provider.Subject {
+			return true
+		}
+	}
+	return false
+}
+
+func stateOf(identity *ory.Identity) ory.IdentityState {
+	if identity.State == nil {
+		return ory.IDENTITYSTATE_ACTIVE
+	}
+	return *identity.State
+}
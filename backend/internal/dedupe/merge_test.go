@@ -0,0 +1,283 @@
+package dedupe
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	ory "github.com/ory/kratos-client-go"
+)
+
+func TestCombineTraits(t *testing.T) {
+	tests := []struct {
+		name   string
+		winner string
+		loser  string
+		rules  Rules
+		want   string
+	}{
+		{
+			name:   "winner keeps its values and fills the gaps",
+			winner: `{"email":"jane@example.org","name":{"first":"Jane"}}`,
+			loser:  `{"email":"j.doe@example.org","name":{"first":"J.","last":"Doe"},"phone":"+33600000000"}`,
+			want:   `{"email":"jane@example.org","name":{"first":"Jane","last":"Doe"},"phone":"+33600000000"}`,
+		},
+		{
+			name:   "empty winner values are replaced",
+			winner: `{"email":"","tags":[],"name":{}}`,
+			loser:  `{"email":"jane@example.org","tags":["a"],"name":{"first":"Jane"}}`,
+			want:   `{"email":"jane@example.org","tags":["a"],"name":{"first":"Jane"}}`,
+		},
+		{
+			name:   "loser takes over and keeps the winner's values it lacks",
+			winner: `{"email":"jane@example.org","name":{"first":"Jane","last":"Doe"}}`,
+			loser:  `{"email":"j.doe@example.org","name":{"first":""}}`,
+			rules:  Rules{Default: RuleLoser},
+			want:   `{"email":"j.doe@example.org","name":{"first":"Jane","last":"Doe"}}`,
+		},
+		{
+			name:   "rule applies below its pointer",
+			winner: `{"email":"jane@example.org","name":{"first":"Jane","last":"Doe"}}`,
+			loser:  `{"email":"j.doe@example.org","name":{"first":"Janet","last":"Smith"}}`,
+			rules:  Rules{Fields: map[string]string{"/name": RuleLoser}},
+			want:   `{"email":"jane@example.org","name":{"first":"Janet","last":"Smith"}}`,
+		},
+		{
+			name:   "nested pointer overrides its parent",
+			winner: `{"name":{"first":"Jane","last":"Doe"}}`,
+			loser:  `{"name":{"first":"Janet","last":"Smith"}}`,
+			rules:  Rules{Fields: map[string]string{"/name": RuleLoser, "/name/last": RuleWinner}},
+			want:   `{"name":{"first":"Janet","last":"Doe"}}`,
+		},
+		{
+			name:   "lists are taken as a whole",
+			winner: `{"tags":["a"]}`,
+			loser:  `{"tags":["b","c"]}`,
+			want:   `{"tags":["a"]}`,
+		},
+		{
+			name:   "union combines lists without duplicates",
+			winner: `{"tags":["a","b"],"emails":[{"value":"a@example.org"}]}`,
+			loser:  `{"tags":["b","c"],"emails":[{"value":"a@example.org"},{"value":"b@example.org"}]}`,
+			rules:  Rules{Default: RuleUnion},
+			want:   `{"tags":["a","b","c"],"emails":[{"value":"a@example.org"},{"value":"b@example.org"}]}`,
+		},
+		{
+			name:   "union follows the winner for other values",
+			winner: `{"email":"jane@example.org","tags":"a"}`,
+			loser:  `{"email":"j.doe@example.org","tags":["b"],"phone":"+33600000000"}`,
+			rules:  Rules{Fields: map[string]string{"/email": RuleUnion, "/tags": RuleUnion, "/phone": RuleUnion}},
+			want:   `{"email":"jane@example.org","tags":"a","phone":"+33600000000"}`,
+		},
+		{
+			name:   "union takes the loser's list when the winner has none",
+			winner: `{}`,
+			loser:  `{"tags":["b"]}`,
+			rules:  Rules{Default: RuleUnion},
+			want:   `{"tags":["b"]}`,
+		},
+		{
+			name:   "no traits",
+			winner: `{}`,
+			loser:  `{}`,
+			want:   `{}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var winner, loser, want map[string]interface{}
+			decode(t, tt.winner, &winner)
+			decode(t, tt.loser, &loser)
+			decode(t, tt.want, &want)
+
+			got, err := CombineTraits(winner, loser, tt.rules)
+			if err != nil {
+				t.Fatalf("CombineTraits: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				raw, _ := json.Marshal(got)
+				t.Errorf("traits = %s, want %s", raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombineTraitsDoesNotModifyInputs(t *testing.T) {
+	var winner, loser map[string]interface{}
+	decode(t, `{"name":{"first":"Jane"},"tags":["a"]}`, &winner)
+	decode(t, `{"name":{"last":"Doe"},"tags":["b"]}`, &loser)
+
+	got, err := CombineTraits(winner, loser, Rules{Default: RuleUnion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got["name"].(map[string]interface{})["first"] = "Janet"
+	got["tags"].([]interface{})[0] = "z"
+
+	if raw, _ := json.Marshal(winner); string(raw) != `{"name":{"first":"Jane"},"tags":["a"]}` {
+		t.Errorf("winner = %s, want it unchanged", raw)
+	}
+	if raw, _ := json.Marshal(loser); string(raw) != `{"name":{"last":"Doe"},"tags":["b"]}` {
+		t.Errorf("loser = %s, want it unchanged", raw)
+	}
+}
+
+func TestCombineTraitsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		winner string
+		loser  string
+		rules  Rules
+	}{
+		{"object and string", `{"name":"Jane Doe"}`, `{"name":{"first":"Jane"}}`, Rules{}},
+		{"string and object", `{"name":{"first":"Jane"}}`, `{"name":"Jane Doe"}`, Rules{Default: RuleLoser}},
+		{"unknown default rule", `{}`, `{}`, Rules{Default: "newest"}},
+		{"unknown field rule", `{}`, `{}`, Rules{Fields: map[string]string{"/email": "newest"}}},
+		{"field is not a pointer", `{}`, `{}`, Rules{Fields: map[string]string{"email": RuleLoser}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var winner, loser map[string]interface{}
+			decode(t, tt.winner, &winner)
+			decode(t, tt.loser, &loser)
+
+			if got, err := CombineTraits(winner, loser, tt.rules); err == nil {
+				t.Errorf("CombineTraits = %v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestPlanCredentials(t *testing.T) {
+	password := ory.IdentityCredentials{Config: map[string]interface{}{"hashed_password": "$2a$10$loser"}}
+	winnerPassword := ory.IdentityCredentials{Config: map[string]interface{}{"hashed_password": "$2a$10$winner"}}
+	noHash := ory.IdentityCredentials{Identifiers: []string{"jane@example.org"}}
+	totp := ory.IdentityCredentials{Config: map[string]interface{}{"totp_url": "otpauth://totp/x"}}
+	oidc := func(subjects ...string) ory.IdentityCredentials {
+		var providers []interface{}
+		for _, subject := range subjects {
+			providers = append(providers, map[string]interface{}{"provider": "google", "subject": subject})
+		}
+		return ory.IdentityCredentials{Config: map[string]interface{}{"providers": providers}}
+	}
+
+	tests := []struct {
+		name          string
+		winner        map[string]ory.IdentityCredentials
+		loser         map[string]ory.IdentityCredentials
+		loserAction   string
+		wantMoved     []string
+		wantNotMoved  []string
+		wantPassword  string
+		wantProviders []string
+	}{
+		{
+			name:         "password moves to a winner without one",
+			loser:        map[string]ory.IdentityCredentials{"password": password},
+			loserAction:  LoserDelete,
+			wantMoved:    []string{"password"},
+			wantNotMoved: []string{},
+			wantPassword: "$2a$10$loser",
+		},
+		{
+			name:         "winner keeps its password",
+			winner:       map[string]ory.IdentityCredentials{"password": winnerPassword},
+			loser:        map[string]ory.IdentityCredentials{"password": password},
+			loserAction:  LoserDelete,
+			wantMoved:    []string{},
+			wantNotMoved: []string{"password"},
+		},
+		{
+			name:          "OIDC links are added to the winner's",
+			winner:        map[string]ory.IdentityCredentials{"oidc": oidc("w1")},
+			loser:         map[string]ory.IdentityCredentials{"oidc": oidc("l1", "w1")},
+			loserAction:   LoserDelete,
+			wantMoved:     []string{"oidc"},
+			wantNotMoved:  []string{},
+			wantProviders: []string{"w1", "l1"},
+		},
+		{
+			name:          "password and OIDC move together",
+			loser:         map[string]ory.IdentityCredentials{"password": password, "oidc": oidc("l1")},
+			loserAction:   LoserDelete,
+			wantMoved:     []string{"oidc", "password"},
+			wantNotMoved:  []string{},
+			wantPassword:  "$2a$10$loser",
+			wantProviders: []string{"l1"},
+		},
+		{
+			name:         "unsupported credentials stay with the loser",
+			loser:        map[string]ory.IdentityCredentials{"password": noHash, "totp": totp},
+			loserAction:  LoserDelete,
+			wantMoved:    []string{},
+			wantNotMoved: []string{"password", "totp"},
+		},
+		{
+			name:         "nothing moves from a deactivated loser",
+			loser:        map[string]ory.IdentityCredentials{"password": password, "oidc": oidc("l1"), "totp": totp},
+			loserAction:  LoserDeactivate,
+			wantMoved:    []string{},
+			wantNotMoved: []string{"oidc", "password", "totp"},
+		},
+		{
+			name:         "loser without credentials",
+			loserAction:  LoserDelete,
+			wantMoved:    []string{},
+			wantNotMoved: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merge := &Merge{
+				LoserAction:         tt.loserAction,
+				MovedCredentials:    []string{},
+				NotMovedCredentials: []string{},
+				winner:              &ory.Identity{Id: "winner"},
+				loser:               &ory.Identity{Id: "loser"},
+			}
+			if tt.winner != nil {
+				merge.winner.Credentials = &tt.winner
+			}
+			if tt.loser != nil {
+				merge.loser.Credentials = &tt.loser
+			}
+
+			(&Merger{}).planCredentials(merge)
+
+			if !reflect.DeepEqual(merge.MovedCredentials, tt.wantMoved) || !reflect.DeepEqual(merge.NotMovedCredentials, tt.wantNotMoved) {
+				t.Fatalf("moved = %v, not moved = %v, want %v and %v", merge.MovedCredentials, merge.NotMovedCredentials, tt.wantMoved, tt.wantNotMoved)
+			}
+			if len(tt.wantMoved) == 0 {
+				if merge.credentials != nil {
+					t.Errorf("credentials = %+v, want none sent", merge.credentials)
+				}
+				return
+			}
+
+			var hash string
+			if merge.credentials.Password != nil {
+				hash = merge.credentials.Password.Config.GetHashedPassword()
+			}
+			if hash != tt.wantPassword {
+				t.Errorf("password hash = %q, want %q", hash, tt.wantPassword)
+			}
+			var subjects []string
+			if merge.credentials.Oidc != nil {
+				for _, provider := range merge.credentials.Oidc.Config.Providers {
+					subjects = append(subjects, provider.Subject)
+				}
+			}
+			if !reflect.DeepEqual(subjects, tt.wantProviders) {
+				t.Errorf("OIDC subjects = %v, want %v", subjects, tt.wantProviders)
+			}
+		})
+	}
+}
+
+// decode parses a JSON literal of a test case
+func decode(t *testing.T, raw string, target interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(raw), target); err != nil {
+		t.Fatalf("invalid JSON %s: %v", raw, err)
+	}
+}
@@ -0,0 +1,285 @@
+package erasure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// tombstonesDocument is the store document holding the records of anonymized identities
+const tombstonesDocument = "anonymized_identities.json"
+
+// deletableCredentials are the credential types Kratos can delete from an identity
+var deletableCredentials = []string{"lookup_secret", "totp", "webauthn"}
+
+var (
+	// ErrNotFound is returned when an identity has not been anonymized
+	ErrNotFound = errors.New("identity is not anonymized")
+	// ErrAlreadyAnonymized is returned when anonymizing an identity a second time
+	ErrAlreadyAnonymized = errors.New("identity is already anonymized")
+)
+
+// InvalidTraitsError is returned when no pseudonym matching the identity schema could be found for
+// required traits. Nothing is changed in that case.
+type InvalidTraitsError struct {
+	Errors []schema.ValidationError
+}
+
+func (e *InvalidTraitsError) Error() string {
+	return fmt.Sprintf("pseudonymous traits do not match the identity schema (%d errors)", len(e.Errors))
+}
+
+// Forgetter drops the local copies of an identity's personal data, such as its history or activity
+type Forgetter interface {
+	Forget(identityID string) error
+}
+
+// Tombstone records the anonymization of an identity, without any of its personal data
+type Tombstone struct {
+	IdentityID   string    `json:"identity_id"`
+	SchemaID     string    `json:"schema_id"`
+	AnonymizedBy string    `json:"anonymized_by"`
+	AnonymizedAt time.Time `json:"anonymized_at"`
+	Reason       string    `json:"reason,omitempty"`
+	// ReplacedTraits and RemovedTraits are the pointers of the traits replaced with pseudonyms or left out
+	ReplacedTraits []string `json:"replaced_traits"`
+	RemovedTraits  []string `json:"removed_traits"`
+	// Credentials are the credential types deleted or, for passwords, replaced with a random secret.
+	// RemainingCredentials are those Kratos cannot delete, such as OIDC links.
+	Credentials          []string `json:"credentials"`
+	RemainingCredentials []string `json:"remaining_credentials"`
+	// Errors lists the steps that failed after the identity was anonymized in Kratos. Anonymizing
+	// the identity again retries them.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Result is the outcome of anonymizing an identity
+type Result struct {
+	Tombstone Tombstone `json:"tombstone"`
+	// Identity is the anonymized identity, nil for dry runs
+	Identity *ory.Identity `json:"identity,omitempty"`
+	// Traits are the pseudonymous traits, which dry runs return without changing anything
+	Traits map[string]interface{} `json:"traits"`
+	DryRun bool                   `json:"dry_run,omitempty"`
+}
+
+// Anonymizer erases the personal data of identities of a Kratos target while keeping them as
+// inactive placeholders, and records a tombstone for each of them
+type Anonymizer struct {
+	client     *kratos.Client
+	store      *store.Store
+	forgetters []Forgetter
+
+	mu         sync.Mutex
+	tombstones []*Tombstone
+}
+
+// NewAnonymizer loads the tombstones. The forgetters erase the local copies of anonymized identities.
+func NewAnonymizer(client *kratos.Client, s *store.Store, forgetters ...Forgetter) (*Anonymizer, error) {
+	a := &Anonymizer{client: client, store: s, forgetters: forgetters}
+	if err := s.Load(tombstonesDocument, &a.tombstones); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Anonymize replaces the traits of an identity with pseudonyms, clears its metadata, deletes or
+// scrambles its credentials, revokes its sessions, deactivates it and erases the local copies of
+// its data. Kratos derives the verifiable and recovery addresses from the traits, so they are
+// replaced too. A dry run only returns the planned changes.
+func (a *Anonymizer) Anonymize(ctx context.Context, id, admin, reason string, dryRun bool) (Result, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if tombstone, ok := a.find(id); ok && len(tombstone.Errors) == 0 {
+		return Result{}, ErrAlreadyAnonymized
+	}
+
+	identity, err := a.client.GetIdentityWithCredentials(ctx, id)
+	if err != nil {
+		return Result{}, err
+	}
+	doc, err := a.client.GetIdentitySchema(ctx, identity.SchemaId)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to fetch identity schema: %w", err)
+	}
+
+	traits, _ := identity.Traits.(map[string]interface{})
+	pseudonymized := schema.Pseudonymize(doc, traits, id)
+	if errs := schema.NewValidator(doc).ValidateTraits(pseudonymized.Traits); len(errs) > 0 {
+		return Result{}, &InvalidTraitsError{Errors: errs}
+	}
+
+	tombstone := &Tombstone{
+		IdentityID:           id,
+		SchemaID:             identity.SchemaId,
+		AnonymizedBy:         admin,
+		AnonymizedAt:         time.Now().UTC(),
+		Reason:               reason,
+		ReplacedTraits:       nonNil(pseudonymized.Replaced),
+		RemovedTraits:        nonNil(pseudonymized.Removed),
+		Credentials:          []string{},
+		RemainingCredentials: []string{},
+	}
+
+	var deletable []string
+	hasPassword := false
+	if identity.Credentials != nil {
+		for credType := range *identity.Credentials {
+			switch {
+			case credType == "password":
+				hasPassword = true
+				tombstone.Credentials = append(tombstone.Credentials, credType)
+			case contains(deletableCredentials, credType):
+				deletable = append(deletable, credType)
+				tombstone.Credentials = append(tombstone.Credentials, credType)
+			default:
+				tombstone.RemainingCredentials = append(tombstone.RemainingCredentials, credType)
+			}
+		}
+	}
+	sort.Strings(deletable)
+	sort.Strings(tombstone.Credentials)
+	sort.Strings(tombstone.RemainingCredentials)
+
+	result := Result{Tombstone: *tombstone, Traits: pseudonymized.Traits, DryRun: dryRun}
+	if dryRun {
+		return result, nil
+	}
+
+	body := ory.UpdateIdentityBody{
+		SchemaId:      identity.SchemaId,
+		Traits:        pseudonymized.Traits,
+		State:         ory.IDENTITYSTATE_INACTIVE,
+		MetadataAdmin: map[string]interface{}{"anonymized": true, "anonymized_at": tombstone.AnonymizedAt},
+	}
+	if hasPassword {
+		// Nobody knows the new password, and the previous hash is gone
+		password := randomSecret()
+		body.Credentials = &ory.IdentityWithCredentials{
+			Password: &ory.IdentityWithCredentialsPassword{
+				Config: &ory.IdentityWithCredentialsPasswordConfig{Password: &password},
+			},
+		}
+	}
+	result.Identity, err = a.client.UpdateIdentity(ctx, id, body)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to anonymize identity: %w", err)
+	}
+	kratos.RedactCredentials(result.Identity)
+
+	// The identity no longer holds personal data, the remaining steps are best effort
+	var errs []error
+	for _, credType := range deletable {
+		if err := a.client.DeleteCredential(ctx, id, credType); err != nil && kratos.StatusCode(err) != http.StatusNotFound {
+			errs = append(errs, err)
+		}
+	}
+	// Kratos answers 404 when the identity has no session to revoke
+	if err := a.client.RevokeIdentitySessions(ctx, id); err != nil && kratos.StatusCode(err) != http.StatusNotFound {
+		errs = append(errs, fmt.Errorf("failed to revoke sessions: %w", err))
+	}
+	for _, forgetter := range a.forgetters {
+		if err := forgetter.Forget(id); err != nil {
+			errs = append(errs, fmt.Errorf("failed to erase local data: %w", err))
+		}
+	}
+	for _, err := range errs {
+		tombstone.Errors = append(tombstone.Errors, err.Error())
+	}
+
+	a.remove(id)
+	a.tombstones = append(a.tombstones, tombstone)
+	if err := a.save(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to save tombstone: %w", err))
+	}
+
+	result.Tombstone = *tombstone
+	return result, errors.Join(errs...)
+}
+
+// List returns the tombstones of anonymized identities, most recently anonymized first
+func (a *Anonymizer) List() []Tombstone {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]Tombstone, 0, len(a.tombstones))
+	for i := len(a.tombstones) - 1; i >= 0; i-- {
+		result = append(result, *a.tombstones[i])
+	}
+	return result
+}
+
+// Get returns the tombstone of an anonymized identity
+func (a *Anonymizer) Get(id string) (Tombstone, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tombstone, ok := a.find(id)
+	if !ok {
+		return Tombstone{}, ErrNotFound
+	}
+	return *tombstone, nil
+}
+
+// find returns the tombstone of an identity. Callers must hold a.mu.
+func (a *Anonymizer) find(id string) (*Tombstone, bool) {
+	for _, tombstone := range a.tombstones {
+		if tombstone.IdentityID == id {
+			return tombstone, true
+		}
+	}
+	return nil, false
+}
+
+// remove drops the tombstone of an identity. Callers must hold a.mu.
+func (a *Anonymizer) remove(id string) {
+	kept := a.tombstones[:0]
+	for _, tombstone := range a.tombstones {
+		if tombstone.IdentityID != id {
+			kept = append(kept, tombstone)
+		}
+	}
+	a.tombstones = kept
+}
+
+// save persists the tombstones. Callers must hold a.mu.
+func (a *Anonymizer) save() error {
+	return a.store.Save(tombstonesDocument, a.tombstones)
+}
+
+// randomSecret returns a random password nobody knows
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/activity"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 1000
+)
+
+// ActivityHandler serves the activity feed of a Kratos target
+type ActivityHandler struct {
+	feed *activity.Feed
+}
+
+// NewActivityHandler creates a new activity handler
+func NewActivityHandler(feed *activity.Feed) *ActivityHandler {
+	return &ActivityHandler{feed: feed}
+}
+
+// List returns the activity of every identity, newest first
+func (h *ActivityHandler) List(c *gin.Context) {
+	h.list(c, "")
+}
+
+// ForIdentity returns the activity of an identity, newest first
+func (h *ActivityHandler) ForIdentity(c *gin.Context) {
+	h.list(c, c.Param("id"))
+}
+
+// list writes the events matching the kind, before and limit query parameters
+func (h *ActivityHandler) list(c *gin.Context, identityID string) {
+	query := activity.Query{IdentityID: identityID, Kind: c.Query("kind")}
+	if query.Kind != "" && !activity.IsKind(query.Kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kind", "details": "Supported kinds: registration, login, settings, recovery, verification"})
+		return
+	}
+
+	if before := c.Query("before"); before != "" {
+		t, err := time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before", "details": err.Error()})
+			return
+		}
+		query.Before = t
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultActivityLimit)))
+	if err != nil || limit < 1 || limit > maxActivityLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit", "details": "limit must be between 1 and 1000"})
+		return
+	}
+	query.Limit = limit
+
+	events := h.feed.List(query)
+	response := gin.H{"data": events}
+	if len(events) == limit {
+		response["next_before"] = events[len(events)-1].ReceivedAt.Format(time.RFC3339Nano)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// KratosHooksHandler receives the web hooks Kratos calls after self-service flows
+type KratosHooksHandler struct {
+	feeds         map[string]*activity.Feed
+	defaultTarget string
+}
+
+// NewKratosHooksHandler creates a new Kratos hooks handler storing events in the feed of each target
+func NewKratosHooksHandler(feeds map[string]*activity.Feed, defaultTarget string) *KratosHooksHandler {
+	return &KratosHooksHandler{feeds: feeds, defaultTarget: defaultTarget}
+}
+
+// KratosHookRequest is the body rendered by the web hook Jsonnet template in test/kratos/hooks/activity.jsonnet
+type KratosHookRequest struct {
+	// Event is the flow kind, which can also be given in the event query parameter
+	Event      string `json:"event"`
+	IdentityID string `json:"identity_id"`
+	FlowID     string `json:"flow_id"`
+	FlowType   string `json:"flow_type"`
+	Method     string `json:"method"`
+	SessionID  string `json:"session_id"`
+	IPAddress  string `json:"ip_address"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// Receive stores a flow event. The target query parameter selects the Kratos target, the default one otherwise.
+func (h *KratosHooksHandler) Receive(c *gin.Context) {
+	target := c.DefaultQuery("target", h.defaultTarget)
+	feed, ok := h.feeds[target]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown Kratos target", "target": target})
+		return
+	}
+
+	var req KratosHookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+
+	if event := c.Query("event"); event != "" {
+		req.Event = event
+	}
+	if !activity.IsKind(req.Event) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event", "details": "Supported events: registration, login, settings, recovery, verification"})
+		return
+	}
+	if req.IdentityID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "identity_id is required"})
+		return
+	}
+
+	event, err := feed.Add(activity.Event{
+		Kind:       req.Event,
+		IdentityID: req.IdentityID,
+		FlowID:     req.FlowID,
+		FlowType:   req.FlowType,
+		Method:     req.Method,
+		SessionID:  req.SessionID,
+		IPAddress:  req.IPAddress,
+		UserAgent:  req.UserAgent,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store event", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, event)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// ApprovalsHandler handles change requests waiting for a second admin
+type ApprovalsHandler struct {
+	config  *config.Config
+	manager *approvals.Manager
+}
+
+// NewApprovalsHandler creates a new approvals handler
+func NewApprovalsHandler(cfg *config.Config, manager *approvals.Manager) *ApprovalsHandler {
+	return &ApprovalsHandler{config: cfg, manager: manager}
+}
+
+// DecisionRequest represents the request body to approve or reject a change request
+type DecisionRequest struct {
+	Comment string `json:"comment"`
+}
+
+// List returns change requests, newest first, optionally filtered by status
+func (h *ApprovalsHandler) List(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", approvals.StatusPending, approvals.StatusApproved, approvals.StatusRejected,
+		approvals.StatusExpired, approvals.StatusExecuted, approvals.StatusFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status", "details": "Supported statuses: pending, approved, rejected, expired, executed, failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": h.manager.List(status)})
+}
+
+// Get returns a change request
+func (h *ApprovalsHandler) Get(c *gin.Context) {
+	req, err := h.manager.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// Approve approves a change request of another admin and runs it.
+// The approver needs write access to the target of the request.
+func (h *ApprovalsHandler) Approve(c *gin.Context) {
+	req, body, ok := h.decision(c)
+	if !ok {
+		return
+	}
+	if !h.canWrite(auth.Admin(c), req.Target) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Write access to the Kratos target is required to approve", "target": req.Target})
+		return
+	}
+
+	approved, err := h.manager.Approve(c.Request.Context(), req.ID, auth.Admin(c), body.Comment)
+	if err != nil {
+		h.decisionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, approved)
+}
+
+// Reject rejects a change request. Requesters can reject their own request to withdraw it.
+func (h *ApprovalsHandler) Reject(c *gin.Context) {
+	req, body, ok := h.decision(c)
+	if !ok {
+		return
+	}
+	admin := auth.Admin(c)
+	if req.RequestedBy != admin && !h.canWrite(admin, req.Target) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Write access to the Kratos target is required to reject", "target": req.Target})
+		return
+	}
+
+	rejected, err := h.manager.Reject(req.ID, admin, body.Comment)
+	if err != nil {
+		h.decisionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rejected)
+}
+
+// decision loads the change request being decided on and the optional decision body
+func (h *ApprovalsHandler) decision(c *gin.Context) (approvals.Request, DecisionRequest, bool) {
+	var body DecisionRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return approvals.Request{}, body, false
+		}
+	}
+
+	req, err := h.manager.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return approvals.Request{}, body, false
+	}
+	return req, body, true
+}
+
+// canWrite reports whether an admin has write access to a target, or to every target for
+// requests spanning several of them
+func (h *ApprovalsHandler) canWrite(admin, target string) bool {
+	if target != "" {
+		return h.config.Role(admin, target) == config.RoleWrite
+	}
+	for _, t := range h.config.KratosTargets {
+		if h.config.Role(admin, t.Name) != config.RoleWrite {
+			return false
+		}
+	}
+	return true
+}
+
+// decisionError writes the response for a failed decision
+func (h *ApprovalsHandler) decisionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, approvals.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+	case errors.Is(err, approvals.ErrNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is no longer pending", "details": err.Error()})
+	case errors.Is(err, approvals.ErrSelfApproval):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Change requests must be approved by another admin"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide on change request", "details": err.Error()})
+	}
+}
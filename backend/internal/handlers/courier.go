@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/gin-gonic/gin"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// maxCourierPageSize limits the page size forwarded to Kratos
+const maxCourierPageSize = 1000
+
+// CourierHandler handles courier message requests
+type CourierHandler struct {
+	client *kratos.Client
+}
+
+// NewCourierHandler creates a new courier handler
+func NewCourierHandler(client *kratos.Client) *CourierHandler {
+	return &CourierHandler{client: client}
+}
+
+// List returns a page of courier messages.
+// Query parameters: status, recipient, page_size (default 50) and page_token.
+func (h *CourierHandler) List(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && !ory.CourierMessageStatus(status).IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status", "details": "expected one of queued, sent, processing, abandoned"})
+		return
+	}
+
+	pageSize, err := strconv.ParseInt(c.DefaultQuery("page_size", "50"), 10, 64)
+	if err != nil || pageSize <= 0 || pageSize > maxCourierPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page_size"})
+		return
+	}
+
+	result, err := h.client.ListCourierMessages(c.Request.Context(), kratos.CourierMessageFilter{
+		Status:    status,
+		Recipient: c.Query("recipient"),
+		PageSize:  pageSize,
+		PageToken: c.Query("page_token"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch courier messages", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":            result.Messages,
+		"page_size":       pageSize,
+		"next_page_token": result.NextPageToken,
+	})
+}
+
+// Get returns a courier message with its dispatch attempts and errors
+func (h *CourierHandler) Get(c *gin.Context) {
+	id := c.Param("id")
+
+	message, err := h.client.GetCourierMessage(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Courier message not found", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// ForIdentity returns the most recent courier messages sent to any of an identity's
+// verifiable or recovery addresses, newest first
+func (h *CourierHandler) ForIdentity(c *gin.Context) {
+	id := c.Param("id")
+
+	identity, err := h.client.GetIdentity(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found", "details": err.Error()})
+		return
+	}
+
+	recipients := identityAddresses(identity)
+	messages := []ory.Message{}
+	for _, recipient := range recipients {
+		result, err := h.client.ListCourierMessages(c.Request.Context(), kratos.CourierMessageFilter{
+			Recipient: recipient,
+			PageSize:  maxCourierPageSize,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch courier messages", "details": err.Error()})
+			return
+		}
+		messages = append(messages, result.Messages...)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       messages,
+		"recipients": recipients,
+	})
+}
+
+// identityAddresses returns the distinct verifiable and recovery addresses of an identity
+func identityAddresses(identity *ory.Identity) []string {
+	seen := map[string]bool{}
+	addresses := []string{}
+	add := func(value string) {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			addresses = append(addresses, value)
+		}
+	}
+
+	for _, address := range identity.VerifiableAddresses {
+		add(address.Value)
+	}
+	for _, address := range identity.RecoveryAddresses {
+		add(address.Value)
+	}
+
+	return addresses
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/activity"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/history"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	"github.com/gin-gonic/gin"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// DSARHandler exports everything known about an identity for data subject access requests
+type DSARHandler struct {
+	client    *kratos.Client
+	target    string
+	feed      *activity.Feed
+	history   *history.Recorder
+	approvals *approvals.Manager
+	// trash is nil unless soft delete is enabled
+	trash *trash.Bin
+}
+
+// NewDSARHandler creates a new DSAR handler for a Kratos target
+func NewDSARHandler(client *kratos.Client, target string, feed *activity.Feed, recorder *history.Recorder, manager *approvals.Manager, bin *trash.Bin) *DSARHandler {
+	return &DSARHandler{client: client, target: target, feed: feed, history: recorder, approvals: manager, trash: bin}
+}
+
+// DSARFile describes a file of the export bundle
+type DSARFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Count is the number of records in the file, when it holds a list
+	Count *int `json:"count,omitempty"`
+}
+
+// DSARManifest is the manifest.json of the export bundle
+type DSARManifest struct {
+	IdentityID  string     `json:"identity_id"`
+	Target      string     `json:"target"`
+	GeneratedAt time.Time  `json:"generated_at"`
+	GeneratedBy string     `json:"generated_by"`
+	Addresses   []string   `json:"addresses"`
+	Files       []DSARFile `json:"files"`
+	// Notes explains what the bundle does not contain
+	Notes []string `json:"notes"`
+}
+
+// Export returns a ZIP bundle with the identity without credential secrets, its sessions and
+// devices, the courier messages sent to its addresses and the audit entries recorded about it
+func (h *DSARHandler) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	identity, err := h.client.GetIdentityWithCredentials(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found", "details": err.Error()})
+		return
+	}
+	kratos.RedactCredentials(identity)
+
+	sessions, err := h.client.GetIdentitySessions(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions", "details": err.Error()})
+		return
+	}
+	if sessions == nil {
+		sessions = []ory.Session{}
+	}
+
+	addresses := identityAddresses(identity)
+	messages, err := h.courierMessages(ctx, addresses)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch courier messages", "details": err.Error()})
+		return
+	}
+
+	manifest := DSARManifest{
+		IdentityID:  id,
+		Target:      h.target,
+		GeneratedAt: time.Now().UTC(),
+		GeneratedBy: auth.Admin(c),
+		Addresses:   addresses,
+		Notes: []string{
+			"Credential secrets such as password hashes, TOTP keys and recovery codes are not exported.",
+			"Activity is only recorded for self-service flows reported by Kratos web hooks, and kept for a limited time.",
+		},
+	}
+
+	bundle := &bytes.Buffer{}
+	archive := zip.NewWriter(bundle)
+	add := func(name, description string, v interface{}, count int) error {
+		file := DSARFile{Name: name, Description: description}
+		if count >= 0 {
+			file.Count = &count
+		}
+		manifest.Files = append(manifest.Files, file)
+		return writeJSON(archive, name, manifest.GeneratedAt, v)
+	}
+
+	versions := h.history.List(id, time.Time{})
+	events := h.feed.List(activity.Query{IdentityID: id})
+	requests := h.changeRequests(id)
+
+	files := []struct {
+		name, description string
+		v                 interface{}
+		count             int
+	}{
+		{"identity.json", "Identity with its traits, addresses, metadata and credential types, without secrets", identity, -1},
+		{"sessions.json", "Sessions with the devices, IP addresses and locations they were used from", sessions, len(sessions)},
+		{"courier_messages.json", "Emails and SMS sent to the identity's addresses", messages, len(messages)},
+		{"audit/history.json", "Versions of the identity's traits, state and metadata, and who changed them", versions, len(versions)},
+		{"audit/activity.json", "Registration, login, settings, recovery and verification flows", events, len(events)},
+		{"audit/change_requests.json", "Approval requests for operations on the identity", requests, len(requests)},
+	}
+	for _, f := range files {
+		if err := add(f.name, f.description, f.v, f.count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export", "details": err.Error()})
+			return
+		}
+	}
+
+	if h.trash != nil {
+		if entry, err := h.trash.Get(id); err == nil {
+			deletion := gin.H{"deleted_by": entry.DeletedBy, "deleted_at": entry.DeletedAt, "purge_at": entry.PurgeAt}
+			if err := add("audit/deletion.json", "Soft deletion of the identity, pending its purge", deletion, -1); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export", "details": err.Error()})
+				return
+			}
+		} else if !errors.Is(err, trash.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export", "details": err.Error()})
+			return
+		}
+	}
+
+	if err := writeJSON(archive, "manifest.json", manifest.GeneratedAt, manifest); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export", "details": err.Error()})
+		return
+	}
+	if err := archive.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export", "details": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("dsar-%s-%s.zip", id, manifest.GeneratedAt.Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/zip", bundle.Bytes())
+}
+
+// courierMessages returns every courier message sent to the addresses, newest first
+func (h *DSARHandler) courierMessages(ctx context.Context, addresses []string) ([]ory.Message, error) {
+	messages := []ory.Message{}
+	for _, recipient := range addresses {
+		filter := kratos.CourierMessageFilter{Recipient: recipient, PageSize: maxCourierPageSize}
+		for {
+			result, err := h.client.ListCourierMessages(ctx, filter)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, result.Messages...)
+			if result.NextPageToken == "" || len(result.Messages) == 0 {
+				break
+			}
+			filter.PageToken = result.NextPageToken
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+// changeRequests returns the change requests about the identity on this target, including the
+// merges it took part in
+func (h *DSARHandler) changeRequests(id string) []approvals.Request {
+	result := []approvals.Request{}
+	for _, req := range h.approvals.List("") {
+		if req.Target == h.target && req.Concerns(id) {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+// writeJSON adds an indented JSON file to a ZIP archive
+func writeJSON(archive *zip.Writer, name string, modified time.Time, v interface{}) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/dedupe"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/history"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// DuplicatesHandler finds and merges identities belonging to the same person
+type DuplicatesHandler struct {
+	client  *kratos.Client
+	merger  *dedupe.Merger
+	events  *webhooks.Publisher
+	history *history.Recorder
+}
+
+// NewDuplicatesHandler creates a new duplicates handler publishing merges to events and
+// recording them in the identity history. Deleted losers go to bin when it is not nil.
+func NewDuplicatesHandler(client *kratos.Client, events *webhooks.Publisher, recorder *history.Recorder, bin *trash.Bin) *DuplicatesHandler {
+	return &DuplicatesHandler{
+		client:  client,
+		merger:  dedupe.NewMerger(client, bin),
+		events:  events,
+		history: recorder,
+	}
+}
+
+// Find returns groups of identities sharing an email address or phone number, or with similar
+// names. The by query parameter restricts the kinds of matches, e.g. by=email,phone, and
+// min_score sets the name similarity from which names match. Names are only compared with names
+// starting with the same two letters, and skipped_name_prefixes lists the prefixes shared by too
+// many names to compare.
+func (h *DuplicatesHandler) Find(c *gin.Context) {
+	var opts dedupe.Options
+	if by := c.Query("by"); by != "" {
+		for _, kind := range strings.Split(by, ",") {
+			kind = strings.TrimSpace(kind)
+			if kind != dedupe.MatchEmail && kind != dedupe.MatchPhone && kind != dedupe.MatchName {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid by", "details": "Supported matches: email, phone, name"})
+				return
+			}
+			opts.By = append(opts.By, kind)
+		}
+	}
+	if value := c.Query("min_score"); value != "" {
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil || score <= 0 || score > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_score", "details": "min_score must be greater than 0 and at most 1"})
+			return
+		}
+		opts.MinNameScore = score
+	}
+
+	var identities []ory.Identity
+	err := h.client.ForEachIdentityPage(c.Request.Context(), func(page []ory.Identity) error {
+		identities = append(identities, page...)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities", "details": err.Error()})
+		return
+	}
+
+	groups, skipped := dedupe.Find(identities, opts)
+	c.JSON(http.StatusOK, gin.H{"data": groups, "total": len(groups), "scanned": len(identities), "skipped_name_prefixes": skipped})
+}
+
+// MergeIdentitiesRequest represents the request body for merging two identities
+type MergeIdentitiesRequest struct {
+	WinnerID    string       `json:"winner_id" binding:"required"`
+	LoserID     string       `json:"loser_id" binding:"required"`
+	Rules       dedupe.Rules `json:"rules"`
+	LoserAction string       `json:"loser_action" binding:"omitempty,oneof=delete deactivate"`
+	DryRun      bool         `json:"dry_run"`
+}
+
+// Merge merges the loser into the winner and deletes or deactivates the loser
+func (h *DuplicatesHandler) Merge(c *gin.Context) {
+	var req MergeIdentitiesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	// Dry runs skip approval, so the flag seen by the approval middleware is authoritative
+	req.DryRun = approvals.DryRun(c)
+
+	ctx := c.Request.Context()
+	merge, err := h.merger.Prepare(ctx, dedupe.Plan{
+		WinnerID:    req.WinnerID,
+		LoserID:     req.LoserID,
+		Rules:       req.Rules,
+		LoserAction: req.LoserAction,
+	})
+	switch {
+	case errors.Is(err, dedupe.ErrInvalidPlan):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merge", "details": err.Error()})
+		return
+	case kratos.StatusCode(err) == http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found", "details": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare merge", "details": err.Error()})
+		return
+	}
+
+	errs, err := validateTraits(ctx, h.client, merge.SchemaID, merge.Traits)
+	if err != nil {
+		schemaError(c, err)
+		return
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Merged traits do not match the winner's identity schema", "errors": errs, "traits": merge.Traits})
+		return
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, dedupe.Result{Merge: merge, DryRun: true})
+		return
+	}
+
+	admin := auth.Admin(c)
+	result, err := h.merger.Execute(ctx, merge, admin)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if kratos.StatusCode(err) == http.StatusConflict || errors.Is(err, trash.ErrAlreadyDeleted) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": "Failed to merge identities", "details": err.Error()})
+		return
+	}
+
+	h.events.Publish(webhooks.EventIdentityMerged, admin, gin.H{"winner_id": req.WinnerID, "loser_id": req.LoserID, "loser_action": merge.LoserAction})
+	h.events.Publish(webhooks.EventIdentityUpdated, admin, result.Winner)
+	h.record(result.Winner, admin)
+	if result.Loser != nil {
+		h.events.Publish(webhooks.EventIdentityUpdated, admin, result.Loser)
+		h.record(result.Loser, admin)
+	} else if result.PurgeAt != nil {
+		h.events.Publish(webhooks.EventIdentityDeleted, admin, gin.H{"identity_id": req.LoserID, "soft_deleted": true, "purge_at": result.PurgeAt})
+	} else {
+		h.events.Publish(webhooks.EventIdentityDeleted, admin, gin.H{"identity_id": req.LoserID})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// record adds a version to the identity history. The merge is already made, so failures are only logged.
+func (h *DuplicatesHandler) record(identity *ory.Identity, admin string) {
+	if _, _, err := h.history.Record(identity, history.SourceMerge, admin); err != nil {
+		log.Printf("Failed to record version of identity %s: %v", identity.Id, err)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/erasure"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/history"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// ErasureHandler handles the anonymization of identities for right-to-erasure requests
+type ErasureHandler struct {
+	anonymizer *erasure.Anonymizer
+	events     *webhooks.Publisher
+	history    *history.Recorder
+}
+
+// NewErasureHandler creates a new erasure handler publishing anonymizations to events. The
+// anonymized identity starts a new history in recorder.
+func NewErasureHandler(anonymizer *erasure.Anonymizer, events *webhooks.Publisher, recorder *history.Recorder) *ErasureHandler {
+	return &ErasureHandler{anonymizer: anonymizer, events: events, history: recorder}
+}
+
+// AnonymizeRequest represents the optional request body for anonymizing an identity
+type AnonymizeRequest struct {
+	Reason string `json:"reason"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// Anonymize erases the personal data of an identity and keeps it as an inactive placeholder
+func (h *ErasureHandler) Anonymize(c *gin.Context) {
+	id := c.Param("id")
+
+	var req AnonymizeRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+	}
+	// Dry runs skip approval, so the flag seen by the approval middleware is authoritative
+	req.DryRun = approvals.DryRun(c)
+
+	result, err := h.anonymizer.Anonymize(c.Request.Context(), id, auth.Admin(c), req.Reason, req.DryRun)
+	var invalid *erasure.InvalidTraitsError
+	switch {
+	case errors.Is(err, erasure.ErrAlreadyAnonymized):
+		c.JSON(http.StatusConflict, gin.H{"error": "Identity is already anonymized"})
+		return
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No pseudonym matches the identity schema for some required traits", "errors": invalid.Errors})
+		return
+	case kratos.StatusCode(err) == http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found", "details": err.Error()})
+		return
+	case err != nil && result.Identity == nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to anonymize identity", "details": err.Error()})
+		return
+	}
+
+	if result.DryRun {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	h.events.Publish(webhooks.EventIdentityAnonymized, auth.Admin(c), gin.H{"identity_id": id})
+	if _, _, err := h.history.Record(result.Identity, history.SourceAnonymize, auth.Admin(c)); err != nil {
+		log.Printf("Failed to record version of identity %s: %v", id, err)
+	}
+
+	if err != nil {
+		// The identity holds no personal data anymore, but some credentials, sessions or local copies may remain
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Identity anonymized but some data could not be erased", "details": err.Error(), "anonymized": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// List returns the tombstones of anonymized identities, most recently anonymized first
+func (h *ErasureHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.anonymizer.List()})
+}
+
+// Get returns the tombstone of an anonymized identity
+func (h *ErasureHandler) Get(c *gin.Context) {
+	tombstone, err := h.anonymizer.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anonymized identity not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tombstone)
+}
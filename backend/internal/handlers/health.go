@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/gin-gonic/gin"
+)
+
+// Dependency check statuses
+const (
+	checkUp   = "up"
+	checkDown = "down"
+)
+
+// HealthHandler handles liveness and readiness probes
+type HealthHandler struct {
+	clients         map[string]*kratos.Client
+	requiredTargets map[string]bool
+	timeout         time.Duration
+	requirePublic   bool
+}
+
+// NewHealthHandler creates a new health handler checking the Kratos targets in clients.
+// Only the checks of requiredTargets affect readiness.
+func NewHealthHandler(clients map[string]*kratos.Client, requiredTargets []string, timeout time.Duration, requirePublic bool) *HealthHandler {
+	required := make(map[string]bool, len(requiredTargets))
+	for _, name := range requiredTargets {
+		required[name] = true
+	}
+	return &HealthHandler{clients: clients, requiredTargets: required, timeout: timeout, requirePublic: requirePublic}
+}
+
+// DependencyCheck represents the result of checking a single dependency
+type DependencyCheck struct {
+	Status    string `json:"status"`
+	Required  bool   `json:"required"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// TargetReadiness represents the readiness of the APIs of a Kratos target
+type TargetReadiness struct {
+	KratosVersion string                     `json:"kratos_version,omitempty"`
+	Checks        map[string]DependencyCheck `json:"checks"`
+}
+
+// ReadyResponse represents the readiness of the backend and its dependencies
+type ReadyResponse struct {
+	Status  string                      `json:"status"`
+	Targets map[string]*TargetReadiness `json:"targets"`
+}
+
+// Ready checks the Kratos admin and public APIs of every target.
+// It responds 503 if a required dependency of a required target is down. Failures are only
+// logged since the probe is unauthenticated.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	response := ReadyResponse{Status: "ready", Targets: map[string]*TargetReadiness{}}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, client := range h.clients {
+		target := &TargetReadiness{Checks: map[string]DependencyCheck{}}
+		response.Targets[name] = target
+
+		checks := map[string]struct {
+			required bool
+			check    func(ctx context.Context) error
+		}{
+			"kratos_admin":  {required: h.requiredTargets[name], check: client.CheckAdminReady},
+			"kratos_public": {required: h.requiredTargets[name] && h.requirePublic, check: client.CheckPublicReady},
+		}
+		for checkName, dep := range checks {
+			wg.Add(1)
+			go func(name string, target *TargetReadiness, checkName string, required bool, check func(ctx context.Context) error) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+				defer cancel()
+
+				start := time.Now()
+				err := check(ctx)
+				result := DependencyCheck{Status: checkUp, Required: required, LatencyMS: time.Since(start).Milliseconds()}
+				if err != nil {
+					result.Status = checkDown
+					log.Printf("Readiness check %s of target %s failed: %v", checkName, name, err)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				target.Checks[checkName] = result
+				if err != nil && required {
+					response.Status = "not_ready"
+				}
+			}(name, target, checkName, dep.required, dep.check)
+		}
+
+		// The version is informative only and does not affect readiness
+		wg.Add(1)
+		go func(target *TargetReadiness, client *kratos.Client) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+			defer cancel()
+
+			if version, err := client.GetVersion(ctx); err == nil {
+				mu.Lock()
+				target.KratosVersion = version
+				mu.Unlock()
+			}
+		}(target, client)
+	}
+
+	wg.Wait()
+
+	status := http.StatusOK
+	if response.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/history"
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryHandler serves the recorded versions of identities
+type HistoryHandler struct {
+	recorder *history.Recorder
+}
+
+// NewHistoryHandler creates a new identity history handler
+func NewHistoryHandler(recorder *history.Recorder) *HistoryHandler {
+	return &HistoryHandler{recorder: recorder}
+}
+
+// List returns the versions of an identity, newest first. With the at query parameter, only
+// versions recorded until then are returned, the first one being the identity at that time.
+func (h *HistoryHandler) List(c *gin.Context) {
+	var at time.Time
+	if value := c.Query("at"); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid at", "details": err.Error()})
+			return
+		}
+		at = t
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": h.recorder.List(c.Param("id"), at)})
+}
+
+// Get returns a version of an identity and its changes compared to the version in the compare
+// query parameter, the previous version by default
+func (h *HistoryHandler) Get(c *gin.Context) {
+	id := c.Param("id")
+
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version", "details": err.Error()})
+		return
+	}
+	version, ok := h.recorder.Get(id, number)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	var base history.Version
+	if compare := c.Query("compare"); compare != "" {
+		other, err := strconv.Atoi(compare)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid compare", "details": err.Error()})
+			return
+		}
+		if base, ok = h.recorder.Get(id, other); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version to compare with not found", "version": other})
+			return
+		}
+	} else {
+		for _, v := range h.recorder.List(id, time.Time{}) {
+			if v.Version < number {
+				base = v
+				break
+			}
+		}
+	}
+
+	response := gin.H{"version": version, "changes": history.Diff(base, version)}
+	if base.Version > 0 {
+		response["compared_to"] = base.Version
+	}
+	c.JSON(http.StatusOK, response)
+}
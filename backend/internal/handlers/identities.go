@@ -1,22 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/history"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
 	"github.com/gin-gonic/gin"
 	ory "github.com/ory/kratos-client-go"
 )
 
 // IdentitiesHandler handles identity-related requests
 type IdentitiesHandler struct {
-	client *kratos.Client
+	client  *kratos.Client
+	events  *webhooks.Publisher
+	trash   *trash.Bin
+	history *history.Recorder
 }
 
-// NewIdentitiesHandler creates a new identities handler
-func NewIdentitiesHandler(client *kratos.Client) *IdentitiesHandler {
-	return &IdentitiesHandler{client: client}
+// NewIdentitiesHandler creates a new identities handler publishing admin changes to events and
+// recording them in the identity history. Deleted identities go to bin when it is not nil.
+func NewIdentitiesHandler(client *kratos.Client, events *webhooks.Publisher, bin *trash.Bin, recorder *history.Recorder) *IdentitiesHandler {
+	return &IdentitiesHandler{client: client, events: events, trash: bin, history: recorder}
 }
 
 // List returns a paginated list of identities
@@ -66,6 +76,10 @@ func (h *IdentitiesHandler) Create(c *gin.Context) {
 		return
 	}
 
+	if !h.checkTraits(c, req.SchemaID, req.Traits) {
+		return
+	}
+
 	body := ory.CreateIdentityBody{
 		SchemaId: req.SchemaID,
 		Traits:   req.Traits,
@@ -81,6 +95,8 @@ func (h *IdentitiesHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create identity", "details": err.Error()})
 		return
 	}
+	h.events.Publish(webhooks.EventIdentityCreated, auth.Admin(c), identity)
+	h.record(c, identity, history.SourceCreate)
 
 	c.JSON(http.StatusCreated, identity)
 }
@@ -92,7 +108,7 @@ type UpdateIdentityRequest struct {
 	State    string                 `json:"state,omitempty"`
 }
 
-// Update updates an existing identity
+// Update replaces the schema, traits and optionally the state of an existing identity
 func (h *IdentitiesHandler) Update(c *gin.Context) {
 	id := c.Param("id")
 
@@ -102,14 +118,33 @@ func (h *IdentitiesHandler) Update(c *gin.Context) {
 		return
 	}
 
+	if !h.checkTraits(c, req.SchemaID, req.Traits) {
+		return
+	}
+
+	// Kratos replaces the whole identity, so the metadata set by SCIM, LDAP sync or merges is
+	// carried over from the current identity
+	current, err := h.client.GetIdentity(c.Request.Context(), id)
+	if err != nil {
+		if kratos.StatusCode(err) == http.StatusNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identity", "details": err.Error()})
+		return
+	}
+
 	body := ory.UpdateIdentityBody{
-		SchemaId: req.SchemaID,
-		Traits:   req.Traits,
+		SchemaId:       req.SchemaID,
+		Traits:         req.Traits,
+		MetadataPublic: current.MetadataPublic,
+		MetadataAdmin:  current.MetadataAdmin,
 	}
 
 	if req.State != "" {
-		state := ory.IdentityState(req.State)
-		body.State = state
+		body.State = ory.IdentityState(req.State)
+	} else if current.State != nil {
+		body.State = *current.State
 	}
 
 	identity, err := h.client.UpdateIdentity(c.Request.Context(), id, body)
@@ -117,22 +152,88 @@ func (h *IdentitiesHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update identity", "details": err.Error()})
 		return
 	}
+	h.events.Publish(webhooks.EventIdentityUpdated, auth.Admin(c), identity)
+	h.record(c, identity, history.SourceUpdate)
 
 	c.JSON(http.StatusOK, identity)
 }
 
-// Delete deletes an identity
+// record adds a version to the identity history. The change is already made, so failures are only logged.
+func (h *IdentitiesHandler) record(c *gin.Context, identity *ory.Identity, source string) {
+	if _, _, err := h.history.Record(identity, source, auth.Admin(c)); err != nil {
+		log.Printf("Failed to record version of identity %s: %v", identity.Id, err)
+	}
+}
+
+// checkTraits validates traits against the identity schema and writes an error response on failure.
+// It returns true if the request may proceed.
+func (h *IdentitiesHandler) checkTraits(c *gin.Context, schemaID string, traits map[string]interface{}) bool {
+	errs, err := validateTraits(c.Request.Context(), h.client, schemaID, traits)
+	if err != nil {
+		schemaError(c, err)
+		return false
+	}
+
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Traits do not match the identity schema", "errors": errs})
+		return false
+	}
+
+	return true
+}
+
+// Delete deletes an identity. With soft delete enabled, the identity is deactivated and kept
+// for restore unless the permanent query parameter is true.
 func (h *IdentitiesHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.client.DeleteIdentity(c.Request.Context(), id); err != nil {
+	if h.trash != nil && c.Query("permanent") != "true" {
+		h.softDelete(c, id)
+		return
+	}
+
+	// Permanently deleting a soft-deleted identity also drops its snapshot
+	err := trash.ErrNotFound
+	if h.trash != nil {
+		err = h.trash.Purge(c.Request.Context(), id)
+	}
+	if errors.Is(err, trash.ErrNotFound) {
+		err = h.client.DeleteIdentity(c.Request.Context(), id)
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete identity", "details": err.Error()})
 		return
 	}
+	h.events.Publish(webhooks.EventIdentityDeleted, auth.Admin(c), gin.H{"identity_id": id})
 
 	c.JSON(http.StatusNoContent, nil)
 }
 
+// softDelete moves an identity to the trash
+func (h *IdentitiesHandler) softDelete(c *gin.Context, id string) {
+	entry, err := h.trash.Delete(c.Request.Context(), id, auth.Admin(c))
+	switch {
+	case errors.Is(err, trash.ErrAlreadyDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Identity is already soft-deleted"})
+		return
+	case kratos.StatusCode(err) == http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found", "details": err.Error()})
+		return
+	case err != nil && entry.IdentityID == "":
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete identity", "details": err.Error()})
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityDeleted, auth.Admin(c), gin.H{"identity_id": id, "soft_deleted": true, "purge_at": entry.PurgeAt})
+
+	if err != nil {
+		// The identity is deactivated and in the trash, but some of its sessions may still be active
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions", "details": err.Error(), "deleted": entry.Redacted()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry.Redacted())
+}
+
 // GetSessions returns sessions for an identity
 func (h *IdentitiesHandler) GetSessions(c *gin.Context) {
 	id := c.Param("id")
@@ -161,10 +262,13 @@ func (h *IdentitiesHandler) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	if err := h.client.ResetPassword(c.Request.Context(), id, req.Password); err != nil {
+	identity, err := h.client.ResetPassword(c.Request.Context(), id, req.Password)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password", "details": err.Error()})
 		return
 	}
+	h.events.Publish(webhooks.EventPasswordReset, auth.Admin(c), gin.H{"identity_id": id})
+	h.record(c, identity, history.SourcePasswordReset)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
 }
@@ -190,6 +294,7 @@ func (h *IdentitiesHandler) DeleteCredential(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete credential", "details": err.Error()})
 		return
 	}
+	h.events.Publish(webhooks.EventCredentialDeleted, auth.Admin(c), gin.H{"identity_id": id, "credential_type": credType})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Credential deleted successfully"})
 }
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/ldapsync"
+	"github.com/gin-gonic/gin"
+)
+
+// LDAPSyncHandler handles LDAP directory sync runs
+type LDAPSyncHandler struct {
+	scheduler *ldapsync.Scheduler
+	jobs      *jobs.Manager
+}
+
+// NewLDAPSyncHandler creates a new LDAP sync handler
+func NewLDAPSyncHandler(scheduler *ldapsync.Scheduler, manager *jobs.Manager) *LDAPSyncHandler {
+	return &LDAPSyncHandler{scheduler: scheduler, jobs: manager}
+}
+
+// StartSyncRequest represents the request body to start a sync run
+type StartSyncRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// Create starts a sync run outside of the schedule
+func (h *LDAPSyncHandler) Create(c *gin.Context) {
+	var req StartSyncRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+	}
+
+	job, err := h.scheduler.Start(req.DryRun)
+	if errors.Is(err, ldapsync.ErrRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An LDAP sync is already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start LDAP sync", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// List returns all known sync runs
+func (h *LDAPSyncHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.jobs.List(ldapsync.JobKind)})
+}
+
+// Get returns a sync run including its report
+func (h *LDAPSyncHandler) Get(c *gin.Context) {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err != nil || job.Kind != ldapsync.JobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "LDAP sync run not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Cancel stops a running sync. Changes already made are kept.
+func (h *LDAPSyncHandler) Cancel(c *gin.Context) {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err != nil || job.Kind != ldapsync.JobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "LDAP sync run not found"})
+		return
+	}
+
+	if job.Status != jobs.StatusRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "LDAP sync is not running"})
+		return
+	}
+
+	if err := h.jobs.Cancel(job.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel LDAP sync", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "LDAP sync cancelled"})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/migration"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// MigrationsHandler handles schema migration requests
+type MigrationsHandler struct {
+	client   *kratos.Client
+	jobs     *jobs.Manager
+	migrator *migration.Migrator
+}
+
+// NewMigrationsHandler creates a new migrations handler publishing migrated identities to events
+func NewMigrationsHandler(client *kratos.Client, manager *jobs.Manager, events *webhooks.Publisher) *MigrationsHandler {
+	return &MigrationsHandler{
+		client:   client,
+		jobs:     manager,
+		migrator: migration.NewMigrator(client, events),
+	}
+}
+
+// Create validates a migration plan and starts it as a background job
+func (h *MigrationsHandler) Create(c *gin.Context) {
+	var plan migration.Plan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	// Dry runs skip approval, so the flag seen by the approval middleware is authoritative
+	plan.DryRun = approvals.DryRun(c)
+
+	if _, err := migration.NewMapping(plan.Rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trait mapping", "details": err.Error()})
+		return
+	}
+
+	if _, err := h.client.GetIdentitySchema(c.Request.Context(), plan.TargetSchema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target schema not found", "details": err.Error()})
+		return
+	}
+
+	admin := auth.Admin(c)
+	job := h.jobs.Start(migration.JobKind, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		return h.migrator.Run(ctx, plan, admin, progress)
+	})
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// List returns all known migration jobs
+func (h *MigrationsHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.jobs.List(migration.JobKind)})
+}
+
+// Get returns a migration job including its report
+func (h *MigrationsHandler) Get(c *gin.Context) {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err != nil || job.Kind != migration.JobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Migration not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Cancel stops a running migration. Identities already updated stay migrated.
+func (h *MigrationsHandler) Cancel(c *gin.Context) {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err != nil || job.Kind != migration.JobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Migration not found"})
+		return
+	}
+
+	if job.Status != jobs.StatusRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Migration is not running"})
+		return
+	}
+
+	if err := h.jobs.Cancel(job.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel migration", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Migration cancelled"})
+}
@@ -1,33 +1,261 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"net/http"
+	"sort"
 
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/stats"
 	"github.com/gin-gonic/gin"
+	ory "github.com/ory/kratos-client-go"
 )
 
 // SchemasHandler handles schema-related requests
 type SchemasHandler struct {
 	client *kratos.Client
+	usage  *stats.UsageCache
 }
 
 // NewSchemasHandler creates a new schemas handler
-func NewSchemasHandler(client *kratos.Client) *SchemasHandler {
-	return &SchemasHandler{client: client}
+func NewSchemasHandler(client *kratos.Client, usage *stats.UsageCache) *SchemasHandler {
+	return &SchemasHandler{client: client, usage: usage}
+}
+
+// SchemaListItem represents a schema together with its usage counts
+type SchemaListItem struct {
+	kratos.IdentitySchemaWithContent
+	Usage *stats.SchemaUsage `json:"usage,omitempty"`
 }
 
 // List returns all identity schemas
 func (h *SchemasHandler) List(c *gin.Context) {
-	schemas, err := h.client.ListIdentitySchemas(c.Request.Context())
+	ctx := c.Request.Context()
+
+	schemas, err := h.client.ListIdentitySchemas(ctx)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schemas", "details": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": schemas})
+	// Usage counts are best effort, the schema list is still useful without them
+	usageAvailable := true
+	items := make([]SchemaListItem, 0, len(schemas))
+	for _, s := range schemas {
+		item := SchemaListItem{IdentitySchemaWithContent: s}
+
+		if usageAvailable {
+			usage, err := h.usage.Get(ctx, s.ID)
+			if err != nil {
+				log.Printf("Failed to compute schema usage: %v", err)
+				usageAvailable = false
+			}
+			item.Usage = usage
+		}
+
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": items})
 }
 
+// Usage returns identity counts for a single schema
+func (h *SchemasHandler) Usage(c *gin.Context) {
+	id := c.Param("id")
+
+	usage, err := h.usage.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute schema usage", "details": err.Error()})
+		return
+	}
 
+	c.JSON(http.StatusOK, usage)
+}
+
+// Form returns a normalized form description for editing traits of an identity schema
+func (h *SchemasHandler) Form(c *gin.Context) {
+	id := c.Param("id")
+
+	doc, err := h.client.GetIdentitySchema(c.Request.Context(), id)
+	if err != nil {
+		schemaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schema.BuildForm(id, doc))
+}
+
+// LintRequest represents the request body for linting a schema
+type LintRequest struct {
+	Schema map[string]interface{} `json:"schema" binding:"required"`
+	// SchemaID optionally selects existing identities to check for missing required traits
+	SchemaID string `json:"schema_id,omitempty"`
+}
+
+// maxLintSamples is the number of identity IDs listed per missing required trait
+const maxLintSamples = 10
+
+// Lint checks a schema for common mistakes before it is rolled out
+func (h *SchemasHandler) Lint(c *gin.Context) {
+	var req LintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+
+	findings := schema.Lint(req.Schema)
+
+	if req.SchemaID != "" {
+		missing, err := h.missingRequired(c.Request.Context(), req.SchemaID, req.Schema)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing identities", "details": err.Error()})
+			return
+		}
+		findings = append(findings, missing...)
+	}
+
+	valid := true
+	for _, f := range findings {
+		if f.Severity == schema.SeverityError {
+			valid = false
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"valid": valid, "findings": findings})
+}
+
+// missingRequired reports required traits of doc that identities of schemaID do not have
+func (h *SchemasHandler) missingRequired(ctx context.Context, schemaID string, doc map[string]interface{}) ([]schema.Finding, error) {
+	counts := map[string]int{}
+	samples := map[string][]string{}
+
+	err := h.client.ForEachIdentityPage(ctx, func(identities []ory.Identity) error {
+		for _, identity := range identities {
+			if identity.SchemaId != schemaID {
+				continue
+			}
+			traits, _ := identity.Traits.(map[string]interface{})
+			for _, pointer := range schema.MissingRequired(doc, traits) {
+				counts[pointer]++
+				if len(samples[pointer]) < maxLintSamples {
+					samples[pointer] = append(samples[pointer], identity.Id)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pointers := make([]string, 0, len(counts))
+	for pointer := range counts {
+		pointers = append(pointers, pointer)
+	}
+	sort.Strings(pointers)
+
+	findings := make([]schema.Finding, 0, len(pointers))
+	for _, pointer := range pointers {
+		findings = append(findings, schema.Finding{
+			Severity: schema.SeverityError,
+			Code:     "required_missing_in_identities",
+			Pointer:  pointer,
+			Message:  fmt.Sprintf("%d existing identities of schema %s lack this required trait, e.g. %v", counts[pointer], schemaID, samples[pointer]),
+		})
+	}
+	return findings, nil
+}
 
+// Diff lists added, removed and retyped traits between two schemas
+func (h *SchemasHandler) Diff(c *gin.Context) {
+	ctx := c.Request.Context()
+	fromID := c.Query("from")
+	toID := c.Query("to")
 
+	if fromID == "" || toID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters from and to are required"})
+		return
+	}
+
+	from, err := h.client.GetIdentitySchema(ctx, fromID)
+	if err != nil {
+		diffSchemaError(c, "from", fromID, err)
+		return
+	}
+
+	to, err := h.client.GetIdentitySchema(ctx, toID)
+	if err != nil {
+		diffSchemaError(c, "to", toID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schema.DiffSchemas(fromID, from, toID, to))
+}
+
+// diffSchemaError writes the response for a failure to resolve the from or to schema of a diff
+func diffSchemaError(c *gin.Context, param, id string, err error) {
+	if kratos.StatusCode(err) == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Schema %s given as %s not found", id, param), "details": err.Error()})
+		return
+	}
+	schemaError(c, err)
+}
+
+// ValidateTraitsRequest represents the request body for validating traits
+type ValidateTraitsRequest struct {
+	Traits map[string]interface{} `json:"traits"`
+}
+
+// ValidateTraitsResponse represents the result of a traits validation
+type ValidateTraitsResponse struct {
+	Valid  bool                     `json:"valid"`
+	Errors []schema.ValidationError `json:"errors"`
+}
+
+// Validate validates traits against an identity schema without calling Kratos
+func (h *SchemasHandler) Validate(c *gin.Context) {
+	id := c.Param("id")
+
+	var req ValidateTraitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+
+	errs, err := validateTraits(c.Request.Context(), h.client, id, req.Traits)
+	if err != nil {
+		schemaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ValidateTraitsResponse{
+		Valid:  len(errs) == 0,
+		Errors: errs,
+	})
+}
+
+// validateTraits resolves the identity schema and validates traits against it
+func validateTraits(ctx context.Context, client *kratos.Client, schemaID string, traits map[string]interface{}) ([]schema.ValidationError, error) {
+	doc, err := client.GetIdentitySchema(ctx, schemaID)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := schema.NewValidator(doc).ValidateTraits(traits)
+	if errs == nil {
+		errs = []schema.ValidationError{}
+	}
+	return errs, nil
+}
+
+// schemaError writes the response for a failure to resolve an identity schema
+func schemaError(c *gin.Context, err error) {
+	if kratos.StatusCode(err) == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identity schema", "details": err.Error()})
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/scim"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// maxSCIMResults limits the number of users returned per SCIM list request
+const maxSCIMResults = 1000
+
+// SCIMHandler serves the SCIM 2.0 Users endpoints on top of Kratos identities
+type SCIMHandler struct {
+	client *kratos.Client
+	mapper *scim.Mapper
+	events *webhooks.Publisher
+	trash  *trash.Bin
+}
+
+// NewSCIMHandler creates a new SCIM handler publishing provisioned changes to events.
+// Deleted users go to bin when it is not nil, and are no longer served while in it.
+func NewSCIMHandler(client *kratos.Client, mapper *scim.Mapper, events *webhooks.Publisher, bin *trash.Bin) *SCIMHandler {
+	return &SCIMHandler{client: client, mapper: mapper, events: events, trash: bin}
+}
+
+// ServiceProviderConfig returns the supported SCIM features
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	scim.Write(c, http.StatusOK, scim.ServiceProviderConfig(scimBaseURL(c), maxSCIMResults))
+}
+
+// ResourceTypes lists the supported resource types
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	resources := []interface{}{scim.UserResourceType(scimBaseURL(c))}
+	scim.Write(c, http.StatusOK, scim.ListResponse(resources, len(resources), 1))
+}
+
+// ResourceType returns a single resource type
+func (h *SCIMHandler) ResourceType(c *gin.Context) {
+	if c.Param("id") != "User" {
+		scim.WriteError(c, http.StatusNotFound, "", "Resource type not found")
+		return
+	}
+	scim.Write(c, http.StatusOK, scim.UserResourceType(scimBaseURL(c)))
+}
+
+// Schemas lists the supported schemas
+func (h *SCIMHandler) Schemas(c *gin.Context) {
+	resources := []interface{}{h.mapper.UserSchema(scimBaseURL(c))}
+	scim.Write(c, http.StatusOK, scim.ListResponse(resources, len(resources), 1))
+}
+
+// Schema returns a single schema
+func (h *SCIMHandler) Schema(c *gin.Context) {
+	if c.Param("id") != scim.SchemaUser {
+		scim.WriteError(c, http.StatusNotFound, "", "Schema not found")
+		return
+	}
+	scim.Write(c, http.StatusOK, h.mapper.UserSchema(scimBaseURL(c)))
+}
+
+// ListUsers returns the users matching the filter query parameter, paginated with startIndex and count
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	var filter scim.Filter
+	if raw := c.Query("filter"); raw != "" {
+		var err error
+		if filter, err = scim.ParseFilter(raw); err != nil {
+			scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidFilter, err.Error())
+			return
+		}
+	}
+
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil {
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidValue, "startIndex must be an integer")
+		return
+	}
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", "100"))
+	if err != nil {
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidValue, "count must be an integer")
+		return
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxSCIMResults {
+		count = maxSCIMResults
+	}
+
+	identities, err := h.candidates(c.Request.Context(), filter)
+	if err != nil {
+		scim.WriteError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	var matched []interface{}
+	for i := range identities {
+		resource := h.mapper.ToResource(&identities[i], scimUserLocation(c, identities[i].Id))
+		if filter == nil || filter.Match(resource) {
+			matched = append(matched, resource)
+		}
+	}
+
+	page := []interface{}{}
+	if start := startIndex - 1; start < len(matched) {
+		end := start + count
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page = matched[start:end]
+	}
+
+	scim.Write(c, http.StatusOK, scim.ListResponse(page, len(matched), startIndex))
+}
+
+// candidates returns the identities a filter may match. Equality filters on userName are
+// resolved with a credential identifier lookup when userName is mapped to an identifier trait.
+func (h *SCIMHandler) candidates(ctx context.Context, filter scim.Filter) ([]ory.Identity, error) {
+	if path, value, ok := scim.Equality(filter); ok && strings.EqualFold(path, "userName") {
+		identifier, err := h.userNameIsIdentifier(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if identifier {
+			found, err := h.client.FindIdentitiesByIdentifier(ctx, value)
+			if err != nil {
+				return nil, err
+			}
+			return h.ownIdentities(found), nil
+		}
+	}
+
+	var identities []ory.Identity
+	err := h.client.ForEachIdentityPage(ctx, func(page []ory.Identity) error {
+		identities = append(identities, h.ownIdentities(page)...)
+		return nil
+	})
+	return identities, err
+}
+
+// ownIdentities keeps the identities using the SCIM schema that are not soft-deleted
+func (h *SCIMHandler) ownIdentities(identities []ory.Identity) []ory.Identity {
+	result := make([]ory.Identity, 0, len(identities))
+	for _, identity := range identities {
+		if identity.SchemaId == h.mapper.SchemaID() && !h.inTrash(identity.Id) {
+			result = append(result, identity)
+		}
+	}
+	return result
+}
+
+// userNameIsIdentifier reports whether the trait mapped to userName is a credential identifier
+func (h *SCIMHandler) userNameIsIdentifier(ctx context.Context) (bool, error) {
+	doc, err := h.client.GetIdentitySchema(ctx, h.mapper.SchemaID())
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch identity schema: %w", err)
+	}
+
+	pointer := "/traits" + h.mapper.Pointer("userName")
+	for _, identifier := range schema.BuildForm(h.mapper.SchemaID(), doc).Identifiers {
+		if identifier == pointer {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetUser returns a single user
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	identity, ok := h.getIdentity(c)
+	if !ok {
+		return
+	}
+
+	scim.Write(c, http.StatusOK, h.mapper.ToResource(identity, scimUserLocation(c, identity.Id)))
+}
+
+// CreateUser creates an identity from a SCIM User
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var resource map[string]interface{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+		return
+	}
+
+	userName := scim.UserName(resource)
+	if userName == "" {
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidValue, "userName is required")
+		return
+	}
+
+	if exists, err := h.userNameExists(c.Request.Context(), userName); err != nil {
+		scim.WriteError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	} else if exists {
+		scim.WriteError(c, http.StatusConflict, scim.ErrUniqueness, "A user with this userName already exists")
+		return
+	}
+
+	update, err := h.mapper.FromResource(resource, nil)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	identity, err := h.client.CreateIdentity(c.Request.Context(), ory.CreateIdentityBody{
+		SchemaId:      h.mapper.SchemaID(),
+		Traits:        update.Traits,
+		State:         &update.State,
+		MetadataAdmin: update.MetadataAdmin,
+	})
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityCreated, webhooks.ActorSCIM, identity)
+
+	c.Header("Location", scimUserLocation(c, identity.Id))
+	scim.Write(c, http.StatusCreated, h.mapper.ToResource(identity, scimUserLocation(c, identity.Id)))
+}
+
+// userNameExists reports whether a user with the given userName exists
+func (h *SCIMHandler) userNameExists(ctx context.Context, userName string) (bool, error) {
+	filter, err := scim.ParseFilter(fmt.Sprintf("userName eq %q", userName))
+	if err != nil {
+		return false, err
+	}
+
+	identities, err := h.candidates(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	for i := range identities {
+		if filter.Match(h.mapper.ToResource(&identities[i], "")) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReplaceUser replaces the mapped attributes of a user
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	identity, ok := h.getIdentity(c)
+	if !ok {
+		return
+	}
+
+	var resource map[string]interface{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+		return
+	}
+
+	h.save(c, identity, resource)
+}
+
+// PatchUser applies PatchOp operations to a user
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	identity, ok := h.getIdentity(c)
+	if !ok {
+		return
+	}
+
+	var req scim.PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+		return
+	}
+
+	resource := h.mapper.ToResource(identity, scimUserLocation(c, identity.Id))
+	if err := scim.ApplyPatch(resource, req.Operations); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	h.save(c, identity, resource)
+}
+
+// save writes a SCIM User resource to an existing identity
+func (h *SCIMHandler) save(c *gin.Context, identity *ory.Identity, resource map[string]interface{}) {
+	update, err := h.mapper.FromResource(resource, identity)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	updated, err := h.client.UpdateIdentity(c.Request.Context(), identity.Id, ory.UpdateIdentityBody{
+		SchemaId:       identity.SchemaId,
+		Traits:         update.Traits,
+		State:          update.State,
+		MetadataPublic: identity.MetadataPublic,
+		MetadataAdmin:  update.MetadataAdmin,
+	})
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityUpdated, webhooks.ActorSCIM, updated)
+
+	scim.Write(c, http.StatusOK, h.mapper.ToResource(updated, scimUserLocation(c, updated.Id)))
+}
+
+// DeleteUser deletes the identity of a user
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	identity, ok := h.getIdentity(c)
+	if !ok {
+		return
+	}
+
+	if h.trash != nil {
+		h.softDeleteUser(c, identity.Id)
+		return
+	}
+
+	if err := h.client.DeleteIdentity(c.Request.Context(), identity.Id); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityDeleted, webhooks.ActorSCIM, gin.H{"identity_id": identity.Id})
+
+	c.Status(http.StatusNoContent)
+}
+
+// softDeleteUser moves the identity of a user to the trash, from where admins can restore it
+func (h *SCIMHandler) softDeleteUser(c *gin.Context, id string) {
+	entry, err := h.trash.Delete(c.Request.Context(), id, webhooks.ActorSCIM)
+	switch {
+	case errors.Is(err, trash.ErrAlreadyDeleted):
+		scim.WriteError(c, http.StatusNotFound, "", "User not found")
+		return
+	case err != nil && entry.IdentityID == "":
+		writeSCIMError(c, err)
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityDeleted, webhooks.ActorSCIM, gin.H{"identity_id": id, "soft_deleted": true, "purge_at": entry.PurgeAt})
+
+	if err != nil {
+		// The identity is deactivated and in the trash, but some of its sessions may still be active
+		writeSCIMError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getIdentity loads the identity of the :id parameter, writing a 404 if it is not a SCIM user
+func (h *SCIMHandler) getIdentity(c *gin.Context) (*ory.Identity, bool) {
+	identity, err := h.client.GetIdentity(c.Request.Context(), c.Param("id"))
+	if err != nil || identity.SchemaId != h.mapper.SchemaID() || h.inTrash(identity.Id) {
+		scim.WriteError(c, http.StatusNotFound, "", "User not found")
+		return nil, false
+	}
+	return identity, true
+}
+
+// inTrash reports whether an identity is soft-deleted
+func (h *SCIMHandler) inTrash(id string) bool {
+	if h.trash == nil {
+		return false
+	}
+	_, err := h.trash.Get(id)
+	return err == nil
+}
+
+// writeSCIMError converts SCIM and Kratos errors to SCIM error responses
+func writeSCIMError(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	if errors.As(err, &scimErr) {
+		scim.WriteError(c, scimErr.Status, scimErr.ScimType, scimErr.Detail)
+		return
+	}
+
+	switch kratos.StatusCode(err) {
+	case http.StatusConflict:
+		scim.WriteError(c, http.StatusConflict, scim.ErrUniqueness, err.Error())
+	case http.StatusBadRequest:
+		scim.WriteError(c, http.StatusBadRequest, scim.ErrInvalidValue, err.Error())
+	case http.StatusNotFound:
+		scim.WriteError(c, http.StatusNotFound, "", "User not found")
+	default:
+		scim.WriteError(c, http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+// scimBaseURL returns the absolute URL of the SCIM endpoints
+func scimBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/scim/v2"
+}
+
+// scimUserLocation returns the URL of a user resource
+func scimUserLocation(c *gin.Context, id string) string {
+	return scimBaseURL(c) + "/Users/" + id
+}
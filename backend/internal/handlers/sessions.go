@@ -4,18 +4,21 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
 	"github.com/gin-gonic/gin"
 )
 
 // SessionsHandler handles session-related requests
 type SessionsHandler struct {
 	client *kratos.Client
+	events *webhooks.Publisher
 }
 
-// NewSessionsHandler creates a new sessions handler
-func NewSessionsHandler(client *kratos.Client) *SessionsHandler {
-	return &SessionsHandler{client: client}
+// NewSessionsHandler creates a new sessions handler publishing revocations to events
+func NewSessionsHandler(client *kratos.Client, events *webhooks.Publisher) *SessionsHandler {
+	return &SessionsHandler{client: client, events: events}
 }
 
 // List returns a paginated list of sessions
@@ -44,6 +47,7 @@ func (h *SessionsHandler) Revoke(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session", "details": err.Error()})
 		return
 	}
+	h.events.Publish(webhooks.EventSessionRevoked, auth.Admin(c), gin.H{"session_id": id})
 
 	c.JSON(http.StatusNoContent, nil)
 }
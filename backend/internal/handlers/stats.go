@@ -1,49 +1,164 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
 	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/stats"
 	"github.com/gin-gonic/gin"
 )
 
 // StatsHandler handles stats-related requests
 type StatsHandler struct {
-	client *kratos.Client
+	client    *kratos.Client
+	dashboard *stats.DashboardCache
+	history   *stats.History
+	security  *stats.SecurityCache
 }
 
 // NewStatsHandler creates a new stats handler
-func NewStatsHandler(client *kratos.Client) *StatsHandler {
-	return &StatsHandler{client: client}
+func NewStatsHandler(client *kratos.Client, dashboard *stats.DashboardCache, history *stats.History, security *stats.SecurityCache) *StatsHandler {
+	return &StatsHandler{client: client, dashboard: dashboard, history: history, security: security}
 }
 
 // StatsResponse represents dashboard statistics
 type StatsResponse struct {
-	ActiveIdentities int64 `json:"active_identities"`
-	ActiveSessions   int64 `json:"active_sessions"`
+	ActiveIdentities int64     `json:"active_identities"`
+	ActiveSessions   int64     `json:"active_sessions"`
+	RefreshedAt      time.Time `json:"refreshed_at"`
 }
 
-// Get returns dashboard statistics
+// Get returns dashboard statistics from the latest snapshot, refreshed on read when stale
 func (h *StatsHandler) Get(c *gin.Context) {
-	ctx := c.Request.Context()
+	snapshot, err := h.dashboard.Get(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dashboard statistics", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, StatsResponse{
+		ActiveIdentities: snapshot.ActiveIdentities,
+		ActiveSessions:   snapshot.ActiveSessions,
+		RefreshedAt:      snapshot.Timestamp,
+	})
+}
+
+// maxHistoryBuckets limits the number of points per series
+const maxHistoryBuckets = 2000
+
+// HistoryResponse represents time series built from stored snapshots
+type HistoryResponse struct {
+	From     time.Time                `json:"from"`
+	To       time.Time                `json:"to"`
+	Interval string                   `json:"interval"`
+	Series   map[string][]stats.Point `json:"series"`
+}
+
+// History returns time series of identity and session counts.
+// Query parameters: range (default 7d) and interval (default 1d), e.g. range=30d&interval=6h.
+func (h *StatsHandler) History(c *gin.Context) {
+	span, err := config.ParseDuration(c.DefaultQuery("range", "7d"))
+	if err != nil || span <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range", "details": fmt.Sprint(err)})
+		return
+	}
 
-	// Get active identity count
-	activeIdentities, err := h.client.GetActiveIdentityCount(ctx)
+	interval, err := config.ParseDuration(c.DefaultQuery("interval", "1d"))
+	if err != nil || interval <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval", "details": fmt.Sprint(err)})
+		return
+	}
+
+	if span/interval > maxHistoryBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Interval too small for range", "details": fmt.Sprintf("at most %d points per series", maxHistoryBuckets)})
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-span)
+
+	c.JSON(http.StatusOK, HistoryResponse{
+		From:     from,
+		To:       to,
+		Interval: c.DefaultQuery("interval", "1d"),
+		Series:   h.history.Series(from, to, interval),
+	})
+}
+
+// maxTrendsWindow limits how far back trends can be computed
+const maxTrendsWindow = 366 * 24 * time.Hour
+
+// Trends returns signups, first logins and active identities per day or week, per schema.
+// Query parameters: window (default 30d) and bucket (day or week, default day).
+func (h *StatsHandler) Trends(c *gin.Context) {
+	window, err := config.ParseDuration(c.DefaultQuery("window", "30d"))
+	if err != nil || window <= 0 || window > maxTrendsWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window", "details": "window must be a duration such as 7d, at most 366d"})
+		return
+	}
+
+	bucket := c.DefaultQuery("bucket", stats.BucketDay)
+	if bucket != stats.BucketDay && bucket != stats.BucketWeek {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bucket", "details": "bucket must be day or week"})
+		return
+	}
+
+	to := time.Now().UTC()
+	trends, err := stats.ComputeTrends(c.Request.Context(), h.client, to.Add(-window), to, bucket)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch active identity count", "details": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute trends", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, trends)
+}
+
+// Security returns MFA adoption and address verification statistics.
+// Query parameter limit (default 100) caps the identity IDs listed per category.
+func (h *StatsHandler) Security(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
 		return
 	}
 
-	// Get active session count
-	activeSessions, err := h.client.GetSessionCount(ctx)
+	report, err := h.security.Get(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch session count", "details": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute security report", "details": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, StatsResponse{
-		ActiveIdentities: activeIdentities,
-		ActiveSessions:   activeSessions,
-	})
+	c.JSON(http.StatusOK, report.Truncate(limit))
 }
 
+// maxCourierWindow limits how far back courier messages are aggregated
+const maxCourierWindow = 90 * 24 * time.Hour
+
+// Courier returns courier delivery statistics and recipients with repeated failures.
+// Query parameters: window (default 7d) and min_failures (default 2).
+func (h *StatsHandler) Courier(c *gin.Context) {
+	window, err := config.ParseDuration(c.DefaultQuery("window", "7d"))
+	if err != nil || window <= 0 || window > maxCourierWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window", "details": "window must be a duration such as 7d, at most 90d"})
+		return
+	}
+
+	minFailures, err := strconv.ParseInt(c.DefaultQuery("min_failures", "2"), 10, 64)
+	if err != nil || minFailures < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_failures"})
+		return
+	}
+
+	to := time.Now().UTC()
+	result, err := stats.ComputeCourierStats(c.Request.Context(), h.client, to.Add(-window), to, minFailures)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute courier statistics", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
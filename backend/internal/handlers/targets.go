@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// TargetsHandler handles Kratos target requests
+type TargetsHandler struct {
+	config *config.Config
+}
+
+// NewTargetsHandler creates a new targets handler
+func NewTargetsHandler(cfg *config.Config) *TargetsHandler {
+	return &TargetsHandler{config: cfg}
+}
+
+// TargetResponse describes a Kratos target and the caller's role on it
+type TargetResponse struct {
+	Name      string `json:"name"`
+	PublicURL string `json:"public_url"`
+	Role      string `json:"role"`
+	Default   bool   `json:"default"`
+}
+
+// List returns the Kratos targets the authenticated admin can access
+func (h *TargetsHandler) List(c *gin.Context) {
+	admin := auth.Admin(c)
+
+	targets := []TargetResponse{}
+	for _, target := range h.config.KratosTargets {
+		role := h.config.Role(admin, target.Name)
+		if role == "" {
+			continue
+		}
+		targets = append(targets, TargetResponse{
+			Name:      target.Name,
+			PublicURL: target.PublicURL,
+			Role:      role,
+			Default:   target.Name == h.config.DefaultTarget,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": targets})
+}
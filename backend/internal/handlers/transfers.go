@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/approvals"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/transfer"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// TransfersHandler handles requests to copy identities between Kratos targets
+type TransfersHandler struct {
+	config     *config.Config
+	clients    map[string]*kratos.Client
+	jobs       *jobs.Manager
+	dispatcher *webhooks.Dispatcher
+}
+
+// NewTransfersHandler creates a new transfers handler publishing the copied identities as
+// events of the destination target
+func NewTransfersHandler(cfg *config.Config, clients map[string]*kratos.Client, manager *jobs.Manager, dispatcher *webhooks.Dispatcher) *TransfersHandler {
+	return &TransfersHandler{config: cfg, clients: clients, jobs: manager, dispatcher: dispatcher}
+}
+
+// Create validates a transfer plan and starts it as a background job.
+// The admin needs read access to the source and write access to the destination, or read access for a dry run.
+func (h *TransfersHandler) Create(c *gin.Context) {
+	var plan transfer.Plan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	// Dry runs skip approval, so the flag seen by the approval middleware is authoritative
+	plan.DryRun = approvals.DryRun(c)
+
+	if plan.Source == plan.Destination {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and destination must be different targets"})
+		return
+	}
+
+	source, ok := h.clients[plan.Source]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown source target", "target": plan.Source})
+		return
+	}
+	destination, ok := h.clients[plan.Destination]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown destination target", "target": plan.Destination})
+		return
+	}
+
+	admin := auth.Admin(c)
+	if h.config.Role(admin, plan.Source) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to Kratos target", "target": plan.Source})
+		return
+	}
+	switch role := h.config.Role(admin, plan.Destination); {
+	case role == "":
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to Kratos target", "target": plan.Destination})
+		return
+	case role != config.RoleWrite && !plan.DryRun:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Read-only access to Kratos target", "target": plan.Destination})
+		return
+	}
+
+	copier := transfer.NewCopier(source, destination, h.dispatcher.Publisher(plan.Destination))
+	job := h.jobs.Start(transfer.JobKind, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		return copier.Run(ctx, plan, admin, progress)
+	})
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// List returns all known transfer jobs
+func (h *TransfersHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.jobs.List(transfer.JobKind)})
+}
+
+// Get returns a transfer job including its report
+func (h *TransfersHandler) Get(c *gin.Context) {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err != nil || job.Kind != transfer.JobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Cancel stops a running transfer. Identities already copied stay in the destination.
+func (h *TransfersHandler) Cancel(c *gin.Context) {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err != nil || job.Kind != transfer.JobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	if job.Status != jobs.StatusRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transfer is not running"})
+		return
+	}
+
+	if err := h.jobs.Cancel(job.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel transfer", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer cancelled"})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/auth"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/trash"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// TrashHandler handles soft-deleted identities
+type TrashHandler struct {
+	trash  *trash.Bin
+	events *webhooks.Publisher
+}
+
+// NewTrashHandler creates a new trash handler publishing restores to events
+func NewTrashHandler(bin *trash.Bin, events *webhooks.Publisher) *TrashHandler {
+	return &TrashHandler{trash: bin, events: events}
+}
+
+// List returns the soft-deleted identities, most recently deleted first
+func (h *TrashHandler) List(c *gin.Context) {
+	entries := h.trash.List()
+	for i := range entries {
+		entries[i] = entries[i].Redacted()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": entries})
+}
+
+// Get returns the snapshot of a soft-deleted identity and its sessions
+func (h *TrashHandler) Get(c *gin.Context) {
+	entry, err := h.trash.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Soft-deleted identity not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry.Redacted())
+}
+
+// Restore restores a soft-deleted identity. Revoked sessions are not restored.
+func (h *TrashHandler) Restore(c *gin.Context) {
+	restored, err := h.trash.Restore(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, trash.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Soft-deleted identity not found"})
+		return
+	case errors.Is(err, trash.ErrExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Restore window has expired, the identity is being purged"})
+		return
+	case err != nil && restored.Identity == nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore identity", "details": err.Error()})
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityRestored, auth.Admin(c), restored.Identity)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Identity restored but failed to update the trash", "details": err.Error(), "restored": restored})
+		return
+	}
+
+	c.JSON(http.StatusOK, restored)
+}
+
+// Purge permanently deletes a soft-deleted identity before the end of its restore window
+func (h *TrashHandler) Purge(c *gin.Context) {
+	id := c.Param("id")
+
+	err := h.trash.Purge(c.Request.Context(), id)
+	if errors.Is(err, trash.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Soft-deleted identity not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge identity", "details": err.Error()})
+		return
+	}
+	h.events.Publish(webhooks.EventIdentityDeleted, auth.Admin(c), gin.H{"identity_id": id})
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDeliveriesLimit = 100
+	maxDeliveriesLimit     = 1000
+)
+
+// WebhooksHandler handles webhook subscriptions and their delivery log
+type WebhooksHandler struct {
+	config     *config.Config
+	dispatcher *webhooks.Dispatcher
+}
+
+// NewWebhooksHandler creates a new webhooks handler
+func NewWebhooksHandler(cfg *config.Config, dispatcher *webhooks.Dispatcher) *WebhooksHandler {
+	return &WebhooksHandler{config: cfg, dispatcher: dispatcher}
+}
+
+// SubscriptionRequest represents the request body to create or update a webhook subscription
+type SubscriptionRequest struct {
+	URL     string   `json:"url" binding:"required"`
+	Events  []string `json:"events" binding:"required,min=1"`
+	Targets []string `json:"targets"`
+	// Secret is generated on creation and kept on update when empty
+	Secret string `json:"secret"`
+	Active *bool  `json:"active"`
+}
+
+// subscription validates the request and converts it to a subscription
+func (h *WebhooksHandler) subscription(c *gin.Context) (webhooks.Subscription, bool) {
+	var req SubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return webhooks.Subscription{}, false
+	}
+
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook URL must be an absolute http or https URL"})
+		return webhooks.Subscription{}, false
+	}
+	for _, event := range req.Events {
+		if !webhooks.IsEventType(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type", "event": event, "supported": webhooks.EventTypes})
+			return webhooks.Subscription{}, false
+		}
+	}
+	for _, target := range req.Targets {
+		if _, ok := h.config.Target(target); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown Kratos target", "target": target})
+			return webhooks.Subscription{}, false
+		}
+	}
+
+	sub := webhooks.Subscription{
+		URL:     req.URL,
+		Events:  req.Events,
+		Targets: req.Targets,
+		Secret:  req.Secret,
+		Active:  req.Active == nil || *req.Active,
+	}
+	if sub.Targets == nil {
+		sub.Targets = []string{}
+	}
+	return sub, true
+}
+
+// List returns all webhook subscriptions
+func (h *WebhooksHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.dispatcher.Subscriptions(), "events": webhooks.EventTypes})
+}
+
+// Create creates a webhook subscription. The response is the only one including the signing secret.
+func (h *WebhooksHandler) Create(c *gin.Context) {
+	sub, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	created, err := h.dispatcher.CreateSubscription(sub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// Get returns a webhook subscription
+func (h *WebhooksHandler) Get(c *gin.Context) {
+	sub, err := h.dispatcher.Subscription(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sub)
+}
+
+// Update replaces a webhook subscription
+func (h *WebhooksHandler) Update(c *gin.Context) {
+	sub, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	updated, err := h.dispatcher.UpdateSubscription(c.Param("id"), sub)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete deletes a webhook subscription
+func (h *WebhooksHandler) Delete(c *gin.Context) {
+	err := h.dispatcher.DeleteSubscription(c.Param("id"))
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// Deliveries returns the delivery log, newest first
+func (h *WebhooksHandler) Deliveries(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", webhooks.DeliveryPending, webhooks.DeliverySucceeded, webhooks.DeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status", "details": "Supported statuses: pending, succeeded, failed"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDeliveriesLimit)))
+	if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit", "details": "limit must be between 1 and 1000"})
+		return
+	}
+
+	filter := webhooks.DeliveryFilter{
+		SubscriptionID: c.Query("webhook_id"),
+		EventType:      c.Query("event"),
+		Status:         status,
+	}
+	c.JSON(http.StatusOK, gin.H{"data": h.dispatcher.Deliveries(filter, limit)})
+}
+
+// Delivery returns a delivery with its attempts
+func (h *WebhooksHandler) Delivery(c *gin.Context) {
+	delivery, err := h.dispatcher.Delivery(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// Redeliver sends the payload of a delivery again, signed with the subscription's current secret
+func (h *WebhooksHandler) Redeliver(c *gin.Context) {
+	delivery, err := h.dispatcher.Redeliver(c.Param("id"))
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery or webhook not found", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeliver", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
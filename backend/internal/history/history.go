@@ -0,0 +1,267 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// versionsLog is the store log holding identity versions
+const versionsLog = "identity_history.jsonl"
+
+// pruneInterval is how often versions past the retention period are pruned
+const pruneInterval = time.Hour
+
+// Sources of identity versions
+const (
+	SourceCreate        = "create"
+	SourceUpdate        = "update"
+	SourcePasswordReset = "password_reset"
+	SourceScan          = "scan"
+	SourceAnonymize     = "anonymize"
+	SourceMerge         = "merge"
+)
+
+// Version is a snapshot of an identity's traits, state and metadata
+type Version struct {
+	IdentityID string `json:"identity_id"`
+	// Version numbers start at 1 and increase with every snapshot of the identity
+	Version int `json:"version"`
+	// Source is what recorded the snapshot, Actor the admin behind it, empty for scans
+	Source         string      `json:"source"`
+	Actor          string      `json:"actor,omitempty"`
+	RecordedAt     time.Time   `json:"recorded_at"`
+	SchemaID       string      `json:"schema_id"`
+	State          string      `json:"state,omitempty"`
+	Traits         interface{} `json:"traits"`
+	MetadataPublic interface{} `json:"metadata_public,omitempty"`
+	MetadataAdmin  interface{} `json:"metadata_admin,omitempty"`
+}
+
+// sameContent reports whether two versions hold the same identity data
+func (v Version) sameContent(other Version) bool {
+	return v.SchemaID == other.SchemaID &&
+		v.State == other.State &&
+		reflect.DeepEqual(v.Traits, other.Traits) &&
+		reflect.DeepEqual(v.MetadataPublic, other.MetadataPublic) &&
+		reflect.DeepEqual(v.MetadataAdmin, other.MetadataAdmin)
+}
+
+// document returns the version's identity data keyed like the Kratos identity fields, for diffs.
+// Empty fields are left out so they do not show up as changes.
+func (v Version) document() map[string]interface{} {
+	doc := map[string]interface{}{}
+	fields := map[string]interface{}{
+		"traits":          v.Traits,
+		"metadata_public": v.MetadataPublic,
+		"metadata_admin":  v.MetadataAdmin,
+	}
+	for key, value := range fields {
+		if value != nil {
+			doc[key] = value
+		}
+	}
+	if v.SchemaID != "" {
+		doc["schema_id"] = v.SchemaID
+	}
+	if v.State != "" {
+		doc["state"] = v.State
+	}
+	return doc
+}
+
+// Diff lists the values that differ between two versions, with JSON pointers such as
+// /traits/email or /state. A zero from lists every value of to.
+func Diff(from, to Version) []schema.FieldChange {
+	return schema.DiffTraits(from.document(), to.document())
+}
+
+// Recorder keeps the versions of every identity of a Kratos target in memory and persists them in the local store
+type Recorder struct {
+	store     *store.Store
+	retention time.Duration
+
+	mu       sync.RWMutex
+	versions map[string][]Version
+}
+
+// NewRecorder loads previously stored versions
+func NewRecorder(st *store.Store, retention time.Duration) (*Recorder, error) {
+	r := &Recorder{store: st, retention: retention, versions: map[string][]Version{}}
+
+	err := st.ReadLines(versionsLog, func(line []byte) error {
+		var v Version
+		if err := json.Unmarshal(line, &v); err != nil {
+			// Skip entries that were only partially written
+			return nil
+		}
+		r.versions[v.IdentityID] = append(r.versions[v.IdentityID], v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Record stores a new version of an identity. Scans only record identities that changed since
+// their latest version, and false is returned when nothing was recorded.
+func (r *Recorder) Record(identity *ory.Identity, source, actor string) (Version, bool, error) {
+	v := Version{
+		IdentityID:     identity.Id,
+		Source:         source,
+		Actor:          actor,
+		RecordedAt:     time.Now().UTC(),
+		SchemaID:       identity.SchemaId,
+		Traits:         identity.Traits,
+		MetadataPublic: identity.MetadataPublic,
+		MetadataAdmin:  identity.MetadataAdmin,
+	}
+	if identity.State != nil {
+		v.State = string(*identity.State)
+	}
+
+	// Normalize the identity data as it would be read back from the store
+	if err := normalize(&v); err != nil {
+		return Version{}, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.versions[v.IdentityID]
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		if source == SourceScan && latest.sameContent(v) {
+			return latest, false, nil
+		}
+		v.Version = latest.Version + 1
+	} else {
+		v.Version = 1
+	}
+
+	if err := r.store.Append(versionsLog, v); err != nil {
+		return Version{}, false, err
+	}
+	r.versions[v.IdentityID] = append(versions, v)
+	return v, true, nil
+}
+
+// List returns the versions of an identity recorded at or before at, newest first.
+// A zero at returns every version.
+func (r *Recorder) List(identityID string, at time.Time) []Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.versions[identityID]
+	result := make([]Version, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		if at.IsZero() || !versions[i].RecordedAt.After(at) {
+			result = append(result, versions[i])
+		}
+	}
+	return result
+}
+
+// Get returns a version of an identity
+func (r *Recorder) Get(identityID string, version int) (Version, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, v := range r.versions[identityID] {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+// Forget drops every version of an identity, e.g. when its personal data is erased
+func (r *Recorder) Forget(identityID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.versions[identityID]; !ok {
+		return nil
+	}
+	delete(r.versions, identityID)
+	return r.rewrite()
+}
+
+// Run prunes the versions immediately and then every pruneInterval until ctx is cancelled.
+// It runs whether or not the scanner is enabled.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Prune(); err != nil {
+			log.Printf("Failed to prune identity history: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune drops the versions superseded before the retention period. The version in effect at
+// the start of the period is kept, so every point in time within it can still be viewed.
+func (r *Recorder) Prune() error {
+	cutoff := time.Now().Add(-r.retention)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pruned := false
+	for id, versions := range r.versions {
+		keep := 0
+		for keep < len(versions)-1 && versions[keep+1].RecordedAt.Before(cutoff) {
+			keep++
+		}
+		if keep > 0 {
+			pruned = true
+			r.versions[id] = append([]Version(nil), versions[keep:]...)
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return r.rewrite()
+}
+
+// rewrite replaces the stored log with the versions in memory. Callers must hold r.mu.
+func (r *Recorder) rewrite() error {
+	var kept []Version
+	for _, versions := range r.versions {
+		kept = append(kept, versions...)
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].RecordedAt.Before(kept[j].RecordedAt) })
+	entries := make([]interface{}, len(kept))
+	for i, v := range kept {
+		entries[i] = v
+	}
+	return r.store.Rewrite(versionsLog, entries)
+}
+
+// normalize round-trips the identity data of a version through JSON so it compares equal to stored versions
+func normalize(v *Version) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	*v = Version{}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,66 @@
+package history
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// Scanner periodically records the identities changed outside of the admin API, e.g. by users
+// in self-service flows or by SCIM and LDAP provisioning
+type Scanner struct {
+	client   *kratos.Client
+	recorder *Recorder
+	interval time.Duration
+}
+
+// NewScanner creates a new history scanner. An interval of 0 disables the scans.
+func NewScanner(client *kratos.Client, recorder *Recorder, interval time.Duration) *Scanner {
+	return &Scanner{client: client, recorder: recorder, interval: interval}
+}
+
+// Run scans immediately and then on every interval until ctx is cancelled
+func (s *Scanner) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		recorded, err := s.Scan(ctx)
+		if err != nil {
+			log.Printf("Failed to scan identity history: %v", err)
+		} else if recorded > 0 {
+			log.Printf("Recorded %d identity versions", recorded)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan records a version of every identity that changed since its latest version and returns how many were recorded
+func (s *Scanner) Scan(ctx context.Context) (int, error) {
+	recorded := 0
+	err := s.client.ForEachIdentityPage(ctx, func(identities []ory.Identity) error {
+		for i := range identities {
+			_, ok, err := s.recorder.Record(&identities[i], SourceScan, "")
+			if err != nil {
+				return err
+			}
+			if ok {
+				recorded++
+			}
+		}
+		return nil
+	})
+	return recorded, err
+}
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// maxFinishedJobs is the number of finished jobs kept in memory
+const maxFinishedJobs = 100
+
+// ErrNotFound is returned when a job does not exist
+var ErrNotFound = errors.New("job not found")
+
+// Job is a snapshot of a background job
+type Job struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Status     Status      `json:"status"`
+	Total      int64       `json:"total"`
+	Processed  int64       `json:"processed"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+// Func is the work performed by a job. The returned result is exposed on the job,
+// even when an error is returned.
+type Func func(ctx context.Context, progress *Progress) (interface{}, error)
+
+// Manager runs jobs in the background and keeps track of their state
+type Manager struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+}
+
+// NewManager creates a new job manager
+func NewManager() *Manager {
+	return &Manager{
+		jobs:    map[string]*Job{},
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// Start runs fn in the background and returns a snapshot of the new job
+func (m *Manager) Start(kind string, fn Func) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        newID(),
+		Kind:      kind,
+		Status:    StatusRunning,
+		StartedAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.cancels[job.ID] = cancel
+	snapshot := *job
+	m.mu.Unlock()
+
+	go m.run(ctx, job.ID, fn)
+	return snapshot
+}
+
+// run executes the job and records its outcome
+func (m *Manager) run(ctx context.Context, id string, fn Func) {
+	result, err := fn(ctx, &Progress{manager: m, id: id})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs[id]
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Result = result
+	switch {
+	case err == nil:
+		job.Status = StatusSucceeded
+	case errors.Is(err, context.Canceled):
+		job.Status = StatusCancelled
+		job.Error = err.Error()
+	default:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	}
+
+	m.cancels[id]()
+	delete(m.cancels, id)
+	m.prune()
+}
+
+// prune drops the oldest finished jobs beyond maxFinishedJobs. Callers must hold m.mu.
+func (m *Manager) prune() {
+	var finished []*Job
+	for _, job := range m.jobs {
+		if job.FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, job.ID)
+	}
+}
+
+// Get returns a snapshot of a job
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+// List returns snapshots of all known jobs of the given kind, newest first.
+// An empty kind returns jobs of every kind.
+func (m *Manager) List(kind string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if kind == "" || job.Kind == kind {
+			result = append(result, *job)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].StartedAt.After(result[j].StartedAt) })
+	return result
+}
+
+// Cancel requests cancellation of a running job
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	if cancel, ok := m.cancels[id]; ok {
+		cancel()
+	}
+	return nil
+}
+
+// Progress lets a running job report how far along it is. A nil Progress ignores the reports,
+// for running job functions outside of a manager.
+type Progress struct {
+	manager *Manager
+	id      string
+}
+
+// SetTotal sets the number of items the job expects to process
+func (p *Progress) SetTotal(total int64) {
+	if p == nil {
+		return
+	}
+	p.manager.mu.Lock()
+	defer p.manager.mu.Unlock()
+	p.manager.jobs[p.id].Total = total
+}
+
+// Add records that n more items have been processed
+func (p *Progress) Add(n int64) {
+	if p == nil {
+		return
+	}
+	p.manager.mu.Lock()
+	defer p.manager.mu.Unlock()
+	p.manager.jobs[p.id].Processed += n
+}
+
+// newID returns a random job identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
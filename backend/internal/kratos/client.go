@@ -5,26 +5,35 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	ory "github.com/ory/kratos-client-go"
 )
 
 // Client wraps the Ory Kratos admin API client
 type Client struct {
-	api       *ory.APIClient
-	publicURL string
+	api        *ory.APIClient
+	httpClient *http.Client
+	publicURL  string
+	observer   Observer
 }
 
 // NewClient creates a new Kratos client
 func NewClient(adminURL string) *Client {
+	c := &Client{}
+	c.httpClient = &http.Client{
+		Transport: &observedTransport{client: c, next: http.DefaultTransport},
+	}
+
 	config := ory.NewConfiguration()
 	config.Servers = []ory.ServerConfiguration{
 		{URL: adminURL},
 	}
+	config.HTTPClient = c.httpClient
 
-	return &Client{
-		api: ory.NewAPIClient(config),
-	}
+	c.api = ory.NewAPIClient(config)
+	return c
 }
 
 // SetPublicURL sets the public URL for the client
@@ -32,6 +41,11 @@ func (c *Client) SetPublicURL(publicURL string) {
 	c.publicURL = publicURL
 }
 
+// SetAdminToken sets a bearer token sent with every admin API request
+func (c *Client) SetAdminToken(token string) {
+	c.api.GetConfig().AddDefaultHeader("Authorization", "Bearer "+token)
+}
+
 // ListIdentitiesResult contains the list of identities and pagination info
 type ListIdentitiesResult struct {
 	Identities []ory.Identity
@@ -40,6 +54,8 @@ type ListIdentitiesResult struct {
 
 // ListIdentities retrieves a paginated list of identities
 func (c *Client) ListIdentities(ctx context.Context, page, perPage int64) (*ListIdentitiesResult, error) {
+	ctx = withOperation(ctx, "ListIdentities")
+
 	// First, get the total count by fetching with a large page size
 	// Kratos doesn't have a native count endpoint, so we need this workaround
 	allIdentities, _, err := c.api.IdentityApi.ListIdentities(ctx).PerPage(10000).Execute()
@@ -69,8 +85,48 @@ func (c *Client) ListIdentities(ctx context.Context, page, perPage int64) (*List
 	}, nil
 }
 
+// identityPageSize is the page size used when iterating over all identities
+const identityPageSize = 500
+
+// ForEachIdentityPage pages through all identities and calls fn for every page.
+// Iteration stops at the first error returned by Kratos or fn.
+func (c *Client) ForEachIdentityPage(ctx context.Context, fn func(identities []ory.Identity) error) error {
+	ctx = withOperation(ctx, "ForEachIdentityPage")
+
+	for page := int64(1); ; page++ {
+		identities, _, err := c.api.IdentityApi.ListIdentities(ctx).Page(page).PerPage(identityPageSize).Execute()
+		if err != nil {
+			return err
+		}
+
+		if len(identities) > 0 {
+			if err := fn(identities); err != nil {
+				return err
+			}
+		}
+
+		if len(identities) < identityPageSize {
+			return nil
+		}
+	}
+}
+
+// FindIdentitiesByIdentifier retrieves the identities having a credential with the given identifier
+func (c *Client) FindIdentitiesByIdentifier(ctx context.Context, identifier string) ([]ory.Identity, error) {
+	ctx = withOperation(ctx, "FindIdentitiesByIdentifier")
+
+	identities, _, err := c.api.IdentityApi.ListIdentities(ctx).CredentialsIdentifier(identifier).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
 // GetIdentity retrieves a single identity by ID
 func (c *Client) GetIdentity(ctx context.Context, id string) (*ory.Identity, error) {
+	ctx = withOperation(ctx, "GetIdentity")
+
 	identity, _, err := c.api.IdentityApi.GetIdentity(ctx, id).Execute()
 	if err != nil {
 		return nil, err
@@ -81,6 +137,8 @@ func (c *Client) GetIdentity(ctx context.Context, id string) (*ory.Identity, err
 
 // CreateIdentity creates a new identity
 func (c *Client) CreateIdentity(ctx context.Context, body ory.CreateIdentityBody) (*ory.Identity, error) {
+	ctx = withOperation(ctx, "CreateIdentity")
+
 	identity, _, err := c.api.IdentityApi.CreateIdentity(ctx).CreateIdentityBody(body).Execute()
 	if err != nil {
 		return nil, err
@@ -91,6 +149,8 @@ func (c *Client) CreateIdentity(ctx context.Context, body ory.CreateIdentityBody
 
 // UpdateIdentity updates an existing identity
 func (c *Client) UpdateIdentity(ctx context.Context, id string, body ory.UpdateIdentityBody) (*ory.Identity, error) {
+	ctx = withOperation(ctx, "UpdateIdentity")
+
 	identity, _, err := c.api.IdentityApi.UpdateIdentity(ctx, id).UpdateIdentityBody(body).Execute()
 	if err != nil {
 		return nil, err
@@ -101,12 +161,16 @@ func (c *Client) UpdateIdentity(ctx context.Context, id string, body ory.UpdateI
 
 // DeleteIdentity deletes an identity
 func (c *Client) DeleteIdentity(ctx context.Context, id string) error {
+	ctx = withOperation(ctx, "DeleteIdentity")
+
 	_, err := c.api.IdentityApi.DeleteIdentity(ctx, id).Execute()
 	return err
 }
 
 // GetIdentitySessions retrieves sessions for an identity
 func (c *Client) GetIdentitySessions(ctx context.Context, id string) ([]ory.Session, error) {
+	ctx = withOperation(ctx, "GetIdentitySessions")
+
 	sessions, _, err := c.api.IdentityApi.ListIdentitySessions(ctx, id).Execute()
 	if err != nil {
 		return nil, err
@@ -117,6 +181,8 @@ func (c *Client) GetIdentitySessions(ctx context.Context, id string) ([]ory.Sess
 
 // ListSessions retrieves all sessions
 func (c *Client) ListSessions(ctx context.Context, page, perPage int64) ([]ory.Session, error) {
+	ctx = withOperation(ctx, "ListSessions")
+
 	req := c.api.IdentityApi.ListSessions(ctx)
 	req = req.PageSize(perPage)
 
@@ -128,12 +194,84 @@ func (c *Client) ListSessions(ctx context.Context, page, perPage int64) ([]ory.S
 	return sessions, nil
 }
 
+// sessionPageSize is the page size used when iterating over all sessions
+const sessionPageSize = 500
+
+// ForEachSessionPage pages through all sessions and calls fn for every page.
+// If active is non-nil, only sessions with that active state are returned.
+// expand lists the session relations to include, e.g. "identity" or "devices".
+func (c *Client) ForEachSessionPage(ctx context.Context, active *bool, expand []string, fn func(sessions []ory.Session) error) error {
+	ctx = withOperation(ctx, "ForEachSessionPage")
+
+	token := ""
+	for {
+		req := c.api.IdentityApi.ListSessions(ctx).PageSize(sessionPageSize)
+		if active != nil {
+			req = req.Active(*active)
+		}
+		if len(expand) > 0 {
+			req = req.Expand(expand)
+		}
+		if token != "" {
+			req = req.PageToken(token)
+		}
+
+		sessions, resp, err := req.Execute()
+		if err != nil {
+			return err
+		}
+
+		if len(sessions) > 0 {
+			if err := fn(sessions); err != nil {
+				return err
+			}
+		}
+
+		token = nextPageToken(resp)
+		if token == "" || len(sessions) == 0 {
+			return nil
+		}
+	}
+}
+
+// nextPageToken extracts the page token of the rel="next" link from a Kratos list response
+func nextPageToken(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || !strings.Contains(strings.Join(parts[1:], ";"), `rel="next"`) {
+			continue
+		}
+
+		target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		u, err := url.Parse(target)
+		if err != nil {
+			return ""
+		}
+		return u.Query().Get("page_token")
+	}
+	return ""
+}
+
 // RevokeSession revokes a session by ID
 func (c *Client) RevokeSession(ctx context.Context, id string) error {
+	ctx = withOperation(ctx, "RevokeSession")
+
 	_, err := c.api.IdentityApi.DisableSession(ctx, id).Execute()
 	return err
 }
 
+// RevokeIdentitySessions revokes every session of an identity
+func (c *Client) RevokeIdentitySessions(ctx context.Context, id string) error {
+	ctx = withOperation(ctx, "RevokeIdentitySessions")
+
+	_, err := c.api.IdentityApi.DeleteIdentitySessions(ctx, id).Execute()
+	return err
+}
+
 // IdentitySchemaWithContent represents a schema with its full content
 type IdentitySchemaWithContent struct {
 	ID     string                 `json:"id"`
@@ -142,12 +280,19 @@ type IdentitySchemaWithContent struct {
 
 // ListIdentitySchemas retrieves all identity schemas from the public API
 func (c *Client) ListIdentitySchemas(ctx context.Context) ([]IdentitySchemaWithContent, error) {
+	ctx = withOperation(ctx, "ListIdentitySchemas")
+
 	if c.publicURL == "" {
 		return nil, fmt.Errorf("public URL not configured")
 	}
 
 	// Fetch schema list from public API - it includes full schema content inline
-	resp, err := http.Get(c.publicURL + "/schemas")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.publicURL+"/schemas", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schemas: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch schemas: %w", err)
 	}
@@ -165,64 +310,41 @@ func (c *Client) ListIdentitySchemas(ctx context.Context) ([]IdentitySchemaWithC
 	return schemas, nil
 }
 
-// GetIdentityCount returns the total count of identities
-func (c *Client) GetIdentityCount(ctx context.Context) (int64, error) {
-	// Kratos doesn't have a direct count endpoint, so we fetch all identities
-	identities, _, err := c.api.IdentityApi.ListIdentities(ctx).PerPage(10000).Execute()
-	if err != nil {
-		return 0, err
-	}
-
-	return int64(len(identities)), nil
-}
+// GetIdentitySchema retrieves a single identity schema by ID from the admin API
+func (c *Client) GetIdentitySchema(ctx context.Context, id string) (map[string]interface{}, error) {
+	ctx = withOperation(ctx, "GetIdentitySchema")
 
-// GetActiveIdentityCount returns the count of active identities
-func (c *Client) GetActiveIdentityCount(ctx context.Context) (int64, error) {
-	// Fetch all identities and count active ones
-	identities, _, err := c.api.IdentityApi.ListIdentities(ctx).PerPage(10000).Execute()
+	schema, _, err := c.api.IdentityApi.GetIdentitySchema(ctx, id).Execute()
 	if err != nil {
-		return 0, err
-	}
-
-	var count int64
-	for _, identity := range identities {
-		if identity.State != nil && string(*identity.State) == "active" {
-			count++
-		}
+		return nil, err
 	}
 
-	return count, nil
+	return schema, nil
 }
 
-// GetSessionCount returns the total count of active sessions
-func (c *Client) GetSessionCount(ctx context.Context) (int64, error) {
-	sessions, _, err := c.api.IdentityApi.ListSessions(ctx).Active(true).Execute()
-	if err != nil {
-		return 0, err
-	}
-
-	return int64(len(sessions)), nil
-}
+// ResetPassword sets a new password for an identity and returns the updated identity
+func (c *Client) ResetPassword(ctx context.Context, id string, newPassword string) (*ory.Identity, error) {
+	ctx = withOperation(ctx, "ResetPassword")
 
-// ResetPassword sets a new password for an identity
-func (c *Client) ResetPassword(ctx context.Context, id string, newPassword string) error {
 	// First, get the current identity to preserve its data
 	identity, _, err := c.api.IdentityApi.GetIdentity(ctx, id).Execute()
 	if err != nil {
-		return fmt.Errorf("failed to get identity: %w", err)
+		return nil, fmt.Errorf("failed to get identity: %w", err)
 	}
 
 	// Convert traits to map[string]interface{}
 	traits, ok := identity.Traits.(map[string]interface{})
 	if !ok {
-		return fmt.Errorf("failed to convert traits to map")
+		return nil, fmt.Errorf("failed to convert traits to map")
 	}
 
 	// Build update body with new password credentials
 	body := ory.UpdateIdentityBody{
-		SchemaId: identity.SchemaId,
-		Traits:   traits,
-		State:    *identity.State,
+		SchemaId:       identity.SchemaId,
+		Traits:         traits,
+		State:          *identity.State,
+		MetadataPublic: identity.MetadataPublic,
+		MetadataAdmin:  identity.MetadataAdmin,
 		Credentials: &ory.IdentityWithCredentials{
 			Password: &ory.IdentityWithCredentialsPassword{
 				Config: &ory.IdentityWithCredentialsPasswordConfig{
@@ -232,16 +354,18 @@ func (c *Client) ResetPassword(ctx context.Context, id string, newPassword strin
 		},
 	}
 
-	_, _, err = c.api.IdentityApi.UpdateIdentity(ctx, id).UpdateIdentityBody(body).Execute()
+	updated, _, err := c.api.IdentityApi.UpdateIdentity(ctx, id).UpdateIdentityBody(body).Execute()
 	if err != nil {
-		return fmt.Errorf("failed to update identity with new password: %w", err)
+		return nil, fmt.Errorf("failed to update identity with new password: %w", err)
 	}
 
-	return nil
+	return updated, nil
 }
 
 // DeleteCredential deletes a specific credential type for an identity
 func (c *Client) DeleteCredential(ctx context.Context, id string, credentialType string) error {
+	ctx = withOperation(ctx, "DeleteCredential")
+
 	_, err := c.api.IdentityApi.DeleteIdentityCredentials(ctx, id, credentialType).Execute()
 	if err != nil {
 		return fmt.Errorf("failed to delete %s credentials: %w", credentialType, err)
@@ -251,6 +375,8 @@ func (c *Client) DeleteCredential(ctx context.Context, id string, credentialType
 
 // GetIdentityWithCredentials retrieves a single identity by ID including credentials metadata
 func (c *Client) GetIdentityWithCredentials(ctx context.Context, id string) (*ory.Identity, error) {
+	ctx = withOperation(ctx, "GetIdentityWithCredentials")
+
 	identity, _, err := c.api.IdentityApi.GetIdentity(ctx, id).IncludeCredential([]string{"totp", "password", "oidc", "webauthn", "lookup_secret"}).Execute()
 	if err != nil {
 		return nil, err
@@ -258,3 +384,137 @@ func (c *Client) GetIdentityWithCredentials(ctx context.Context, id string) (*or
 
 	return identity, nil
 }
+
+// CourierMessageFilter filters and paginates courier messages
+type CourierMessageFilter struct {
+	Status    string
+	Recipient string
+	PageSize  int64
+	PageToken string
+}
+
+// ListCourierMessagesResult contains a page of courier messages and the token of the next page
+type ListCourierMessagesResult struct {
+	Messages      []ory.Message
+	NextPageToken string
+}
+
+// ListCourierMessages retrieves a page of courier messages
+func (c *Client) ListCourierMessages(ctx context.Context, filter CourierMessageFilter) (*ListCourierMessagesResult, error) {
+	ctx = withOperation(ctx, "ListCourierMessages")
+
+	req := c.api.CourierApi.ListCourierMessages(ctx)
+	if filter.PageSize > 0 {
+		req = req.PageSize(filter.PageSize)
+	}
+	if filter.PageToken != "" {
+		req = req.PageToken(filter.PageToken)
+	}
+	if filter.Status != "" {
+		req = req.Status(ory.CourierMessageStatus(filter.Status))
+	}
+	if filter.Recipient != "" {
+		req = req.Recipient(filter.Recipient)
+	}
+
+	messages, resp, err := req.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListCourierMessagesResult{
+		Messages:      messages,
+		NextPageToken: nextPageToken(resp),
+	}, nil
+}
+
+// GetCourierMessage retrieves a single courier message including its dispatch attempts
+func (c *Client) GetCourierMessage(ctx context.Context, id string) (*ory.Message, error) {
+	ctx = withOperation(ctx, "GetCourierMessage")
+
+	message, _, err := c.api.CourierApi.GetCourierMessage(ctx, id).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// courierPageSize is the page size used when iterating over courier messages
+const courierPageSize = 500
+
+// ForEachCourierMessagePage pages through courier messages, newest first, and calls fn for every page.
+// Iteration stops at the first error returned by Kratos or fn.
+func (c *Client) ForEachCourierMessagePage(ctx context.Context, fn func(messages []ory.Message) error) error {
+	ctx = withOperation(ctx, "ForEachCourierMessagePage")
+
+	token := ""
+	for {
+		req := c.api.CourierApi.ListCourierMessages(ctx).PageSize(courierPageSize)
+		if token != "" {
+			req = req.PageToken(token)
+		}
+
+		messages, resp, err := req.Execute()
+		if err != nil {
+			return err
+		}
+
+		if len(messages) > 0 {
+			if err := fn(messages); err != nil {
+				return err
+			}
+		}
+
+		token = nextPageToken(resp)
+		if token == "" || len(messages) == 0 {
+			return nil
+		}
+	}
+}
+
+// CheckAdminReady checks the readiness endpoint of the Kratos admin API
+func (c *Client) CheckAdminReady(ctx context.Context) error {
+	ctx = withOperation(ctx, "CheckAdminReady")
+
+	_, _, err := c.api.MetadataApi.IsReady(ctx).Execute()
+	return err
+}
+
+// CheckPublicReady checks the readiness endpoint of the Kratos public API
+func (c *Client) CheckPublicReady(ctx context.Context) error {
+	ctx = withOperation(ctx, "CheckPublicReady")
+
+	if c.publicURL == "" {
+		return fmt.Errorf("public URL not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.publicURL+"/health/ready", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// GetVersion returns the version of the Kratos server
+func (c *Client) GetVersion(ctx context.Context) (string, error) {
+	ctx = withOperation(ctx, "GetVersion")
+
+	version, _, err := c.api.MetadataApi.GetVersion(ctx).Execute()
+	if err != nil {
+		return "", err
+	}
+
+	return version.Version, nil
+}
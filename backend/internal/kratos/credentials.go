@@ -0,0 +1,99 @@
+package kratos
+
+import (
+	"sort"
+
+	ory "github.com/ory/kratos-client-go"
+)
+
+// ExportCredentials converts the credentials Kratos allows importing: password hashes and OIDC links.
+// It also returns the types that were exported and the types that cannot be copied, either
+// because Kratos cannot import them or because the source did not export their secrets.
+func ExportCredentials(identity *ory.Identity) (*ory.IdentityWithCredentials, []string, []string) {
+	if identity.Credentials == nil {
+		return nil, []string{}, []string{}
+	}
+
+	creds := &ory.IdentityWithCredentials{}
+	exported, unsupported := []string{}, []string{}
+	for credType, cred := range *identity.Credentials {
+		switch credType {
+		case "password":
+			hash, ok := cred.Config["hashed_password"].(string)
+			if !ok || hash == "" {
+				unsupported = append(unsupported, credType)
+				continue
+			}
+			creds.Password = &ory.IdentityWithCredentialsPassword{
+				Config: &ory.IdentityWithCredentialsPasswordConfig{HashedPassword: &hash},
+			}
+			exported = append(exported, credType)
+		case "oidc":
+			providers := oidcProviders(cred.Config)
+			if len(providers) == 0 {
+				unsupported = append(unsupported, credType)
+				continue
+			}
+			creds.Oidc = &ory.IdentityWithCredentialsOidc{
+				Config: &ory.IdentityWithCredentialsOidcConfig{Providers: providers},
+			}
+			exported = append(exported, credType)
+		default:
+			if len(cred.Identifiers) > 0 || len(cred.Config) > 0 {
+				unsupported = append(unsupported, credType)
+			}
+		}
+	}
+	sort.Strings(exported)
+	sort.Strings(unsupported)
+
+	if len(exported) == 0 {
+		return nil, exported, unsupported
+	}
+	return creds, exported, unsupported
+}
+
+// oidcProviders extracts the provider and subject pairs from an OIDC credential config
+func oidcProviders(config map[string]interface{}) []ory.IdentityWithCredentialsOidcConfigProvider {
+	list, _ := config["providers"].([]interface{})
+
+	var providers []ory.IdentityWithCredentialsOidcConfigProvider
+	for _, item := range list {
+		entry, _ := item.(map[string]interface{})
+		provider, _ := entry["provider"].(string)
+		subject, _ := entry["subject"].(string)
+		if provider != "" && subject != "" {
+			providers = append(providers, ory.IdentityWithCredentialsOidcConfigProvider{Provider: provider, Subject: subject})
+		}
+	}
+	return providers
+}
+
+// RedactCredentials replaces the credentials of an identity with copies without their configs,
+// which hold password hashes, TOTP keys and recovery codes
+func RedactCredentials(identity *ory.Identity) {
+	if identity.Credentials == nil {
+		return
+	}
+	creds := make(map[string]ory.IdentityCredentials, len(*identity.Credentials))
+	for credType, cred := range *identity.Credentials {
+		cred.Config = nil
+		creds[credType] = cred
+	}
+	identity.Credentials = &creds
+}
+
+// VerifiableAddresses copies the verification status of the identity's addresses
+func VerifiableAddresses(identity *ory.Identity) []ory.VerifiableIdentityAddress {
+	addresses := make([]ory.VerifiableIdentityAddress, 0, len(identity.VerifiableAddresses))
+	for _, address := range identity.VerifiableAddresses {
+		addresses = append(addresses, ory.VerifiableIdentityAddress{
+			Value:      address.Value,
+			Via:        address.Via,
+			Status:     address.Status,
+			Verified:   address.Verified,
+			VerifiedAt: address.VerifiedAt,
+		})
+	}
+	return addresses
+}
@@ -0,0 +1,21 @@
+package kratos
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	ory "github.com/ory/kratos-client-go"
+)
+
+// StatusCode returns the HTTP status code of a failed Kratos API call, or 0 if no response was received
+func StatusCode(err error) int {
+	var apiErr *ory.GenericOpenAPIError
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+
+	// The generated client only keeps the response status line, e.g. "409 Conflict"
+	code, _ := strconv.Atoi(strings.SplitN(apiErr.Error(), " ", 2)[0])
+	return code
+}
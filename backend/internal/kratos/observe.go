@@ -0,0 +1,51 @@
+package kratos
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Observer is notified about every HTTP request the client sends to Kratos
+type Observer interface {
+	// ObserveKratosRequest is called once per request. statusCode is 0 if no response was received.
+	ObserveKratosRequest(operation string, duration time.Duration, statusCode int, err error)
+}
+
+// SetObserver registers an observer for Kratos requests. It must be called before the client is used.
+func (c *Client) SetObserver(observer Observer) {
+	c.observer = observer
+}
+
+type operationKey struct{}
+
+// withOperation names the client method issuing requests with ctx
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// observedTransport reports every round trip to the client's observer
+type observedTransport struct {
+	client *Client
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *observedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	if observer := t.client.observer; observer != nil {
+		operation, ok := req.Context().Value(operationKey{}).(string)
+		if !ok {
+			operation = "other"
+		}
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		observer.ObserveKratosRequest(operation, time.Since(start), statusCode, err)
+	}
+
+	return resp, err
+}
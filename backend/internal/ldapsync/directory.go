@@ -0,0 +1,104 @@
+package ldapsync
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/config"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// searchPageSize is the number of entries requested per LDAP search page
+const searchPageSize = 500
+
+// Entry is a user read from the directory. Attribute names are lower case.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Value returns the first value of an attribute
+func (e Entry) Value(name string) (string, bool) {
+	values := e.Attributes[strings.ToLower(name)]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// Directory lists the users to synchronize. LDAPDirectory reads them from an LDAP server,
+// other implementations can stand in for it.
+type Directory interface {
+	Users(ctx context.Context, attributes []string) ([]Entry, error)
+}
+
+// LDAPDirectory searches users in an LDAP server
+type LDAPDirectory struct {
+	config config.LDAPSyncConfig
+}
+
+// NewLDAPDirectory creates a directory reading users below the configured base DN
+func NewLDAPDirectory(cfg config.LDAPSyncConfig) *LDAPDirectory {
+	return &LDAPDirectory{config: cfg}
+}
+
+// Users returns the entries matching the user filter, with the given attributes
+func (d *LDAPDirectory) Users(ctx context.Context, attributes []string) ([]Entry, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+
+	// The LDAP client has no context support, closing the connection aborts the search
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if d.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind to LDAP server: %w", err)
+		}
+	}
+
+	request := ldap.NewSearchRequest(
+		d.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		d.config.Filter,
+		attributes,
+		nil,
+	)
+	result, err := conn.SearchWithPaging(request, searchPageSize)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to search LDAP users: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		entry := Entry{DN: e.DN, Attributes: map[string][]string{}}
+		for _, attribute := range e.Attributes {
+			name := strings.ToLower(attribute.Name)
+			entry.Attributes[name] = append(entry.Attributes[name], attribute.Values...)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,72 @@
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+)
+
+// ErrRunning is returned when a sync is started while another one is running
+var ErrRunning = errors.New("an LDAP sync is already running")
+
+// Scheduler starts sync runs as background jobs, periodically and on demand
+type Scheduler struct {
+	syncer   *Syncer
+	jobs     *jobs.Manager
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+// NewScheduler creates a new sync scheduler. An interval of 0 disables periodic runs.
+func NewScheduler(syncer *Syncer, manager *jobs.Manager, interval time.Duration) *Scheduler {
+	return &Scheduler{syncer: syncer, jobs: manager, interval: interval}
+}
+
+// Start starts a sync run unless one is already running
+func (s *Scheduler) Start(dryRun bool) (jobs.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs.List(JobKind) {
+		if job.Status == jobs.StatusRunning {
+			return jobs.Job{}, ErrRunning
+		}
+	}
+
+	return s.jobs.Start(JobKind, func(ctx context.Context, progress *jobs.Progress) (interface{}, error) {
+		report, err := s.syncer.Run(ctx, dryRun, progress)
+		if err != nil {
+			log.Printf("LDAP sync failed: %v", err)
+		} else {
+			log.Printf("LDAP sync finished: %d created, %d updated, %d deactivated, %d reactivated, %d failed",
+				report.Created, report.Updated, report.Deactivated, report.Reactivated, report.Failed)
+		}
+		return report, err
+	}), nil
+}
+
+// Run starts a sync immediately and then on every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Start(false); err != nil {
+			log.Printf("Skipping scheduled LDAP sync: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package ldapsync
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// JobKind identifies LDAP sync runs in the job manager
+const JobKind = "ldap_sync"
+
+// Admin metadata keys of synchronized identities
+const (
+	metadataID = "ldap_id"
+	metadataDN = "ldap_dn"
+	// metadataDeactivated marks identities deactivated by the sync, which are reactivated when they reappear
+	metadataDeactivated = "ldap_deactivated"
+)
+
+// Actions taken for a directory user or synchronized identity
+const (
+	ActionCreate     = "create"
+	ActionUpdate     = "update"
+	ActionDeactivate = "deactivate"
+	ActionReactivate = "reactivate"
+	ActionUnchanged  = "unchanged"
+)
+
+const (
+	maxFailures = 1000
+	maxChanges  = 1000
+	// eventBatchSize is the number of written identities after which their events are published
+	eventBatchSize = 100
+)
+
+// DefaultMapping maps inetOrgPerson attributes to the traits of the Kratos preset identity schema
+var DefaultMapping = map[string]string{
+	"mail":      "/email",
+	"givenName": "/name/first",
+	"sn":        "/name/last",
+}
+
+// Failure describes a directory user or identity that could not be synchronized
+type Failure struct {
+	DN         string `json:"dn,omitempty"`
+	IdentityID string `json:"identity_id,omitempty"`
+	Error      string `json:"error"`
+}
+
+// Change describes what is, or would be in a dry run, done with a directory user or identity
+type Change struct {
+	Action     string               `json:"action"`
+	DN         string               `json:"dn,omitempty"`
+	IdentityID string               `json:"identity_id,omitempty"`
+	Traits     []schema.FieldChange `json:"traits,omitempty"`
+}
+
+// Report summarizes a sync run
+type Report struct {
+	DryRun bool `json:"dry_run"`
+	// Entries is the number of directory users, Managed the number of identities synchronized before the run
+	Entries     int64     `json:"entries"`
+	Managed     int64     `json:"managed"`
+	Created     int64     `json:"created"`
+	Updated     int64     `json:"updated"`
+	Deactivated int64     `json:"deactivated"`
+	Reactivated int64     `json:"reactivated"`
+	Unchanged   int64     `json:"unchanged"`
+	Failed      int64     `json:"failed"`
+	Failures    []Failure `json:"failures"`
+	Changes     []Change  `json:"changes"`
+}
+
+// record adds the outcome of a directory user or identity to the report
+func (r *Report) record(change *Change, err error) {
+	if err != nil {
+		r.Failed++
+		if len(r.Failures) < maxFailures {
+			r.Failures = append(r.Failures, Failure{DN: change.DN, IdentityID: change.IdentityID, Error: err.Error()})
+		}
+		return
+	}
+
+	switch change.Action {
+	case ActionCreate:
+		r.Created++
+	case ActionUpdate:
+		r.Updated++
+	case ActionDeactivate:
+		r.Deactivated++
+	case ActionReactivate:
+		r.Reactivated++
+	case ActionUnchanged:
+		r.Unchanged++
+		return
+	}
+	if len(r.Changes) < maxChanges {
+		r.Changes = append(r.Changes, *change)
+	}
+}
+
+// Syncer synchronizes directory users into Kratos identities
+type Syncer struct {
+	directory   Directory
+	client      *kratos.Client
+	events      *webhooks.Publisher
+	schemaID    string
+	idAttribute string
+	mapping     map[string]string
+}
+
+// NewSyncer creates a syncer creating identities of schemaID and publishing the identities it
+// writes to events. idAttribute holds the stable identifier of a directory user and mapping maps
+// attribute names to JSON pointers into the traits.
+func NewSyncer(directory Directory, client *kratos.Client, events *webhooks.Publisher, schemaID, idAttribute string, mapping map[string]string) (*Syncer, error) {
+	if len(mapping) == 0 {
+		return nil, errors.New("the LDAP attribute mapping is empty")
+	}
+	for attribute, pointer := range mapping {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("trait pointer %q of LDAP attribute %q must start with /", pointer, attribute)
+		}
+	}
+
+	return &Syncer{
+		directory:   directory,
+		client:      client,
+		events:      events,
+		schemaID:    schemaID,
+		idAttribute: idAttribute,
+		mapping:     mapping,
+	}, nil
+}
+
+// attributes returns the directory attributes read by the sync
+func (s *Syncer) attributes() []string {
+	attributes := []string{s.idAttribute}
+	for attribute := range s.mapping {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes[1:])
+	return attributes
+}
+
+// Run creates identities for new directory users, updates the traits of existing ones and
+// deactivates identities whose user left the directory. In a dry run nothing is written.
+// Identities are matched by the ID attribute stored in their admin metadata. When creating an
+// identity conflicts with one that lost this link, it is matched by its credential identifier
+// if its owner verified that address. The report is returned even when the run fails part way through.
+func (s *Syncer) Run(ctx context.Context, dryRun bool, progress *jobs.Progress) (*Report, error) {
+	report := &Report{DryRun: dryRun, Failures: []Failure{}, Changes: []Change{}}
+
+	entries, err := s.directory.Users(ctx, s.attributes())
+	if err != nil {
+		return report, fmt.Errorf("failed to read directory users: %w", err)
+	}
+	report.Entries = int64(len(entries))
+
+	managed := map[string]ory.Identity{}
+	err = s.client.ForEachIdentityPage(ctx, func(page []ory.Identity) error {
+		for _, identity := range page {
+			if id := metadataString(identity.MetadataAdmin, metadataID); id != "" {
+				managed[id] = identity
+			}
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list identities: %w", err)
+	}
+	report.Managed = int64(len(managed))
+
+	// An empty result is more likely a wrong filter than everyone leaving
+	if len(entries) == 0 && len(managed) > 0 {
+		return report, fmt.Errorf("the directory returned no users, refusing to deactivate %d identities", len(managed))
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if id, ok := s.entryID(entry); ok {
+			seen[id] = true
+		}
+	}
+	var missing []ory.Identity
+	for id, identity := range managed {
+		if !seen[id] {
+			missing = append(missing, identity)
+		}
+	}
+	progress.SetTotal(int64(len(entries) + len(missing)))
+
+	var created, updated []interface{}
+	flush := func() {
+		s.events.PublishBatch(webhooks.EventIdentityCreated, webhooks.ActorLDAPSync, created)
+		s.events.PublishBatch(webhooks.EventIdentityUpdated, webhooks.ActorLDAPSync, updated)
+		created, updated = nil, nil
+	}
+	defer flush()
+	record := func(change *Change, written *ory.Identity, err error) {
+		report.record(change, err)
+		if written == nil || err != nil {
+			return
+		}
+		if change.Action == ActionCreate {
+			created = append(created, written)
+		} else {
+			updated = append(updated, written)
+		}
+		if len(created)+len(updated) >= eventBatchSize {
+			flush()
+		}
+	}
+
+	processed := map[string]bool{}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		id, ok := s.entryID(entry)
+		switch {
+		case !ok:
+			report.record(&Change{DN: entry.DN}, fmt.Errorf("missing %s attribute", s.idAttribute))
+		case processed[id]:
+			report.record(&Change{DN: entry.DN}, fmt.Errorf("duplicate %s %q", s.idAttribute, id))
+		default:
+			processed[id] = true
+			var existing *ory.Identity
+			if identity, ok := managed[id]; ok {
+				existing = &identity
+			}
+			record(s.syncEntry(ctx, entry, id, existing, dryRun))
+		}
+		progress.Add(1)
+	}
+
+	for i := range missing {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		record(s.deactivate(ctx, &missing[i], dryRun))
+		progress.Add(1)
+	}
+
+	return report, nil
+}
+
+// entryID returns the stable identifier of a directory user. Binary values such as
+// Active Directory's objectGUID are hex encoded.
+func (s *Syncer) entryID(entry Entry) (string, bool) {
+	id, ok := entry.Value(s.idAttribute)
+	if !ok || id == "" {
+		return "", false
+	}
+	if !utf8.ValidString(id) {
+		id = hex.EncodeToString([]byte(id))
+	}
+	return id, true
+}
+
+// syncEntry creates the identity of a directory user, or updates it when it already exists.
+// It returns the identity written to Kratos, nil in a dry run or when nothing changed.
+func (s *Syncer) syncEntry(ctx context.Context, entry Entry, id string, existing *ory.Identity, dryRun bool) (*Change, *ory.Identity, error) {
+	change := &Change{DN: entry.DN}
+
+	if existing == nil {
+		traits := map[string]interface{}{}
+		if err := s.applyAttributes(traits, entry); err != nil {
+			return change, nil, err
+		}
+
+		change.Action = ActionCreate
+		change.Traits = schema.DiffTraits(nil, traits)
+		if dryRun {
+			return change, nil, nil
+		}
+
+		state := ory.IDENTITYSTATE_ACTIVE
+		created, err := s.client.CreateIdentity(ctx, ory.CreateIdentityBody{
+			SchemaId:      s.schemaID,
+			Traits:        traits,
+			State:         &state,
+			MetadataAdmin: map[string]interface{}{metadataID: id, metadataDN: entry.DN},
+		})
+		if kratos.StatusCode(err) == http.StatusConflict {
+			// The identity may exist with its link lost, e.g. by an edit that dropped its metadata
+			unlinked, findErr := s.findUnlinked(ctx, entry)
+			if findErr != nil {
+				return change, nil, findErr
+			}
+			if unlinked == nil {
+				return change, nil, fmt.Errorf("an identity that is not synchronized already uses one of the identifiers: %w", err)
+			}
+			return s.syncEntry(ctx, entry, id, unlinked, dryRun)
+		}
+		if err != nil {
+			return change, nil, err
+		}
+		change.IdentityID = created.Id
+		return change, created, nil
+	}
+
+	change.IdentityID = existing.Id
+	current, _ := existing.Traits.(map[string]interface{})
+	traits, err := cloneObject(current)
+	if err != nil {
+		return change, nil, err
+	}
+	if err := s.applyAttributes(traits, entry); err != nil {
+		return change, nil, err
+	}
+	metadata, err := cloneObject(existing.MetadataAdmin)
+	if err != nil {
+		return change, nil, err
+	}
+
+	change.Action = ActionUpdate
+	change.Traits = schema.DiffTraits(current, traits)
+
+	state := ory.IDENTITYSTATE_ACTIVE
+	if existing.State != nil {
+		state = *existing.State
+	}
+	if state == ory.IDENTITYSTATE_INACTIVE && metadata[metadataDeactivated] == true {
+		state = ory.IDENTITYSTATE_ACTIVE
+		delete(metadata, metadataDeactivated)
+		change.Action = ActionReactivate
+	}
+
+	relinked := metadata[metadataID] != id || metadata[metadataDN] != entry.DN
+	metadata[metadataID] = id
+	metadata[metadataDN] = entry.DN
+
+	if change.Action == ActionUpdate && len(change.Traits) == 0 && !relinked {
+		change.Action = ActionUnchanged
+		return change, nil, nil
+	}
+	if dryRun {
+		return change, nil, nil
+	}
+
+	updated, err := s.client.UpdateIdentity(ctx, existing.Id, ory.UpdateIdentityBody{
+		SchemaId:       existing.SchemaId,
+		Traits:         traits,
+		State:          state,
+		MetadataPublic: existing.MetadataPublic,
+		MetadataAdmin:  metadata,
+	})
+	return change, updated, err
+}
+
+// findUnlinked returns the only identity without a sync link having one of the mapped attribute
+// values of a directory user as credential identifier, or nil. The identity must have verified
+// that value as an address: anyone can register an unverified address, and linking such an
+// identity would hand the directory user's account to them.
+func (s *Syncer) findUnlinked(ctx context.Context, entry Entry) (*ory.Identity, error) {
+	attributes := make([]string, 0, len(s.mapping))
+	for attribute := range s.mapping {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+
+	var unverified *ory.Identity
+	var unverifiedValue string
+	for _, attribute := range attributes {
+		value, ok := entry.Value(attribute)
+		if !ok || value == "" {
+			continue
+		}
+		identities, err := s.client.FindIdentitiesByIdentifier(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find identity by %s: %w", attribute, err)
+		}
+		var unlinked []ory.Identity
+		for _, identity := range identities {
+			if metadataString(identity.MetadataAdmin, metadataID) == "" {
+				unlinked = append(unlinked, identity)
+			}
+		}
+		if len(unlinked) != 1 {
+			continue
+		}
+		if hasVerifiedAddress(&unlinked[0], value) {
+			return &unlinked[0], nil
+		}
+		if unverified == nil {
+			unverified, unverifiedValue = &unlinked[0], value
+		}
+	}
+
+	if unverified != nil {
+		return nil, fmt.Errorf("identity %s uses %s without having verified it, refusing to link it to the directory user", unverified.Id, unverifiedValue)
+	}
+	return nil, nil
+}
+
+// hasVerifiedAddress reports whether an identity verified an address, compared case-insensitively
+func hasVerifiedAddress(identity *ory.Identity, value string) bool {
+	for _, address := range identity.VerifiableAddresses {
+		if address.Verified && strings.EqualFold(address.Value, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// deactivate deactivates an identity whose user is no longer in the directory
+func (s *Syncer) deactivate(ctx context.Context, identity *ory.Identity, dryRun bool) (*Change, *ory.Identity, error) {
+	change := &Change{
+		Action:     ActionDeactivate,
+		DN:         metadataString(identity.MetadataAdmin, metadataDN),
+		IdentityID: identity.Id,
+	}
+
+	if identity.State != nil && *identity.State == ory.IDENTITYSTATE_INACTIVE {
+		change.Action = ActionUnchanged
+		return change, nil, nil
+	}
+	if dryRun {
+		return change, nil, nil
+	}
+
+	metadata, err := cloneObject(identity.MetadataAdmin)
+	if err != nil {
+		return change, nil, err
+	}
+	metadata[metadataDeactivated] = true
+	traits, _ := identity.Traits.(map[string]interface{})
+
+	updated, err := s.client.UpdateIdentity(ctx, identity.Id, ory.UpdateIdentityBody{
+		SchemaId:       identity.SchemaId,
+		Traits:         traits,
+		State:          ory.IDENTITYSTATE_INACTIVE,
+		MetadataPublic: identity.MetadataPublic,
+		MetadataAdmin:  metadata,
+	})
+	return change, updated, err
+}
+
+// applyAttributes replaces the mapped traits with the first value of their attribute.
+// Mapped traits whose attribute is missing are removed, other traits are kept.
+func (s *Syncer) applyAttributes(traits map[string]interface{}, entry Entry) error {
+	attributes := make([]string, 0, len(s.mapping))
+	for attribute := range s.mapping {
+		schema.DeletePointer(traits, s.mapping[attribute])
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+
+	for _, attribute := range attributes {
+		value, ok := entry.Value(attribute)
+		if !ok {
+			continue
+		}
+		if err := schema.SetPointer(traits, s.mapping[attribute], value); err != nil {
+			return fmt.Errorf("failed to map %s: %w", attribute, err)
+		}
+	}
+	return nil
+}
+
+// metadataString returns a string value of identity metadata
+func metadataString(metadata interface{}, key string) string {
+	object, _ := metadata.(map[string]interface{})
+	value, _ := object[key].(string)
+	return value
+}
+
+// cloneObject deep copies a JSON object through a JSON round trip. nil gives an empty object.
+func cloneObject(v interface{}) (map[string]interface{}, error) {
+	clone := map[string]interface{}{}
+	if v == nil {
+		return clone, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
@@ -0,0 +1,489 @@
+package ldapsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// fakeDirectory returns fixed users
+type fakeDirectory []Entry
+
+func (d fakeDirectory) Users(ctx context.Context, attributes []string) ([]Entry, error) {
+	return d, nil
+}
+
+// fakeKratos serves the identity endpoints of the Kratos admin API from memory. The email
+// trait is the credential identifier.
+type fakeKratos struct {
+	mu         sync.Mutex
+	identities map[string]*ory.Identity
+	writes     int
+}
+
+func newFakeKratos(t *testing.T, identities ...ory.Identity) (*fakeKratos, *kratos.Client) {
+	t.Helper()
+	f := &fakeKratos{identities: map[string]*ory.Identity{}}
+	for i := range identities {
+		f.identities[identities[i].Id] = &identities[i]
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, kratos.NewClient(server.URL)
+}
+
+func (f *fakeKratos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := strings.TrimPrefix(r.URL.Path, "/admin/identities/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/admin/identities":
+		f.list(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/admin/identities":
+		var body ory.CreateIdentityBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		if f.findByEmail(emailOf(body.Traits)) != nil {
+			writeError(w, http.StatusConflict)
+			return
+		}
+		identity := &ory.Identity{
+			Id:            fmt.Sprintf("identity-%d", len(f.identities)+1),
+			SchemaId:      body.SchemaId,
+			Traits:        body.Traits,
+			State:         body.State,
+			MetadataAdmin: body.MetadataAdmin,
+		}
+		f.identities[identity.Id] = identity
+		f.writes++
+		writeJSON(w, http.StatusCreated, identity)
+	case r.Method == http.MethodPut && id != r.URL.Path:
+		identity, ok := f.identities[id]
+		if !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		var body ory.UpdateIdentityBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		state := body.State
+		identity.SchemaId = body.SchemaId
+		identity.Traits = body.Traits
+		identity.State = &state
+		identity.MetadataPublic = body.MetadataPublic
+		identity.MetadataAdmin = body.MetadataAdmin
+		f.writes++
+		writeJSON(w, http.StatusOK, identity)
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+func (f *fakeKratos) list(w http.ResponseWriter, r *http.Request) {
+	if identifier := r.URL.Query().Get("credentials_identifier"); identifier != "" {
+		result := []*ory.Identity{}
+		if identity := f.findByEmail(identifier); identity != nil {
+			result = append(result, identity)
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	ids := make([]string, 0, len(f.identities))
+	for id := range f.identities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 250
+	}
+
+	result := []*ory.Identity{}
+	for i := (page - 1) * perPage; i < len(ids) && i < page*perPage; i++ {
+		result = append(result, f.identities[ids[i]])
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (f *fakeKratos) findByEmail(email string) *ory.Identity {
+	for _, identity := range f.identities {
+		if email != "" && emailOf(identity.Traits) == email {
+			return identity
+		}
+	}
+	return nil
+}
+
+// identity returns a copy of a stored identity
+func (f *fakeKratos) identity(t *testing.T, id string) ory.Identity {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	identity, ok := f.identities[id]
+	if !ok {
+		t.Fatalf("identity %s does not exist", id)
+	}
+	return *identity
+}
+
+func emailOf(traits interface{}) string {
+	object, _ := traits.(map[string]interface{})
+	email, _ := object["email"].(string)
+	return email
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "message": http.StatusText(status)},
+	})
+}
+
+func user(uuid, mail, givenName string) Entry {
+	attributes := map[string][]string{"mail": {mail}, "givenname": {givenName}, "sn": {"Doe"}}
+	if uuid != "" {
+		attributes["entryuuid"] = []string{uuid}
+	}
+	return Entry{DN: "uid=" + givenName + ",ou=people,dc=example,dc=org", Attributes: attributes}
+}
+
+func synced(id, uuid, email, state string, metadata map[string]interface{}) ory.Identity {
+	identityState := ory.IdentityState(state)
+	admin := map[string]interface{}{metadataID: uuid, metadataDN: "uid=old,ou=people,dc=example,dc=org"}
+	for key, value := range metadata {
+		admin[key] = value
+	}
+	return ory.Identity{
+		Id:            id,
+		SchemaId:      "default",
+		Traits:        map[string]interface{}{"email": email, "name": map[string]interface{}{"first": "Old", "last": "Doe"}},
+		State:         &identityState,
+		MetadataAdmin: admin,
+	}
+}
+
+func runSync(t *testing.T, client *kratos.Client, directory fakeDirectory, dryRun bool) (*Report, error) {
+	t.Helper()
+	return runSyncWithEvents(t, client, nil, directory, dryRun)
+}
+
+func runSyncWithEvents(t *testing.T, client *kratos.Client, events *webhooks.Publisher, directory fakeDirectory, dryRun bool) (*Report, error) {
+	t.Helper()
+	syncer, err := NewSyncer(directory, client, events, "default", "entryUUID", DefaultMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return syncer.Run(context.Background(), dryRun, nil)
+}
+
+func TestRunCreatesIdentities(t *testing.T) {
+	fake, client := newFakeKratos(t)
+
+	report, err := runSync(t, client, fakeDirectory{user("u1", "jane@example.org", "Jane"), user("u2", "john@example.org", "John")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 2 || report.Failed != 0 || len(report.Changes) != 2 {
+		t.Fatalf("report = %+v, want 2 created", report)
+	}
+
+	identity := fake.identity(t, report.Changes[0].IdentityID)
+	if got := metadataString(identity.MetadataAdmin, metadataID); got != "u1" {
+		t.Errorf("ldap_id = %q, want u1", got)
+	}
+	if got := metadataString(identity.MetadataAdmin, metadataDN); got != "uid=Jane,ou=people,dc=example,dc=org" {
+		t.Errorf("ldap_dn = %q", got)
+	}
+	traits := identity.Traits.(map[string]interface{})
+	name := traits["name"].(map[string]interface{})
+	if traits["email"] != "jane@example.org" || name["first"] != "Jane" || name["last"] != "Doe" {
+		t.Errorf("traits = %v", traits)
+	}
+	if identity.State == nil || *identity.State != ory.IDENTITYSTATE_ACTIVE {
+		t.Errorf("state = %v, want active", identity.State)
+	}
+}
+
+func TestRunUpdatesIdentities(t *testing.T) {
+	fake, client := newFakeKratos(t,
+		synced("a", "u1", "jane@example.org", "active", nil),
+		ory.Identity{Id: "b", SchemaId: "default", Traits: map[string]interface{}{"email": "manual@example.org"}},
+	)
+	unchanged := user("u1", "jane@example.org", "Old")
+	unchanged.DN = "uid=old,ou=people,dc=example,dc=org"
+
+	report, err := runSync(t, client, fakeDirectory{unchanged}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unchanged != 1 || fake.writes != 0 {
+		t.Fatalf("report = %+v, writes = %d, want 1 unchanged and no writes", report, fake.writes)
+	}
+
+	report, err = runSync(t, client, fakeDirectory{user("u1", "jane.doe@example.org", "Jane")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Managed != 1 {
+		t.Fatalf("report = %+v, want 1 updated of 1 managed", report)
+	}
+	if len(report.Changes[0].Traits) != 2 {
+		t.Errorf("trait changes = %+v, want email and first name", report.Changes[0].Traits)
+	}
+
+	identity := fake.identity(t, "a")
+	if got := emailOf(identity.Traits); got != "jane.doe@example.org" {
+		t.Errorf("email = %q", got)
+	}
+	if got := metadataString(identity.MetadataAdmin, metadataDN); got != "uid=Jane,ou=people,dc=example,dc=org" {
+		t.Errorf("ldap_dn = %q, want the new DN", got)
+	}
+	if got := emailOf(fake.identity(t, "b").Traits); got != "manual@example.org" {
+		t.Errorf("identity without link changed: email = %q", got)
+	}
+}
+
+func TestRunDeactivatesAndReactivates(t *testing.T) {
+	fake, client := newFakeKratos(t,
+		synced("a", "u1", "jane@example.org", "active", nil),
+		synced("b", "u2", "john@example.org", "active", nil),
+	)
+
+	report, err := runSync(t, client, fakeDirectory{user("u1", "jane@example.org", "Jane")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deactivated != 1 {
+		t.Fatalf("report = %+v, want 1 deactivated", report)
+	}
+	identity := fake.identity(t, "b")
+	if *identity.State != ory.IDENTITYSTATE_INACTIVE {
+		t.Errorf("state = %s, want inactive", *identity.State)
+	}
+	if identity.MetadataAdmin.(map[string]interface{})[metadataDeactivated] != true {
+		t.Errorf("metadata = %v, want ldap_deactivated", identity.MetadataAdmin)
+	}
+
+	report, err = runSync(t, client, fakeDirectory{user("u1", "jane@example.org", "Jane"), user("u2", "john@example.org", "John")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Reactivated != 1 || report.Deactivated != 0 {
+		t.Fatalf("report = %+v, want 1 reactivated", report)
+	}
+	identity = fake.identity(t, "b")
+	if *identity.State != ory.IDENTITYSTATE_ACTIVE {
+		t.Errorf("state = %s, want active", *identity.State)
+	}
+	if _, ok := identity.MetadataAdmin.(map[string]interface{})[metadataDeactivated]; ok {
+		t.Errorf("metadata = %v, want ldap_deactivated removed", identity.MetadataAdmin)
+	}
+}
+
+func TestRunKeepsIdentitiesDeactivatedByAdmins(t *testing.T) {
+	fake, client := newFakeKratos(t, synced("a", "u1", "jane@example.org", "inactive", nil))
+
+	report, err := runSync(t, client, fakeDirectory{user("u1", "jane@example.org", "Jane")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Reactivated != 0 || report.Updated != 1 {
+		t.Fatalf("report = %+v, want 1 updated", report)
+	}
+	if state := *fake.identity(t, "a").State; state != ory.IDENTITYSTATE_INACTIVE {
+		t.Errorf("state = %s, want inactive", state)
+	}
+}
+
+func TestRunReportsDuplicateAndMissingIDs(t *testing.T) {
+	fake, client := newFakeKratos(t)
+
+	report, err := runSync(t, client, fakeDirectory{
+		user("u1", "jane@example.org", "Jane"),
+		user("u1", "jane2@example.org", "Janet"),
+		user("", "nobody@example.org", "Nobody"),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Failed != 2 {
+		t.Fatalf("report = %+v, want 1 created and 2 failed", report)
+	}
+	if !strings.Contains(report.Failures[0].Error, `duplicate entryUUID "u1"`) {
+		t.Errorf("failure = %q, want duplicate", report.Failures[0].Error)
+	}
+	if !strings.Contains(report.Failures[1].Error, "missing entryUUID") {
+		t.Errorf("failure = %q, want missing attribute", report.Failures[1].Error)
+	}
+	if len(fake.identities) != 1 {
+		t.Errorf("identities = %d, want 1", len(fake.identities))
+	}
+}
+
+func TestRunRefusesEmptyDirectory(t *testing.T) {
+	fake, client := newFakeKratos(t, synced("a", "u1", "jane@example.org", "active", nil))
+
+	report, err := runSync(t, client, fakeDirectory{}, false)
+	if err == nil {
+		t.Fatal("expected an error for an empty directory")
+	}
+	if report.Managed != 1 || report.Deactivated != 0 || fake.writes != 0 {
+		t.Errorf("report = %+v, writes = %d, want nothing deactivated", report, fake.writes)
+	}
+
+	// Without synchronized identities, an empty directory is not suspicious
+	_, client = newFakeKratos(t)
+	if _, err := runSync(t, client, fakeDirectory{}, false); err != nil {
+		t.Errorf("empty directory without synchronized identities: %v", err)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	fake, client := newFakeKratos(t,
+		synced("a", "u1", "jane@example.org", "active", nil),
+		synced("b", "u2", "john@example.org", "active", nil),
+		synced("c", "u3", "jack@example.org", "inactive", map[string]interface{}{metadataDeactivated: true}),
+	)
+
+	report, err := runSync(t, client, fakeDirectory{
+		user("u1", "jane.doe@example.org", "Jane"),
+		user("u3", "jack@example.org", "Jack"),
+		user("u4", "jim@example.org", "Jim"),
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Created != 1 || report.Updated != 1 || report.Deactivated != 1 || report.Reactivated != 1 {
+		t.Fatalf("report = %+v, want 1 of each change", report)
+	}
+	if fake.writes != 0 || len(fake.identities) != 3 {
+		t.Errorf("dry run wrote %d times", fake.writes)
+	}
+	if got := emailOf(fake.identity(t, "a").Traits); got != "jane@example.org" {
+		t.Errorf("dry run changed email to %q", got)
+	}
+}
+
+func TestRunRelinksIdentitiesThatLostTheirMetadata(t *testing.T) {
+	fake, client := newFakeKratos(t, ory.Identity{
+		Id:                  "a",
+		SchemaId:            "default",
+		Traits:              map[string]interface{}{"email": "jane@example.org"},
+		VerifiableAddresses: []ory.VerifiableIdentityAddress{{Value: "Jane@example.org", Verified: true, Via: "email"}},
+	})
+
+	report, err := runSync(t, client, fakeDirectory{user("u1", "jane@example.org", "Jane")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Failed != 0 || report.Changes[0].IdentityID != "a" {
+		t.Fatalf("report = %+v, want identity a updated", report)
+	}
+	if got := metadataString(fake.identity(t, "a").MetadataAdmin, metadataID); got != "u1" {
+		t.Errorf("ldap_id = %q, want u1", got)
+	}
+	if len(fake.identities) != 1 {
+		t.Errorf("identities = %d, want 1", len(fake.identities))
+	}
+}
+
+func TestRunRefusesToLinkUnverifiedIdentities(t *testing.T) {
+	fake, client := newFakeKratos(t, ory.Identity{
+		Id:                  "a",
+		SchemaId:            "default",
+		Traits:              map[string]interface{}{"email": "jane@example.org", "name": map[string]interface{}{"first": "Mallory"}},
+		VerifiableAddresses: []ory.VerifiableIdentityAddress{{Value: "jane@example.org", Verified: false, Via: "email"}},
+	})
+
+	report, err := runSync(t, client, fakeDirectory{user("u1", "jane@example.org", "Jane")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 || report.Updated != 0 || report.Created != 0 {
+		t.Fatalf("report = %+v, want 1 failure", report)
+	}
+	if !strings.Contains(report.Failures[0].Error, "without having verified it") {
+		t.Errorf("failure = %q, want the unverified address reported", report.Failures[0].Error)
+	}
+	identity := fake.identity(t, "a")
+	if metadataString(identity.MetadataAdmin, metadataID) != "" || fake.writes != 0 {
+		t.Errorf("identity a = %+v after %d writes, want it left alone", identity, fake.writes)
+	}
+}
+
+func TestRunPublishesEvents(t *testing.T) {
+	_, client := newFakeKratos(t,
+		synced("a", "u1", "jane@example.org", "active", nil),
+		synced("b", "u2", "john@example.org", "active", nil),
+	)
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher, err := webhooks.NewDispatcher(s, 1, time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dispatcher.CreateSubscription(webhooks.Subscription{
+		URL:    "http://127.0.0.1:1/hook",
+		Events: []string{webhooks.EventIdentityCreated, webhooks.EventIdentityUpdated},
+		Active: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	directory := fakeDirectory{user("u1", "jane.doe@example.org", "Jane"), user("u3", "jim@example.org", "Jim")}
+
+	if _, err := runSyncWithEvents(t, client, dispatcher.Publisher("default"), directory, true); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries := dispatcher.Deliveries(webhooks.DeliveryFilter{}, 10); len(deliveries) != 0 {
+		t.Fatalf("dry run queued %d deliveries", len(deliveries))
+	}
+
+	if _, err := runSyncWithEvents(t, client, dispatcher.Publisher("default"), directory, false); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, delivery := range dispatcher.Deliveries(webhooks.DeliveryFilter{}, 10) {
+		counts[delivery.EventType]++
+		var event webhooks.Event
+		if err := json.Unmarshal(delivery.Payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Actor != webhooks.ActorLDAPSync || event.Target != "default" {
+			t.Errorf("event actor = %q, target = %q", event.Actor, event.Target)
+		}
+	}
+	// u1 is updated, u2 deactivated and u3 created
+	if counts[webhooks.EventIdentityCreated] != 1 || counts[webhooks.EventIdentityUpdated] != 2 {
+		t.Errorf("events = %v, want 1 created and 2 updated", counts)
+	}
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/stats"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kratos_admin"
+
+// Metrics holds the Prometheus collectors exposed on /metrics
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	kratosRequests *prometheus.CounterVec
+	kratosDuration *prometheus.HistogramVec
+
+	identities     *prometheus.GaugeVec
+	activeSessions *prometheus.GaugeVec
+	mfaAdoption    *prometheus.GaugeVec
+	lastRefresh    *prometheus.GaugeVec
+	refreshErrors  *prometheus.CounterVec
+}
+
+// New creates and registers all collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled by the backend.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests handled by the backend.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+
+		kratosRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kratos_requests_total",
+			Help:      "Requests sent to Kratos, by target, client operation and outcome.",
+		}, []string{"target", "operation", "outcome"}),
+		kratosDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kratos_request_duration_seconds",
+			Help:      "Latency of requests sent to Kratos, by target and client operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"target", "operation"}),
+
+		identities: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "identities",
+			Help:      "Number of identities by target, schema and state.",
+		}, []string{"target", "schema", "state"}),
+		activeSessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_sessions",
+			Help:      "Number of active sessions by target.",
+		}, []string{"target"}),
+		mfaAdoption: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "identities_by_security_category",
+			Help:      "Number of identities per target and MFA and verification category, e.g. totp, mfa, password_only.",
+		}, []string{"target", "category"}),
+		lastRefresh: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gauges_last_refresh_timestamp_seconds",
+			Help:      "Unix time of the last successful identity and session gauge refresh, by target.",
+		}, []string{"target"}),
+		refreshErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gauges_refresh_errors_total",
+			Help:      "Failed identity and session gauge refreshes, by target.",
+		}, []string{"target"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.kratosRequests, m.kratosDuration,
+		m.identities, m.activeSessions, m.mfaAdoption,
+		m.lastRefresh, m.refreshErrors,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
+
+// Middleware records the count and latency of backend HTTP requests
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Use the route template to keep label cardinality bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		m.httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Target returns the observer of the requests sent to a Kratos target
+func (m *Metrics) Target(name string) kratos.Observer {
+	return &targetObserver{metrics: m, target: name}
+}
+
+// targetObserver records the Kratos requests of one target
+type targetObserver struct {
+	metrics *Metrics
+	target  string
+}
+
+// ObserveKratosRequest implements kratos.Observer
+func (o *targetObserver) ObserveKratosRequest(operation string, duration time.Duration, statusCode int, err error) {
+	outcome := "success"
+	switch {
+	case err != nil || statusCode == 0:
+		outcome = "network_error"
+	case statusCode >= http.StatusInternalServerError:
+		outcome = "server_error"
+	case statusCode >= http.StatusBadRequest:
+		outcome = "client_error"
+	}
+
+	o.metrics.kratosRequests.WithLabelValues(o.target, operation, outcome).Inc()
+	o.metrics.kratosDuration.WithLabelValues(o.target, operation).Observe(duration.Seconds())
+}
+
+// Refresher periodically recomputes the identity, session and MFA gauges of a Kratos target
+// It reads from the stats caches so that scraping does not add full scans of Kratos.
+type Refresher struct {
+	metrics   *Metrics
+	target    string
+	usage     *stats.UsageCache
+	dashboard *stats.DashboardCache
+	security  *stats.SecurityCache
+	interval  time.Duration
+}
+
+// NewRefresher creates a new gauge refresher for target
+func NewRefresher(m *Metrics, target string, usage *stats.UsageCache, dashboard *stats.DashboardCache, security *stats.SecurityCache, interval time.Duration) *Refresher {
+	return &Refresher{metrics: m, target: target, usage: usage, dashboard: dashboard, security: security, interval: interval}
+}
+
+// Run refreshes the gauges immediately and then on every interval until ctx is cancelled
+func (r *Refresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Refresh(ctx); err != nil {
+			r.metrics.refreshErrors.WithLabelValues(r.target).Inc()
+			log.Printf("Failed to refresh metrics gauges of Kratos target %q: %v", r.target, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh recomputes all gauges once
+func (r *Refresher) Refresh(ctx context.Context) error {
+	usage, err := r.usage.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := r.dashboard.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	report, err := r.security.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Reset so schemas and states that disappeared are not reported with stale values
+	r.metrics.identities.DeletePartialMatch(prometheus.Labels{"target": r.target})
+	for schemaID, u := range usage {
+		for state, count := range u.ByState {
+			r.metrics.identities.WithLabelValues(r.target, schemaID, state).Set(float64(count))
+		}
+	}
+	r.metrics.activeSessions.WithLabelValues(r.target).Set(float64(snapshot.ActiveSessions))
+	for category, count := range report.Counts {
+		r.metrics.mfaAdoption.WithLabelValues(r.target, category).Set(float64(count))
+	}
+	r.metrics.lastRefresh.WithLabelValues(r.target).SetToCurrentTime()
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+)
+
+// Rule operations
+const (
+	OpRename   = "rename"
+	OpMove     = "move"
+	OpDefault  = "default"
+	OpDrop     = "drop"
+	OpTemplate = "template"
+)
+
+// Rule is a single declarative trait transformation.
+// Paths are JSON pointers relative to the traits object, e.g. "/name/first".
+//
+//   - rename:   renames the key at From to the key To, keeping it in the same object
+//   - move:     moves the value at From to the path To
+//   - default:  sets Path to Value if it is not present
+//   - drop:     removes Path
+//   - template: sets Path to Template rendered with the traits as data, e.g. "{{.name.first}} {{.name.last}}"
+type Rule struct {
+	Op       string      `json:"op" binding:"required,oneof=rename move default drop template"`
+	From     string      `json:"from,omitempty"`
+	To       string      `json:"to,omitempty"`
+	Path     string      `json:"path,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	Template string      `json:"template,omitempty"`
+}
+
+// Mapping is a compiled list of rules
+type Mapping struct {
+	rules     []Rule
+	templates map[int]*template.Template
+}
+
+// NewMapping validates the rules and compiles their templates
+func NewMapping(rules []Rule) (*Mapping, error) {
+	m := &Mapping{rules: rules, templates: map[int]*template.Template{}}
+
+	for i, rule := range rules {
+		switch rule.Op {
+		case OpRename, OpMove:
+			if rule.From == "" || rule.To == "" {
+				return nil, fmt.Errorf("rule %d: %s requires from and to", i, rule.Op)
+			}
+		case OpDefault, OpDrop:
+			if rule.Path == "" {
+				return nil, fmt.Errorf("rule %d: %s requires path", i, rule.Op)
+			}
+		case OpTemplate:
+			if rule.Path == "" || rule.Template == "" {
+				return nil, fmt.Errorf("rule %d: template requires path and template", i)
+			}
+			tmpl, err := template.New(fmt.Sprint(i)).Option("missingkey=error").Parse(rule.Template)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid template: %w", i, err)
+			}
+			m.templates[i] = tmpl
+		default:
+			return nil, fmt.Errorf("rule %d: unknown op %q", i, rule.Op)
+		}
+	}
+
+	return m, nil
+}
+
+// Apply returns a transformed copy of the traits. The input is not modified.
+func (m *Mapping) Apply(traits map[string]interface{}) (map[string]interface{}, error) {
+	result, err := deepCopy(traits)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range m.rules {
+		switch rule.Op {
+		case OpRename:
+			value, ok := schema.GetPointer(result, rule.From)
+			if !ok {
+				continue
+			}
+			tokens := schema.SplitPointer(rule.From)
+			parent := ""
+			for _, token := range tokens[:len(tokens)-1] {
+				parent = schema.JoinPointer(parent, token)
+			}
+			schema.DeletePointer(result, rule.From)
+			if err := schema.SetPointer(result, schema.JoinPointer(parent, rule.To), value); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+
+		case OpMove:
+			value, ok := schema.GetPointer(result, rule.From)
+			if !ok {
+				continue
+			}
+			schema.DeletePointer(result, rule.From)
+			if err := schema.SetPointer(result, rule.To, value); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+
+		case OpDefault:
+			if _, ok := schema.GetPointer(result, rule.Path); ok {
+				continue
+			}
+			if err := schema.SetPointer(result, rule.Path, rule.Value); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+
+		case OpDrop:
+			schema.DeletePointer(result, rule.Path)
+
+		case OpTemplate:
+			var buf bytes.Buffer
+			if err := m.templates[i].Execute(&buf, result); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			if err := schema.SetPointer(result, rule.Path, buf.String()); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// deepCopy copies a JSON-compatible value through a JSON round trip
+func deepCopy(traits map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	if traits == nil {
+		return result, nil
+	}
+
+	encoded, err := json.Marshal(traits)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,225 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// JobKind identifies schema migration jobs in the job manager
+const JobKind = "schema_migration"
+
+const (
+	defaultBatchSize = 25
+	maxFailures      = 1000
+	maxSamples       = 20
+)
+
+// Plan describes a migration of identities from one schema to another
+type Plan struct {
+	SourceSchema string `json:"source_schema" binding:"required"`
+	TargetSchema string `json:"target_schema" binding:"required"`
+	Rules        []Rule `json:"rules" binding:"dive"`
+	BatchSize    int    `json:"batch_size,omitempty"`
+	DryRun       bool   `json:"dry_run"`
+}
+
+// Failure describes an identity that could not be migrated
+type Failure struct {
+	IdentityID string                   `json:"identity_id"`
+	Errors     []schema.ValidationError `json:"errors,omitempty"`
+	Error      string                   `json:"error,omitempty"`
+}
+
+// Change is a preview of the traits of a single identity before and after the mapping
+type Change struct {
+	IdentityID string                 `json:"identity_id"`
+	Before     map[string]interface{} `json:"before"`
+	After      map[string]interface{} `json:"after"`
+}
+
+// Report summarizes a migration run
+type Report struct {
+	DryRun       bool      `json:"dry_run"`
+	SourceSchema string    `json:"source_schema"`
+	TargetSchema string    `json:"target_schema"`
+	Matched      int64     `json:"matched"`
+	Valid        int64     `json:"valid"`
+	Invalid      int64     `json:"invalid"`
+	Updated      int64     `json:"updated"`
+	Failed       int64     `json:"failed"`
+	Failures     []Failure `json:"failures"`
+	Samples      []Change  `json:"samples"`
+}
+
+// addFailure records a failure, keeping at most maxFailures entries
+func (r *Report) addFailure(f Failure) {
+	if len(r.Failures) < maxFailures {
+		r.Failures = append(r.Failures, f)
+	}
+}
+
+// candidate is an identity whose traits passed validation against the target schema
+type candidate struct {
+	identity ory.Identity
+	traits   map[string]interface{}
+}
+
+// Migrator moves identities between schemas through the Kratos admin API
+type Migrator struct {
+	client *kratos.Client
+	events *webhooks.Publisher
+}
+
+// NewMigrator creates a new migrator publishing an identity.updated event per migrated identity
+func NewMigrator(client *kratos.Client, events *webhooks.Publisher) *Migrator {
+	return &Migrator{client: client, events: events}
+}
+
+// Run dry-runs the plan against every identity using the source schema and, unless
+// plan.DryRun is set, updates the identities that validate against the target schema.
+// actor is the admin who started the migration. The report is returned even when the run
+// fails part way through.
+func (m *Migrator) Run(ctx context.Context, plan Plan, actor string, progress *jobs.Progress) (*Report, error) {
+	report := &Report{
+		DryRun:       plan.DryRun,
+		SourceSchema: plan.SourceSchema,
+		TargetSchema: plan.TargetSchema,
+		Failures:     []Failure{},
+		Samples:      []Change{},
+	}
+
+	mapping, err := NewMapping(plan.Rules)
+	if err != nil {
+		return report, err
+	}
+
+	doc, err := m.client.GetIdentitySchema(ctx, plan.TargetSchema)
+	if err != nil {
+		return report, fmt.Errorf("failed to fetch target schema: %w", err)
+	}
+	validator := schema.NewValidator(doc)
+
+	var identities []ory.Identity
+	err = m.client.ForEachIdentityPage(ctx, func(page []ory.Identity) error {
+		for _, identity := range page {
+			if identity.SchemaId == plan.SourceSchema {
+				identities = append(identities, identity)
+			}
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list identities: %w", err)
+	}
+
+	report.Matched = int64(len(identities))
+	total := report.Matched
+	if !plan.DryRun {
+		// Every identity is validated, then the valid ones are updated
+		total *= 2
+	}
+	progress.SetTotal(total)
+
+	var candidates []candidate
+	for _, identity := range identities {
+		progress.Add(1)
+
+		before, _ := identity.Traits.(map[string]interface{})
+		after, err := mapping.Apply(before)
+		if err != nil {
+			report.Invalid++
+			report.addFailure(Failure{IdentityID: identity.Id, Error: err.Error()})
+			continue
+		}
+
+		if len(report.Samples) < maxSamples {
+			report.Samples = append(report.Samples, Change{IdentityID: identity.Id, Before: before, After: after})
+		}
+
+		if errs := validator.ValidateTraits(after); len(errs) > 0 {
+			report.Invalid++
+			report.addFailure(Failure{IdentityID: identity.Id, Errors: errs})
+			continue
+		}
+
+		report.Valid++
+		candidates = append(candidates, candidate{identity: identity, traits: after})
+	}
+
+	if plan.DryRun {
+		return report, nil
+	}
+
+	// Identities that failed validation are not updated, but still count as processed
+	progress.Add(report.Invalid)
+
+	return report, m.apply(ctx, plan, actor, candidates, report, progress)
+}
+
+// apply updates the candidates in batches of concurrent requests
+func (m *Migrator) apply(ctx context.Context, plan Plan, actor string, candidates []candidate, report *Report, progress *jobs.Progress) error {
+	batchSize := plan.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	var mu sync.Mutex
+	for start := 0; start < len(candidates); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := start + batchSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+
+		var wg sync.WaitGroup
+		var updated []interface{}
+		for _, cand := range candidates[start:end] {
+			wg.Add(1)
+			go func(cand candidate) {
+				defer wg.Done()
+				identity, err := m.update(ctx, plan.TargetSchema, cand)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					report.Failed++
+					report.addFailure(Failure{IdentityID: cand.identity.Id, Error: err.Error()})
+				} else {
+					report.Updated++
+					updated = append(updated, identity)
+				}
+				progress.Add(1)
+			}(cand)
+		}
+		wg.Wait()
+		m.events.PublishBatch(webhooks.EventIdentityUpdated, actor, updated)
+	}
+
+	return nil
+}
+
+// update writes the migrated traits, preserving state and metadata
+func (m *Migrator) update(ctx context.Context, targetSchema string, cand candidate) (*ory.Identity, error) {
+	state := ory.IDENTITYSTATE_ACTIVE
+	if cand.identity.State != nil {
+		state = *cand.identity.State
+	}
+
+	return m.client.UpdateIdentity(ctx, cand.identity.Id, ory.UpdateIdentityBody{
+		SchemaId:       targetSchema,
+		Traits:         cand.traits,
+		State:          state,
+		MetadataPublic: cand.identity.MetadataPublic,
+		MetadataAdmin:  cand.identity.MetadataAdmin,
+	})
+}
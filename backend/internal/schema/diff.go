@@ -0,0 +1,140 @@
+package schema
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff lists the trait changes between two identity schemas
+type Diff struct {
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Added    []PropertyChange `json:"added"`
+	Removed  []PropertyChange `json:"removed"`
+	Retyped  []PropertyChange `json:"retyped"`
+	Changed  []PropertyChange `json:"changed"`
+	Required []PropertyChange `json:"required"`
+}
+
+// PropertyChange describes a single changed trait
+type PropertyChange struct {
+	Pointer  string   `json:"pointer"`
+	FromType string   `json:"from_type,omitempty"`
+	ToType   string   `json:"to_type,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	Required *bool    `json:"required,omitempty"`
+}
+
+// flatProperty is a trait node with the required flag taken from its parent
+type flatProperty struct {
+	node     map[string]interface{}
+	required bool
+}
+
+// diffedKeywords are the validation keywords compared for the changed list
+var diffedKeywords = []string{
+	"format", "enum", "const", "pattern", "minLength", "maxLength", "minimum", "maximum",
+	"exclusiveMinimum", "exclusiveMaximum", "minItems", "maxItems", "additionalProperties", ExtensionKey,
+}
+
+// DiffSchemas compares the traits of two identity schemas
+func DiffSchemas(fromID string, from map[string]interface{}, toID string, to map[string]interface{}) *Diff {
+	diff := &Diff{
+		From:     fromID,
+		To:       toID,
+		Added:    []PropertyChange{},
+		Removed:  []PropertyChange{},
+		Retyped:  []PropertyChange{},
+		Changed:  []PropertyChange{},
+		Required: []PropertyChange{},
+	}
+
+	before := flatten(Schema(from))
+	after := flatten(Schema(to))
+
+	pointers := make([]string, 0, len(before)+len(after))
+	for pointer := range before {
+		pointers = append(pointers, pointer)
+	}
+	for pointer := range after {
+		if _, ok := before[pointer]; !ok {
+			pointers = append(pointers, pointer)
+		}
+	}
+	sort.Strings(pointers)
+
+	for _, pointer := range pointers {
+		old, inOld := before[pointer]
+		cur, inNew := after[pointer]
+
+		switch {
+		case !inOld:
+			diff.Added = append(diff.Added, PropertyChange{Pointer: pointer, ToType: typeName(cur.node), Required: boolPtr(cur.required)})
+		case !inNew:
+			diff.Removed = append(diff.Removed, PropertyChange{Pointer: pointer, FromType: typeName(old.node)})
+		default:
+			if fromType, toType := typeName(old.node), typeName(cur.node); fromType != toType {
+				diff.Retyped = append(diff.Retyped, PropertyChange{Pointer: pointer, FromType: fromType, ToType: toType})
+			}
+
+			var keywords []string
+			for _, keyword := range diffedKeywords {
+				if !reflect.DeepEqual(old.node[keyword], cur.node[keyword]) {
+					keywords = append(keywords, keyword)
+				}
+			}
+			if len(keywords) > 0 {
+				diff.Changed = append(diff.Changed, PropertyChange{Pointer: pointer, Keywords: keywords})
+			}
+
+			if old.required != cur.required {
+				diff.Required = append(diff.Required, PropertyChange{Pointer: pointer, Required: boolPtr(cur.required)})
+			}
+		}
+	}
+
+	return diff
+}
+
+// flatten maps every trait pointer of a schema to its node
+func flatten(s Schema) map[string]flatProperty {
+	result := map[string]flatProperty{}
+	traits := s.Traits()
+	if traits == nil {
+		return result
+	}
+
+	var visit func(node map[string]interface{}, pointer string)
+	visit = func(node map[string]interface{}, pointer string) {
+		required := Required(node)
+		for name, child := range s.Properties(node) {
+			childPointer := JoinPointer(pointer, name)
+			result[childPointer] = flatProperty{node: child, required: required[name]}
+			visit(child, childPointer)
+		}
+		if items, ok := node["items"].(map[string]interface{}); ok {
+			itemPointer := JoinPointer(pointer, "-")
+			item := s.Resolve(items)
+			result[itemPointer] = flatProperty{node: item}
+			visit(item, itemPointer)
+		}
+	}
+	visit(traits, "/traits")
+
+	return result
+}
+
+// typeName renders the declared type(s) of a node
+func typeName(node map[string]interface{}) string {
+	types := Types(node)
+	if len(types) == 0 {
+		return primaryType(node)
+	}
+	sort.Strings(types)
+	return strings.Join(types, "|")
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
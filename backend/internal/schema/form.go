@@ -0,0 +1,172 @@
+package schema
+
+import "sort"
+
+// Form is a normalized description of the editor for an identity schema's traits
+type Form struct {
+	SchemaID              string   `json:"schema_id"`
+	Title                 string   `json:"title,omitempty"`
+	Fields                []Field  `json:"fields"`
+	Identifiers           []string `json:"identifiers"`
+	VerificationAddresses []string `json:"verification_addresses"`
+	RecoveryAddresses     []string `json:"recovery_addresses"`
+}
+
+// Field describes a single trait in a form
+type Field struct {
+	Name        string        `json:"name"`
+	Path        string        `json:"path"`
+	Label       string        `json:"label"`
+	Description string        `json:"description,omitempty"`
+	Type        string        `json:"type"`
+	Format      string        `json:"format,omitempty"`
+	Required    bool          `json:"required"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Default     interface{}   `json:"default,omitempty"`
+	Constraints *Constraints  `json:"constraints,omitempty"`
+	Fields      []Field       `json:"fields,omitempty"`
+	Items       *Field        `json:"items,omitempty"`
+	Kratos      *Extension    `json:"kratos,omitempty"`
+}
+
+// Constraints holds the validation keywords relevant to form inputs
+type Constraints struct {
+	MinLength *float64 `json:"min_length,omitempty"`
+	MaxLength *float64 `json:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Minimum   *float64 `json:"minimum,omitempty"`
+	Maximum   *float64 `json:"maximum,omitempty"`
+	MinItems  *float64 `json:"min_items,omitempty"`
+	MaxItems  *float64 `json:"max_items,omitempty"`
+}
+
+// BuildForm converts an identity schema document into a form description
+func BuildForm(schemaID string, doc map[string]interface{}) *Form {
+	s := Schema(doc)
+	form := &Form{
+		SchemaID:              schemaID,
+		Fields:                []Field{},
+		Identifiers:           []string{},
+		VerificationAddresses: []string{},
+		RecoveryAddresses:     []string{},
+	}
+	form.Title, _ = s.Resolve(s)["title"].(string)
+
+	traits := s.Traits()
+	if traits == nil {
+		return form
+	}
+
+	form.Fields = s.buildFields(traits, "/traits", form)
+	return form
+}
+
+// buildFields builds the fields of an object node in a stable order
+func (s Schema) buildFields(node map[string]interface{}, pointer string, form *Form) []Field {
+	props := s.Properties(node)
+	required := Required(node)
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]Field, 0, len(names))
+	for _, name := range names {
+		field := s.buildField(name, props[name], JoinPointer(pointer, name), form)
+		field.Required = required[name]
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// buildField builds a single field, recursing into nested objects and array items
+func (s Schema) buildField(name string, node map[string]interface{}, pointer string, form *Form) Field {
+	field := Field{
+		Name: name,
+		Path: pointer,
+		Type: primaryType(node),
+	}
+
+	field.Label, _ = node["title"].(string)
+	if field.Label == "" {
+		field.Label = name
+	}
+	field.Description, _ = node["description"].(string)
+	field.Format, _ = node["format"].(string)
+	field.Default = node["default"]
+	if enum, ok := node["enum"].([]interface{}); ok {
+		field.Enum = enum
+	}
+	field.Constraints = buildConstraints(node)
+
+	if ext := ParseExtension(node); !ext.IsEmpty() {
+		field.Kratos = &ext
+		if len(ext.Identifiers) > 0 {
+			form.Identifiers = append(form.Identifiers, pointer)
+		}
+		if ext.Verification != "" {
+			form.VerificationAddresses = append(form.VerificationAddresses, pointer)
+		}
+		if ext.Recovery != "" {
+			form.RecoveryAddresses = append(form.RecoveryAddresses, pointer)
+		}
+	}
+
+	switch field.Type {
+	case "object":
+		field.Fields = s.buildFields(node, pointer, form)
+	case "array":
+		if items, ok := node["items"].(map[string]interface{}); ok {
+			item := s.buildField(name, s.Resolve(items), JoinPointer(pointer, "-"), form)
+			field.Items = &item
+		}
+	}
+
+	return field
+}
+
+// primaryType picks the type a form input should use, ignoring "null"
+func primaryType(node map[string]interface{}) string {
+	for _, t := range Types(node) {
+		if t != "null" {
+			return t
+		}
+	}
+	if _, ok := node["properties"]; ok {
+		return "object"
+	}
+	if _, ok := node["items"]; ok {
+		return "array"
+	}
+	return "string"
+}
+
+func buildConstraints(node map[string]interface{}) *Constraints {
+	c := &Constraints{}
+	empty := true
+	set := func(key string) *float64 {
+		if n, ok := number(node[key]); ok {
+			empty = false
+			return &n
+		}
+		return nil
+	}
+
+	c.MinLength = set("minLength")
+	c.MaxLength = set("maxLength")
+	c.Minimum = set("minimum")
+	c.Maximum = set("maximum")
+	c.MinItems = set("minItems")
+	c.MaxItems = set("maxItems")
+	if pattern, ok := node["pattern"].(string); ok {
+		c.Pattern = pattern
+		empty = false
+	}
+
+	if empty {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Lint severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Finding is a single problem reported by the linter
+type Finding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Pointer  string `json:"pointer"`
+	Message  string `json:"message"`
+}
+
+// Lint checks an identity schema for common mistakes
+func Lint(doc map[string]interface{}) []Finding {
+	s := Schema(doc)
+	findings := []Finding{}
+	add := func(severity, code, pointer, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Code: code, Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	traits := s.Traits()
+	if traits == nil {
+		add(SeverityError, "missing_traits", "/properties/traits", "schema does not define a traits object")
+		return findings
+	}
+	if types := Types(traits); len(types) != 1 || types[0] != "object" {
+		add(SeverityError, "traits_not_object", "/properties/traits", "traits must be of type object")
+	}
+
+	identifiers := 0
+	s.walk(traits, "/traits", true, func(pointer string, node map[string]interface{}, required bool) {
+		ext := ParseExtension(node)
+		format, _ := node["format"].(string)
+
+		if len(ext.Identifiers) > 0 {
+			identifiers++
+			if !required {
+				add(SeverityWarning, "optional_identifier", pointer, "credential identifier is not required, identities without it cannot sign in with %v", ext.Identifiers)
+			}
+		}
+
+		if format == "email" {
+			if ext.Verification == "" {
+				add(SeverityWarning, "email_without_verification", pointer, "email field has no ory.sh/kratos verification extension")
+			}
+			if ext.Recovery == "" {
+				add(SeverityWarning, "email_without_recovery", pointer, "email field has no ory.sh/kratos recovery extension")
+			}
+		}
+		if (ext.Verification == "email" || ext.Recovery == "email") && format != "email" {
+			add(SeverityWarning, "address_without_email_format", pointer, "field is used as an email address but does not declare format \"email\"")
+		}
+
+		if pattern, ok := node["pattern"].(string); ok {
+			if _, err := regexp.Compile(pattern); err != nil {
+				add(SeverityError, "invalid_pattern", pointer, "pattern %q is invalid: %v", pattern, err)
+			}
+		}
+
+		props := s.Properties(node)
+		for name := range Required(node) {
+			if _, ok := props[name]; !ok {
+				add(SeverityError, "unknown_required", JoinPointer(pointer, name), "required property %s is not defined", name)
+			}
+		}
+	})
+
+	if identifiers == 0 {
+		add(SeverityError, "missing_identifier", "/traits", "no trait is marked as a credential identifier, identities cannot sign in")
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Pointer < findings[j].Pointer })
+	return findings
+}
+
+// MissingRequired returns the pointers of required traits absent from the given traits.
+// Nested objects are only checked when they are present.
+func MissingRequired(doc map[string]interface{}, traits map[string]interface{}) []string {
+	s := Schema(doc)
+	node := s.Traits()
+	if node == nil {
+		return nil
+	}
+
+	var missing []string
+	var check func(node map[string]interface{}, value map[string]interface{}, pointer string)
+	check = func(node map[string]interface{}, value map[string]interface{}, pointer string) {
+		props := s.Properties(node)
+		for name := range Required(node) {
+			if _, ok := value[name]; !ok {
+				missing = append(missing, JoinPointer(pointer, name))
+			}
+		}
+		for name, child := range props {
+			if nested, ok := value[name].(map[string]interface{}); ok {
+				check(child, nested, JoinPointer(pointer, name))
+			}
+		}
+	}
+	if traits == nil {
+		traits = map[string]interface{}{}
+	}
+	check(node, traits, "/traits")
+
+	sort.Strings(missing)
+	return missing
+}
+
+// walk visits every property below node, depth first.
+// required reports whether the property and all of its ancestors are required.
+func (s Schema) walk(node map[string]interface{}, pointer string, required bool, fn func(pointer string, node map[string]interface{}, required bool)) {
+	fn(pointer, node, required)
+
+	props := s.Properties(node)
+	req := Required(node)
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s.walk(props[name], JoinPointer(pointer, name), required && req[name], fn)
+	}
+
+	if items, ok := node["items"].(map[string]interface{}); ok {
+		s.walk(s.Resolve(items), JoinPointer(pointer, "-"), false, fn)
+	}
+}
@@ -0,0 +1,70 @@
+package schema
+
+import "fmt"
+
+// GetPointer returns the value at a JSON pointer inside a document
+func GetPointer(doc map[string]interface{}, pointer string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, token := range SplitPointer(pointer) {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[token]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// SetPointer sets the value at a JSON pointer, creating intermediate objects as needed
+func SetPointer(doc map[string]interface{}, pointer string, value interface{}) error {
+	tokens := SplitPointer(pointer)
+	if len(tokens) == 0 {
+		return fmt.Errorf("cannot replace the document root")
+	}
+
+	current := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		next, ok := current[token]
+		if !ok || next == nil {
+			child := map[string]interface{}{}
+			current[token] = child
+			current = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %q is not an object", pointer, token)
+		}
+		current = child
+	}
+
+	current[tokens[len(tokens)-1]] = value
+	return nil
+}
+
+// DeletePointer removes the value at a JSON pointer and reports whether it existed
+func DeletePointer(doc map[string]interface{}, pointer string) bool {
+	tokens := SplitPointer(pointer)
+	if len(tokens) == 0 {
+		return false
+	}
+
+	current := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		child, ok := current[token].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = child
+	}
+
+	last := tokens[len(tokens)-1]
+	if _, ok := current[last]; !ok {
+		return false
+	}
+	delete(current, last)
+	return true
+}
@@ -0,0 +1,181 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// pseudonymDomain is the reserved domain of pseudonymous email addresses and URLs
+const pseudonymDomain = "anonymized.invalid"
+
+// Pseudonymized is the result of replacing the values of identity traits with pseudonyms
+type Pseudonymized struct {
+	Traits map[string]interface{}
+	// Replaced lists the pointers of the values that were replaced, e.g. /traits/email.
+	// Removed lists optional values that were left out because no pseudonym matches their schema.
+	Replaced []string
+	Removed  []string
+}
+
+// Pseudonymize replaces the strings and numbers of identity traits with values that match their
+// schema: email addresses, phone numbers, URIs, dates and other format annotations get a valid
+// value of that format. Values restricted by enum or const, booleans and nulls are kept, and
+// optional lists are emptied. Pseudonyms are derived from seed, usually the identity ID, so
+// identifiers stay unique across anonymized identities.
+func Pseudonymize(doc map[string]interface{}, traits map[string]interface{}, seed string) Pseudonymized {
+	p := &pseudonymizer{schema: Schema(doc), validator: NewValidator(doc), seed: seed}
+	result, _ := p.value(p.schema.Traits(), normalize(traits), "/traits", true)
+	out, _ := result.(map[string]interface{})
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+
+	sort.Strings(p.result.Replaced)
+	sort.Strings(p.result.Removed)
+	p.result.Traits = out
+	return p.result
+}
+
+type pseudonymizer struct {
+	schema    Schema
+	validator *Validator
+	seed      string
+	result    Pseudonymized
+}
+
+// value returns the pseudonym of a value, or false when an optional value has to be left out
+func (p *pseudonymizer) value(node map[string]interface{}, value interface{}, pointer string, required bool) (interface{}, bool) {
+	node = p.schema.Resolve(node)
+	if _, ok := node["const"]; ok {
+		return value, true
+	}
+	if _, ok := node["enum"]; ok {
+		return value, true
+	}
+
+	var candidate interface{}
+	switch val := value.(type) {
+	case map[string]interface{}:
+		return p.object(node, val, pointer), true
+	case []interface{}:
+		return p.array(node, val, pointer), true
+	case string:
+		if val == "" {
+			return val, true
+		}
+		candidate = p.pseudonym(node, val, pointer)
+	case float64:
+		candidate = lowestNumber(node)
+	default:
+		return value, true
+	}
+
+	if !required && len(p.validator.validate(node, candidate, pointer)) > 0 {
+		p.result.Removed = append(p.result.Removed, pointer)
+		return nil, false
+	}
+	if candidate != value {
+		p.result.Replaced = append(p.result.Replaced, pointer)
+	}
+	return candidate, true
+}
+
+func (p *pseudonymizer) object(node map[string]interface{}, value map[string]interface{}, pointer string) map[string]interface{} {
+	props := p.schema.Properties(node)
+	required := Required(node)
+
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make(map[string]interface{}, len(value))
+	for _, key := range keys {
+		child := props[key]
+		if child == nil {
+			child, _ = node["additionalProperties"].(map[string]interface{})
+		}
+		if v, ok := p.value(child, value[key], JoinPointer(pointer, key), required[key]); ok {
+			out[key] = v
+		}
+	}
+	return out
+}
+
+// array empties lists that may be empty and replaces the items of the others
+func (p *pseudonymizer) array(node map[string]interface{}, value []interface{}, pointer string) []interface{} {
+	if min, _ := number(node["minItems"]); min == 0 {
+		if len(value) > 0 {
+			p.result.Replaced = append(p.result.Replaced, pointer)
+		}
+		return []interface{}{}
+	}
+
+	items, _ := node["items"].(map[string]interface{})
+	out := make([]interface{}, len(value))
+	for i, item := range value {
+		out[i], _ = p.value(items, item, JoinPointer(pointer, fmt.Sprint(i)), true)
+	}
+	return out
+}
+
+// pseudonym returns a string matching the format of the node, also for email addresses used for
+// verification or recovery, or recognized by their value, when the format is not annotated
+func (p *pseudonymizer) pseudonym(node map[string]interface{}, value, pointer string) string {
+	sum := sha256.Sum256([]byte(p.seed + pointer))
+	hash := hex.EncodeToString(sum[:])
+
+	format, _ := node["format"].(string)
+	ext := ParseExtension(node)
+	if format == "" && (ext.Verification == "email" || ext.Recovery == "email" || (strings.Contains(value, "@") && checkFormat("email", value) == "")) {
+		format = "email"
+	}
+
+	switch format {
+	case "email":
+		return "anonymized-" + hash[:16] + "@" + pseudonymDomain
+	case "tel":
+		// 555-01xx numbers are reserved for fiction in the North American numbering plan
+		return fmt.Sprintf("+1555%07d", binary.BigEndian.Uint32(sum[:4])%10000000)
+	case "uri", "url":
+		return "https://" + pseudonymDomain + "/" + hash[:16]
+	case "date":
+		return "1970-01-01"
+	case "date-time":
+		return "1970-01-01T00:00:00Z"
+	case "ipv4":
+		return "192.0.2.1"
+	case "ipv6":
+		return "2001:db8::1"
+	case "uuid":
+		return hash[0:8] + "-" + hash[8:12] + "-4" + hash[13:16] + "-8" + hash[17:20] + "-" + hash[20:32]
+	}
+
+	pseudonym := "anonymized-" + hash[:16]
+	if max, ok := number(node["maxLength"]); ok && float64(len(pseudonym)) > max {
+		pseudonym = hash[:int(max)]
+	}
+	if min, ok := number(node["minLength"]); ok && float64(utf8.RuneCountInString(pseudonym)) < min {
+		pseudonym += strings.Repeat(hash, int(min)/len(hash)+1)
+		pseudonym = pseudonym[:int(min)]
+	}
+	return pseudonym
+}
+
+// lowestNumber returns the smallest non-negative number allowed by the node, ignoring multipleOf
+func lowestNumber(node map[string]interface{}) float64 {
+	value := 0.0
+	if min, ok := number(node["minimum"]); ok && min > value {
+		value = min
+	}
+	if min, ok := number(node["exclusiveMinimum"]); ok && min >= value {
+		value = min + 1
+	}
+	return value
+}
@@ -0,0 +1,189 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pseudonymSchema is an identity schema with a trait for every kind of value Pseudonymize handles
+const pseudonymSchema = `{
+	"properties": {"traits": {
+		"type": "object",
+		"properties": {
+			"email": {"type": "string", "format": "email", "ory.sh/kratos": {"credentials": {"password": {"identifier": true}}}},
+			"recovery": {"type": "string", "ory.sh/kratos": {"recovery": {"via": "email"}}},
+			"contact": {"type": "string"},
+			"phone": {"type": "string", "format": "tel"},
+			"website": {"type": "string", "format": "uri"},
+			"birthday": {"type": "string", "format": "date"},
+			"seen_at": {"type": "string", "format": "date-time"},
+			"ip": {"type": "string", "format": "ipv4"},
+			"ipv6": {"type": "string", "format": "ipv6"},
+			"external_id": {"type": "string", "format": "uuid"},
+			"name": {
+				"type": "object",
+				"properties": {
+					"first": {"type": "string", "minLength": 40},
+					"last": {"type": "string", "maxLength": 8}
+				},
+				"required": ["first", "last"]
+			},
+			"username": {"type": "string", "pattern": "^[a-z0-9-]+$"},
+			"employee_number": {"type": "string", "pattern": "^E[0-9]{6}$"},
+			"plan": {"type": "string", "enum": ["free", "pro"]},
+			"tos": {"const": true},
+			"newsletter": {"type": "boolean"},
+			"nickname": {"type": ["string", "null"]},
+			"age": {"type": "integer", "minimum": 18},
+			"score": {"type": "number", "exclusiveMinimum": 5},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"emails": {"type": "array", "minItems": 1, "items": {"type": "string", "format": "email"}},
+			"extra": {"type": "object", "additionalProperties": {"type": "string", "format": "email"}}
+		},
+		"required": ["email", "name", "emails"]
+	}}
+}`
+
+// pseudonymTraits are the personal data of Jane Doe for pseudonymSchema
+const pseudonymTraits = `{
+	"email": "jane@example.org",
+	"recovery": "jane.doe",
+	"contact": "jane@doe.example",
+	"phone": "+33612345678",
+	"website": "https://jane.example.org",
+	"birthday": "1990-05-17",
+	"seen_at": "2024-01-01T10:00:00Z",
+	"ip": "203.0.113.7",
+	"ipv6": "2001:db8::7",
+	"external_id": "0d1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3d",
+	"name": {"first": "Jane", "last": "Doe-Smithson"},
+	"username": "jane-doe",
+	"employee_number": "E123456",
+	"plan": "pro",
+	"tos": true,
+	"newsletter": true,
+	"nickname": null,
+	"age": 34,
+	"score": 42,
+	"tags": ["admin", "beta"],
+	"emails": ["jane@example.org", "j.doe@example.org"],
+	"extra": {"work": "jane@work.example"}
+}`
+
+func TestPseudonymize(t *testing.T) {
+	doc := decode(t, pseudonymSchema)
+	traits := decode(t, pseudonymTraits)
+
+	result := Pseudonymize(doc, traits, "identity-1")
+
+	if errs := NewValidator(doc).ValidateTraits(result.Traits); len(errs) > 0 {
+		t.Fatalf("pseudonymous traits are invalid: %v", errs)
+	}
+
+	// Every personal value is gone
+	raw, _ := json.Marshal(result.Traits)
+	for _, value := range []string{"jane", "Jane", "Doe", "example.org", "+33612345678", "1990", "2024", "203.0.113.7", "2001:db8::7", "0d1b2c3d", "E123456", "admin"} {
+		if strings.Contains(string(raw), value) {
+			t.Errorf("traits %s still contain %q", raw, value)
+		}
+	}
+
+	tests := []struct {
+		pointer string
+		check   func(value interface{}) bool
+	}{
+		{"/email", hasPrefixAndSuffix("anonymized-", "@"+pseudonymDomain)},
+		{"/recovery", hasPrefixAndSuffix("anonymized-", "@"+pseudonymDomain)},
+		{"/contact", hasPrefixAndSuffix("anonymized-", "@"+pseudonymDomain)},
+		{"/phone", hasPrefixAndSuffix("+1555", "")},
+		{"/website", hasPrefixAndSuffix("https://"+pseudonymDomain+"/", "")},
+		{"/birthday", equals("1970-01-01")},
+		{"/seen_at", equals("1970-01-01T00:00:00Z")},
+		{"/ip", equals("192.0.2.1")},
+		{"/ipv6", equals("2001:db8::1")},
+		{"/name/first", func(v interface{}) bool { s, _ := v.(string); return len(s) == 40 }},
+		{"/name/last", func(v interface{}) bool { s, _ := v.(string); return len(s) == 8 }},
+		{"/username", hasPrefixAndSuffix("anonymized-", "")},
+		{"/plan", equals("pro")},
+		{"/tos", equals(true)},
+		{"/newsletter", equals(true)},
+		{"/nickname", equals(nil)},
+		{"/age", equals(18.0)},
+		{"/score", equals(6.0)},
+		{"/tags", func(v interface{}) bool { l, ok := v.([]interface{}); return ok && len(l) == 0 }},
+		{"/emails", func(v interface{}) bool {
+			l, _ := v.([]interface{})
+			return len(l) == 2 && l[0] != l[1] && hasPrefixAndSuffix("anonymized-", "@"+pseudonymDomain)(l[0])
+		}},
+		{"/extra/work", hasPrefixAndSuffix("anonymized-", "@"+pseudonymDomain)},
+	}
+	for _, tt := range tests {
+		value, ok := GetPointer(result.Traits, tt.pointer)
+		if !ok || !tt.check(value) {
+			t.Errorf("%s = %#v", tt.pointer, value)
+		}
+	}
+
+	if _, ok := result.Traits["employee_number"]; ok {
+		t.Errorf("employee_number = %v, want it removed since no pseudonym matches its pattern", result.Traits["employee_number"])
+	}
+	if !reflect.DeepEqual(result.Removed, []string{"/traits/employee_number"}) {
+		t.Errorf("Removed = %v, want [/traits/employee_number]", result.Removed)
+	}
+	for _, kept := range []string{"/traits/plan", "/traits/tos", "/traits/newsletter", "/traits/nickname"} {
+		for _, pointer := range result.Replaced {
+			if pointer == kept {
+				t.Errorf("Replaced lists %s, whose value is kept", kept)
+			}
+		}
+	}
+	if len(result.Replaced) != 19 {
+		t.Errorf("Replaced = %v, want the 19 replaced values", result.Replaced)
+	}
+}
+
+func TestPseudonymizeIsDerivedFromSeed(t *testing.T) {
+	doc := decode(t, pseudonymSchema)
+
+	first := Pseudonymize(doc, decode(t, pseudonymTraits), "identity-1")
+	again := Pseudonymize(doc, decode(t, pseudonymTraits), "identity-1")
+	other := Pseudonymize(doc, decode(t, pseudonymTraits), "identity-2")
+
+	if !reflect.DeepEqual(first.Traits, again.Traits) {
+		t.Errorf("pseudonyms of the same seed differ: %v and %v", first.Traits, again.Traits)
+	}
+	if first.Traits["email"] == other.Traits["email"] || first.Traits["phone"] == other.Traits["phone"] {
+		t.Errorf("identities 1 and 2 share pseudonyms: %v and %v", first.Traits, other.Traits)
+	}
+}
+
+func TestPseudonymizeRequiredWithoutPseudonym(t *testing.T) {
+	doc := decode(t, `{"properties":{"traits":{
+		"type":"object",
+		"properties":{"employee_number":{"type":"string","pattern":"^E[0-9]{6}$"}},
+		"required":["employee_number"]
+	}}}`)
+
+	result := Pseudonymize(doc, decode(t, `{"employee_number":"E123456"}`), "identity-1")
+
+	// Required traits are never left out, the caller refuses invalid pseudonymous traits
+	if len(result.Removed) != 0 || result.Traits["employee_number"] == "E123456" {
+		t.Fatalf("result = %+v, want the value replaced", result)
+	}
+	if errs := NewValidator(doc).ValidateTraits(result.Traits); len(errs) != 1 || errs[0].Pointer != "/traits/employee_number" {
+		t.Errorf("errors = %v, want the pattern of employee_number reported", errs)
+	}
+}
+
+func hasPrefixAndSuffix(prefix, suffix string) func(interface{}) bool {
+	return func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, prefix) && strings.HasSuffix(s, suffix)
+	}
+}
+
+func equals(want interface{}) func(interface{}) bool {
+	return func(v interface{}) bool { return reflect.DeepEqual(v, want) }
+}
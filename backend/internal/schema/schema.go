@@ -0,0 +1,176 @@
+package schema
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ExtensionKey is the JSON schema keyword Kratos uses for its own annotations
+const ExtensionKey = "ory.sh/kratos"
+
+// Schema is a decoded identity JSON schema document
+type Schema map[string]interface{}
+
+// Resolve follows a local $ref on the given node and returns the referenced node.
+// Nodes without a $ref, or with a $ref that cannot be resolved locally, are returned as-is.
+func (s Schema) Resolve(node map[string]interface{}) map[string]interface{} {
+	// Guard against reference cycles
+	for i := 0; i < 32; i++ {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		target, ok := s.lookup(ref)
+		if !ok {
+			return node
+		}
+		node = target
+	}
+	return node
+}
+
+// lookup resolves a local JSON pointer reference such as "#/definitions/name"
+func (s Schema) lookup(ref string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+
+	fragment := strings.TrimPrefix(ref, "#")
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		fragment = unescaped
+	}
+
+	var current interface{} = map[string]interface{}(s)
+	for _, token := range SplitPointer(fragment) {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[token]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	node, ok := current.(map[string]interface{})
+	return node, ok
+}
+
+// Traits returns the resolved sub-schema describing identity traits
+func (s Schema) Traits() map[string]interface{} {
+	props, ok := s.Resolve(s)["properties"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	traits, ok := props["traits"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return s.Resolve(traits)
+}
+
+// Properties returns the resolved properties of an object node
+func (s Schema) Properties(node map[string]interface{}) map[string]map[string]interface{} {
+	props, ok := node["properties"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := make(map[string]map[string]interface{}, len(props))
+	for name, raw := range props {
+		if child, ok := raw.(map[string]interface{}); ok {
+			result[name] = s.Resolve(child)
+		}
+	}
+	return result
+}
+
+// Types returns the declared JSON types of a node
+func Types(node map[string]interface{}) []string {
+	switch t := node["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// Required returns the names listed in a node's "required" keyword
+func Required(node map[string]interface{}) map[string]bool {
+	result := map[string]bool{}
+	if list, ok := node["required"].([]interface{}); ok {
+		for _, item := range list {
+			if name, ok := item.(string); ok {
+				result[name] = true
+			}
+		}
+	}
+	return result
+}
+
+// Extension describes the ory.sh/kratos annotations of a single trait
+type Extension struct {
+	Identifiers  []string `json:"identifiers,omitempty"`
+	Verification string   `json:"verification,omitempty"`
+	Recovery     string   `json:"recovery,omitempty"`
+}
+
+// IsEmpty reports whether the trait carries no Kratos annotations
+func (e Extension) IsEmpty() bool {
+	return len(e.Identifiers) == 0 && e.Verification == "" && e.Recovery == ""
+}
+
+// ParseExtension reads the ory.sh/kratos annotations of a node, ignoring unknown keys
+func ParseExtension(node map[string]interface{}) Extension {
+	var ext Extension
+	raw, ok := node[ExtensionKey].(map[string]interface{})
+	if !ok {
+		return ext
+	}
+
+	if creds, ok := raw["credentials"].(map[string]interface{}); ok {
+		for credType, cfg := range creds {
+			if m, ok := cfg.(map[string]interface{}); ok && m["identifier"] == true {
+				ext.Identifiers = append(ext.Identifiers, credType)
+			}
+		}
+		sort.Strings(ext.Identifiers)
+	}
+	if v, ok := raw["verification"].(map[string]interface{}); ok {
+		ext.Verification, _ = v["via"].(string)
+	}
+	if r, ok := raw["recovery"].(map[string]interface{}); ok {
+		ext.Recovery, _ = r["via"].(string)
+	}
+
+	return ext
+}
+
+// JoinPointer appends an unescaped token to a JSON pointer
+func JoinPointer(pointer, token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return pointer + "/" + token
+}
+
+// SplitPointer splits a JSON pointer into its unescaped tokens
+func SplitPointer(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, "~1", "/")
+		parts[i] = strings.ReplaceAll(part, "~0", "~")
+	}
+	return parts
+}
@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"reflect"
+	"sort"
+)
+
+// FieldChange describes a trait whose value differs between two versions of the traits
+type FieldChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// DiffTraits lists the leaf values that differ between before and after, sorted by JSON pointer
+func DiffTraits(before, after map[string]interface{}) []FieldChange {
+	from, to := map[string]interface{}{}, map[string]interface{}{}
+	flattenTraits("", before, from)
+	flattenTraits("", after, to)
+
+	changes := []FieldChange{}
+	for path, value := range from {
+		if other, ok := to[path]; !ok || !reflect.DeepEqual(value, other) {
+			changes = append(changes, FieldChange{Path: path, From: value, To: to[path]})
+		}
+	}
+	for path, value := range to {
+		if _, ok := from[path]; !ok {
+			changes = append(changes, FieldChange{Path: path, To: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// flattenTraits collects the non-object values of traits keyed by JSON pointer.
+// Arrays are compared as a whole.
+func flattenTraits(pointer string, traits map[string]interface{}, out map[string]interface{}) {
+	for key, value := range traits {
+		path := JoinPointer(pointer, key)
+		if object, ok := value.(map[string]interface{}); ok {
+			flattenTraits(path, object, out)
+			continue
+		}
+		out[path] = value
+	}
+}
@@ -0,0 +1,499 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError describes a single value that does not match the schema
+type ValidationError struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// Validator validates documents against an identity JSON schema.
+// It implements the subset of draft-07 used by Kratos identity schemas and
+// treats unknown keywords, including the ory.sh/kratos extension, as annotations.
+type Validator struct {
+	schema Schema
+}
+
+// NewValidator creates a new validator for the given schema document
+func NewValidator(doc map[string]interface{}) *Validator {
+	return &Validator{schema: Schema(doc)}
+}
+
+// ValidateTraits validates identity traits and returns errors with pointers relative to the identity,
+// e.g. "/traits/email"
+func (v *Validator) ValidateTraits(traits map[string]interface{}) []ValidationError {
+	if traits == nil {
+		traits = map[string]interface{}{}
+	}
+	return v.Validate(map[string]interface{}{"traits": traits})
+}
+
+// Validate validates an arbitrary document against the schema root
+func (v *Validator) Validate(instance interface{}) []ValidationError {
+	errs := v.validate(v.schema, normalize(instance), "")
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Pointer < errs[j].Pointer })
+	return errs
+}
+
+func (v *Validator) validate(node map[string]interface{}, value interface{}, pointer string) []ValidationError {
+	node = v.schema.Resolve(node)
+	var errs []ValidationError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if types := Types(node); len(types) > 0 && !matchesAnyType(value, types) {
+		fail("expected %s, but got %s", strings.Join(types, " or "), typeOf(value))
+		return errs
+	}
+
+	if enum, ok := node["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		fail("value must be one of %s", formatEnum(enum))
+	}
+	if constant, ok := node["const"]; ok && !reflect.DeepEqual(normalize(constant), value) {
+		fail("value must be %v", constant)
+	}
+
+	switch val := value.(type) {
+	case string:
+		errs = append(errs, v.validateString(node, val, pointer)...)
+	case float64:
+		errs = append(errs, validateNumber(node, val, pointer)...)
+	case []interface{}:
+		errs = append(errs, v.validateArray(node, val, pointer)...)
+	case map[string]interface{}:
+		errs = append(errs, v.validateObject(node, val, pointer)...)
+	}
+
+	errs = append(errs, v.validateCombinators(node, value, pointer)...)
+	return errs
+}
+
+func (v *Validator) validateString(node map[string]interface{}, value string, pointer string) []ValidationError {
+	var errs []ValidationError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(value)
+	if min, ok := number(node["minLength"]); ok && float64(length) < min {
+		fail("length must be >= %v, but got %d", min, length)
+	}
+	if max, ok := number(node["maxLength"]); ok && float64(length) > max {
+		fail("length must be <= %v, but got %d", max, length)
+	}
+	if pattern, ok := node["pattern"].(string); ok {
+		re, err := compilePattern(pattern)
+		if err == nil && !re.MatchString(value) {
+			fail("does not match pattern %q", pattern)
+		}
+	}
+	if format, ok := node["format"].(string); ok {
+		if msg := checkFormat(format, value); msg != "" {
+			fail("%s", msg)
+		}
+	}
+
+	// Kratos sends verification and recovery messages to these addresses, so they must be deliverable
+	ext := ParseExtension(node)
+	if (ext.Verification == "email" || ext.Recovery == "email") && node["format"] != "email" && value != "" {
+		if msg := checkFormat("email", value); msg != "" {
+			fail("%s", msg)
+		}
+	}
+
+	return errs
+}
+
+func validateNumber(node map[string]interface{}, value float64, pointer string) []ValidationError {
+	var errs []ValidationError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if min, ok := number(node["minimum"]); ok && value < min {
+		fail("must be >= %v but found %v", min, value)
+	}
+	if max, ok := number(node["maximum"]); ok && value > max {
+		fail("must be <= %v but found %v", max, value)
+	}
+	if min, ok := number(node["exclusiveMinimum"]); ok && value <= min {
+		fail("must be > %v but found %v", min, value)
+	}
+	if max, ok := number(node["exclusiveMaximum"]); ok && value >= max {
+		fail("must be < %v but found %v", max, value)
+	}
+	if multiple, ok := number(node["multipleOf"]); ok && multiple > 0 {
+		if q := value / multiple; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v", multiple)
+		}
+	}
+
+	return errs
+}
+
+func (v *Validator) validateArray(node map[string]interface{}, value []interface{}, pointer string) []ValidationError {
+	var errs []ValidationError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if min, ok := number(node["minItems"]); ok && float64(len(value)) < min {
+		fail("must have at least %v items", min)
+	}
+	if max, ok := number(node["maxItems"]); ok && float64(len(value)) > max {
+		fail("must have at most %v items", max)
+	}
+	if node["uniqueItems"] == true {
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					fail("items at index %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+
+	switch items := node["items"].(type) {
+	case map[string]interface{}:
+		for i, item := range value {
+			errs = append(errs, v.validate(items, item, JoinPointer(pointer, fmt.Sprint(i)))...)
+		}
+	case []interface{}:
+		for i, item := range value {
+			if i < len(items) {
+				if child, ok := items[i].(map[string]interface{}); ok {
+					errs = append(errs, v.validate(child, item, JoinPointer(pointer, fmt.Sprint(i)))...)
+				}
+			} else if node["additionalItems"] == false {
+				fail("additional items are not allowed")
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+func (v *Validator) validateObject(node map[string]interface{}, value map[string]interface{}, pointer string) []ValidationError {
+	var errs []ValidationError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	required := Required(node)
+	names := make([]string, 0, len(required))
+	for name := range required {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := value[name]; !ok {
+			errs = append(errs, ValidationError{Pointer: JoinPointer(pointer, name), Message: fmt.Sprintf("property %s is missing", name)})
+		}
+	}
+
+	if min, ok := number(node["minProperties"]); ok && float64(len(value)) < min {
+		fail("must have at least %v properties", min)
+	}
+	if max, ok := number(node["maxProperties"]); ok && float64(len(value)) > max {
+		fail("must have at most %v properties", max)
+	}
+
+	props, _ := node["properties"].(map[string]interface{})
+	patternProps, _ := node["patternProperties"].(map[string]interface{})
+
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPointer := JoinPointer(pointer, key)
+		matched := false
+
+		if child, ok := props[key].(map[string]interface{}); ok {
+			matched = true
+			errs = append(errs, v.validate(child, value[key], childPointer)...)
+		}
+		for pattern, raw := range patternProps {
+			re, err := compilePattern(pattern)
+			child, ok := raw.(map[string]interface{})
+			if err != nil || !ok || !re.MatchString(key) {
+				continue
+			}
+			matched = true
+			errs = append(errs, v.validate(child, value[key], childPointer)...)
+		}
+		if matched {
+			continue
+		}
+
+		switch additional := node["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, ValidationError{Pointer: childPointer, Message: fmt.Sprintf("additional property %s is not allowed", key)})
+			}
+		case map[string]interface{}:
+			errs = append(errs, v.validate(additional, value[key], childPointer)...)
+		}
+	}
+
+	return errs
+}
+
+func (v *Validator) validateCombinators(node map[string]interface{}, value interface{}, pointer string) []ValidationError {
+	var errs []ValidationError
+
+	for _, sub := range subSchemas(node["allOf"]) {
+		errs = append(errs, v.validate(sub, value, pointer)...)
+	}
+
+	if subs := subSchemas(node["anyOf"]); len(subs) > 0 {
+		matched := false
+		for _, sub := range subs {
+			if len(v.validate(sub, value, pointer)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, ValidationError{Pointer: pointer, Message: "value does not match any of the allowed schemas"})
+		}
+	}
+
+	if subs := subSchemas(node["oneOf"]); len(subs) > 0 {
+		matches := 0
+		for _, sub := range subs {
+			if len(v.validate(sub, value, pointer)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			errs = append(errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf("value must match exactly one schema, but matched %d", matches)})
+		}
+	}
+
+	if not, ok := node["not"].(map[string]interface{}); ok && len(v.validate(not, value, pointer)) == 0 {
+		errs = append(errs, ValidationError{Pointer: pointer, Message: "value matches a schema it must not match"})
+	}
+
+	if cond, ok := node["if"].(map[string]interface{}); ok {
+		branch := "else"
+		if len(v.validate(cond, value, pointer)) == 0 {
+			branch = "then"
+		}
+		if sub, ok := node[branch].(map[string]interface{}); ok {
+			errs = append(errs, v.validate(sub, value, pointer)...)
+		}
+	}
+
+	return errs
+}
+
+var (
+	patternCache sync.Map
+	telPattern   = regexp.MustCompile(`^\+?[0-9][0-9 ().-]{3,}$`)
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// compilePattern compiles and caches ECMA-style patterns used in the schema
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Store(pattern, re)
+	return re, nil
+}
+
+// checkFormat returns an error message if value does not match a known format.
+// Unknown formats are accepted, as the JSON schema spec treats them as annotations.
+func checkFormat(format, value string) string {
+	switch format {
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return fmt.Sprintf("%q is not valid \"email\"", value)
+		}
+	case "uri", "url":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" {
+			return fmt.Sprintf("%q is not valid \"%s\"", value, format)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Sprintf("%q is not valid \"date\"", value)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Sprintf("%q is not valid \"date-time\"", value)
+		}
+	case "tel":
+		if !telPattern.MatchString(value) {
+			return fmt.Sprintf("%q is not valid \"tel\"", value)
+		}
+	case "ipv4":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return fmt.Sprintf("%q is not valid \"ipv4\"", value)
+		}
+	case "ipv6":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() != nil {
+			return fmt.Sprintf("%q is not valid \"ipv6\"", value)
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			return fmt.Sprintf("%q is not valid \"uuid\"", value)
+		}
+	}
+	return ""
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, t := range types {
+		if matchesType(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(value interface{}, t string) bool {
+	switch t {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(normalize(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(list []interface{}) string {
+	parts := make([]string, 0, len(list))
+	for _, item := range list {
+		encoded, _ := json.Marshal(item)
+		parts = append(parts, string(encoded))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func subSchemas(raw interface{}) []map[string]interface{} {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if sub, ok := item.(map[string]interface{}); ok {
+			result = append(result, sub)
+		}
+	}
+	return result
+}
+
+func number(raw interface{}) (float64, bool) {
+	switch n := raw.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// normalize converts a value into the generic shape produced by encoding/json
+// so that Go values built in code compare equal to decoded JSON
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, float64, bool:
+		return value
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalize(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = normalize(item)
+		}
+		return result
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var result interface{}
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		return value
+	}
+	return result
+}
@@ -0,0 +1,261 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// decode parses a JSON literal of a test case
+func decode(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		t.Fatalf("invalid JSON %s: %v", raw, err)
+	}
+	return doc
+}
+
+func TestValidateTraits(t *testing.T) {
+	tests := []struct {
+		name   string
+		traits string // schema of the traits property
+		value  string
+		want   []ValidationError
+	}{
+		{
+			name:   "valid",
+			traits: `{"type":"object","properties":{"email":{"type":"string","format":"email"}},"required":["email"]}`,
+			value:  `{"email":"jane@example.com"}`,
+		},
+		{
+			name:   "type",
+			traits: `{"type":"object","properties":{"age":{"type":"integer"},"name":{"type":["string","null"]}}}`,
+			value:  `{"age":1.5,"name":true}`,
+			want: []ValidationError{
+				{"/traits/age", "expected integer, but got number"},
+				{"/traits/name", "expected string or null, but got boolean"},
+			},
+		},
+		{
+			name:   "required",
+			traits: `{"type":"object","properties":{"name":{"type":"object","required":["first","last"]}},"required":["email","name"]}`,
+			value:  `{"name":{"first":"Jane"}}`,
+			want: []ValidationError{
+				{"/traits/email", "property email is missing"},
+				{"/traits/name/last", "property last is missing"},
+			},
+		},
+		{
+			name:   "enum and const",
+			traits: `{"properties":{"plan":{"enum":["free","pro"]},"tos":{"const":true}}}`,
+			value:  `{"plan":"team","tos":false}`,
+			want: []ValidationError{
+				{"/traits/plan", `value must be one of ["free", "pro"]`},
+				{"/traits/tos", "value must be true"},
+			},
+		},
+		{
+			name:   "string length and pattern",
+			traits: `{"properties":{"a":{"minLength":3},"b":{"maxLength":2},"c":{"pattern":"^[a-z]+$"}}}`,
+			value:  `{"a":"é","b":"abc","c":"ABC"}`,
+			want: []ValidationError{
+				{"/traits/a", "length must be >= 3, but got 1"},
+				{"/traits/b", "length must be <= 2, but got 3"},
+				{"/traits/c", `does not match pattern "^[a-z]+$"`},
+			},
+		},
+		{
+			name: "formats",
+			traits: `{"properties":{
+				"email":{"format":"email"},"uri":{"format":"uri"},"date":{"format":"date"},
+				"date_time":{"format":"date-time"},"tel":{"format":"tel"},"ipv4":{"format":"ipv4"},
+				"ipv6":{"format":"ipv6"},"uuid":{"format":"uuid"},"custom":{"format":"custom"}}}`,
+			value: `{"email":"Jane <jane@example.com>","uri":"example.com","date":"2024-13-01",
+				"date_time":"2024-01-01","tel":"call me","ipv4":"::1","ipv6":"127.0.0.1","uuid":"123","custom":"anything"}`,
+			want: []ValidationError{
+				{"/traits/date", `"2024-13-01" is not valid "date"`},
+				{"/traits/date_time", `"2024-01-01" is not valid "date-time"`},
+				{"/traits/email", `"Jane <jane@example.com>" is not valid "email"`},
+				{"/traits/ipv4", `"::1" is not valid "ipv4"`},
+				{"/traits/ipv6", `"127.0.0.1" is not valid "ipv6"`},
+				{"/traits/tel", `"call me" is not valid "tel"`},
+				{"/traits/uri", `"example.com" is not valid "uri"`},
+				{"/traits/uuid", `"123" is not valid "uuid"`},
+			},
+		},
+		{
+			name:   "verification address must be an email",
+			traits: `{"properties":{"email":{"type":"string","ory.sh/kratos":{"verification":{"via":"email"}}}}}`,
+			value:  `{"email":"jane"}`,
+			want:   []ValidationError{{"/traits/email", `"jane" is not valid "email"`}},
+		},
+		{
+			name: "numbers",
+			traits: `{"properties":{"min":{"minimum":1},"max":{"maximum":10},"xmin":{"exclusiveMinimum":1},
+				"xmax":{"exclusiveMaximum":10},"step":{"multipleOf":0.5},"ok":{"multipleOf":0.1}}}`,
+			value: `{"min":0,"max":11,"xmin":1,"xmax":10,"step":0.7,"ok":0.3}`,
+			want: []ValidationError{
+				{"/traits/max", "must be <= 10 but found 11"},
+				{"/traits/min", "must be >= 1 but found 0"},
+				{"/traits/step", "must be a multiple of 0.5"},
+				{"/traits/xmax", "must be < 10 but found 10"},
+				{"/traits/xmin", "must be > 1 but found 1"},
+			},
+		},
+		{
+			name:   "array size and uniqueness",
+			traits: `{"properties":{"a":{"minItems":2},"b":{"maxItems":1},"c":{"uniqueItems":true}}}`,
+			value:  `{"a":[1],"b":[1,2],"c":[{"x":1},{"x":1}]}`,
+			want: []ValidationError{
+				{"/traits/a", "must have at least 2 items"},
+				{"/traits/b", "must have at most 1 items"},
+				{"/traits/c", "items at index 0 and 1 are equal"},
+			},
+		},
+		{
+			name:   "array items",
+			traits: `{"properties":{"emails":{"items":{"type":"string"}},"pair":{"items":[{"type":"string"},{"type":"number"}],"additionalItems":false}}}`,
+			value:  `{"emails":["a",2],"pair":["a","b","c"]}`,
+			want: []ValidationError{
+				{"/traits/emails/1", "expected string, but got number"},
+				{"/traits/pair", "additional items are not allowed"},
+				{"/traits/pair/1", "expected number, but got string"},
+			},
+		},
+		{
+			name:   "object size",
+			traits: `{"properties":{"a":{"minProperties":1},"b":{"maxProperties":1}}}`,
+			value:  `{"a":{},"b":{"x":1,"y":2}}`,
+			want: []ValidationError{
+				{"/traits/a", "must have at least 1 properties"},
+				{"/traits/b", "must have at most 1 properties"},
+			},
+		},
+		{
+			name:   "additional and pattern properties",
+			traits: `{"properties":{"name":{"type":"string"}},"patternProperties":{"^x_":{"type":"number"}},"additionalProperties":false}`,
+			value:  `{"name":"Jane","x_age":"old","nickname":"J"}`,
+			want: []ValidationError{
+				{"/traits/nickname", "additional property nickname is not allowed"},
+				{"/traits/x_age", "expected number, but got string"},
+			},
+		},
+		{
+			name:   "additional properties schema",
+			traits: `{"additionalProperties":{"type":"string"}}`,
+			value:  `{"a":"ok","b":1}`,
+			want:   []ValidationError{{"/traits/b", "expected string, but got number"}},
+		},
+		{
+			name:   "allOf",
+			traits: `{"properties":{"name":{"allOf":[{"minLength":2},{"maxLength":3}]}}}`,
+			value:  `{"name":"Jane"}`,
+			want:   []ValidationError{{"/traits/name", "length must be <= 3, but got 4"}},
+		},
+		{
+			name:   "anyOf",
+			traits: `{"properties":{"id":{"anyOf":[{"type":"string"},{"type":"integer"}]},"ok":{"anyOf":[{"type":"string"},{"type":"integer"}]}}}`,
+			value:  `{"id":true,"ok":3}`,
+			want:   []ValidationError{{"/traits/id", "value does not match any of the allowed schemas"}},
+		},
+		{
+			name:   "oneOf",
+			traits: `{"properties":{"none":{"oneOf":[{"type":"string"},{"type":"integer"}]},"both":{"oneOf":[{"type":"number"},{"type":"integer"}]}}}`,
+			value:  `{"none":true,"both":1}`,
+			want: []ValidationError{
+				{"/traits/both", "value must match exactly one schema, but matched 2"},
+				{"/traits/none", "value must match exactly one schema, but matched 0"},
+			},
+		},
+		{
+			name:   "not",
+			traits: `{"properties":{"name":{"not":{"const":"admin"}}}}`,
+			value:  `{"name":"admin"}`,
+			want:   []ValidationError{{"/traits/name", "value matches a schema it must not match"}},
+		},
+		{
+			name: "if then else",
+			traits: `{"properties":{"a":{"$ref":"#/definitions/contact"},"b":{"$ref":"#/definitions/contact"}},
+				"definitions":{"contact":{"if":{"required":["phone"]},"then":{"required":["country"]},"else":{"required":["email"]}}}}`,
+			value: `{"a":{"phone":"+33 1 23 45 67 89"},"b":{}}`,
+			want: []ValidationError{
+				{"/traits/a/country", "property country is missing"},
+				{"/traits/b/email", "property email is missing"},
+			},
+		},
+		{
+			name:   "ref",
+			traits: `{"properties":{"email":{"$ref":"#/definitions/email"}},"definitions":{"email":{"type":"string","format":"email"}}}`,
+			value:  `{"email":"not an email"}`,
+			want:   []ValidationError{{"/traits/email", `"not an email" is not valid "email"`}},
+		},
+		{
+			name:   "escaped pointer",
+			traits: `{"properties":{"a/b":{"type":"string"},"c~d":{"type":"string"}}}`,
+			value:  `{"a/b":1,"c~d":2}`,
+			want: []ValidationError{
+				{"/traits/a~1b", "expected string, but got number"},
+				{"/traits/c~0d", "expected string, but got number"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traits := decode(t, tt.traits)
+			doc := map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"traits": traits},
+			}
+			// Local references resolve against the document root, as in Kratos schemas
+			if definitions, ok := traits["definitions"]; ok {
+				doc["definitions"] = definitions
+			}
+
+			got := NewValidator(doc).ValidateTraits(decode(t, tt.value))
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTraitsGoValues(t *testing.T) {
+	doc := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"traits": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"age":  map[string]interface{}{"type": "integer", "minimum": 18},
+					"tags": map[string]interface{}{"items": map[string]interface{}{"enum": []interface{}{"a", "b"}}},
+				},
+			},
+		},
+	}
+
+	// Values built in code are compared like decoded JSON
+	got := NewValidator(doc).ValidateTraits(map[string]interface{}{"age": 17, "tags": []string{"a", "c"}})
+	want := []ValidationError{
+		{"/traits/age", "must be >= 18 but found 17"},
+		{"/traits/tags/1", `value must be one of ["a", "b"]`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors = %v, want %v", got, want)
+	}
+}
+
+func TestValidateTraitsNil(t *testing.T) {
+	doc := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"traits": map[string]interface{}{"type": "object", "required": []interface{}{"email"}},
+		},
+	}
+
+	got := NewValidator(doc).ValidateTraits(nil)
+	want := []ValidationError{{"/traits/email", "property email is missing"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors = %v, want %v", got, want)
+	}
+}
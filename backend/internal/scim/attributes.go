@@ -0,0 +1,127 @@
+package scim
+
+import (
+	"strings"
+)
+
+// multiValued lists the core User attributes holding arrays of objects, lower-cased
+var multiValued = map[string]bool{
+	"emails":           true,
+	"phonenumbers":     true,
+	"ims":              true,
+	"photos":           true,
+	"addresses":        true,
+	"groups":           true,
+	"entitlements":     true,
+	"roles":            true,
+	"x509certificates": true,
+}
+
+// splitPath splits an attribute path such as "name.givenName" into its segments.
+// The core User schema URN prefix is dropped, and extension URNs are kept as the first segment.
+func splitPath(path string) []string {
+	if strings.HasPrefix(strings.ToLower(path), strings.ToLower(SchemaUser)+":") {
+		path = path[len(SchemaUser)+1:]
+	} else if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		i := strings.LastIndex(path, ":")
+		return append([]string{path[:i]}, strings.Split(path[i+1:], ".")...)
+	}
+	return strings.Split(path, ".")
+}
+
+// lookup returns the key and value of an attribute, matching its name case-insensitively
+func lookup(obj map[string]interface{}, name string) (string, interface{}, bool) {
+	if value, ok := obj[name]; ok {
+		return name, value, true
+	}
+	for key, value := range obj {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return name, nil, false
+}
+
+// primary returns the element of a multi-valued attribute marked primary, or the first one
+func primary(list []interface{}) (map[string]interface{}, bool) {
+	var first map[string]interface{}
+	for _, item := range list {
+		element, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if first == nil {
+			first = element
+		}
+		if isTrue(element["primary"]) {
+			return element, true
+		}
+	}
+	return first, first != nil
+}
+
+// getPath returns the value of an attribute path. Sub-attributes of multi-valued
+// attributes are read from their primary element.
+func getPath(resource map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = resource
+	for _, segment := range splitPath(path) {
+		if list, ok := current.([]interface{}); ok {
+			element, ok := primary(list)
+			if !ok {
+				return nil, false
+			}
+			current = element
+		}
+
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if _, current, ok = lookup(obj, segment); !ok {
+			return nil, false
+		}
+	}
+	return current, current != nil
+}
+
+// setPath sets the value of an attribute path, creating intermediate objects. Sub-attributes
+// of multi-valued attributes are written to their primary element, which is created if needed.
+func setPath(resource map[string]interface{}, path string, value interface{}) {
+	segments := splitPath(path)
+	current := resource
+	for i, segment := range segments[:len(segments)-1] {
+		key, next, _ := lookup(current, segment)
+
+		if multiValued[strings.ToLower(segment)] && i == 0 {
+			list, _ := next.([]interface{})
+			element, ok := primary(list)
+			if !ok {
+				element = map[string]interface{}{"primary": true}
+				current[key] = append(list, element)
+			}
+			current = element
+			continue
+		}
+
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			current[key] = child
+		}
+		current = child
+	}
+
+	key, _, _ := lookup(current, segments[len(segments)-1])
+	current[key] = value
+}
+
+// isTrue reports whether a value is true, accepting the "True" strings some clients send
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package scim
+
+import (
+	"sort"
+	"strings"
+)
+
+// ServiceProviderConfig describes the supported SCIM features
+func ServiceProviderConfig(baseURL string, maxResults int) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":          []string{SchemaServiceProviderConfig},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]interface{}{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": maxResults},
+		"changePassword":   map[string]interface{}{"supported": false},
+		"sort":             map[string]interface{}{"supported": false},
+		"etag":             map[string]interface{}{"supported": false},
+		"authenticationSchemes": []interface{}{
+			map[string]interface{}{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer Token",
+				"description": "Authentication with a static bearer token",
+				"primary":     true,
+			},
+		},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// UserResourceType describes the User resource type
+func UserResourceType(baseURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{SchemaResourceType},
+		"id":          "User",
+		"name":        "User",
+		"endpoint":    "/Users",
+		"description": "Kratos identities",
+		"schema":      SchemaUser,
+		"meta": map[string]interface{}{
+			"resourceType": "ResourceType",
+			"location":     baseURL + "/ResourceTypes/User",
+		},
+	}
+}
+
+// UserSchema describes the User attributes supported by the mapping
+func (m *Mapper) UserSchema(baseURL string) map[string]interface{} {
+	attributes := []interface{}{
+		attribute("userName", "string", map[string]interface{}{"required": true, "uniqueness": "server"}),
+		attribute("active", "boolean", nil),
+		attribute("externalId", "string", map[string]interface{}{"caseExact": true}),
+	}
+
+	// Describe the other mapped attributes, grouping sub-attributes under their parent
+	subs := map[string][]string{}
+	var parents []string
+	for _, path := range m.paths {
+		segments := splitPath(path)
+		if strings.HasPrefix(segments[0], "urn:") || strings.EqualFold(path, "userName") {
+			continue
+		}
+		if _, ok := subs[segments[0]]; !ok {
+			parents = append(parents, segments[0])
+		}
+		if len(segments) > 1 {
+			subs[segments[0]] = append(subs[segments[0]], segments[1])
+		} else if subs[segments[0]] == nil {
+			subs[segments[0]] = []string{}
+		}
+	}
+	sort.Strings(parents)
+
+	for _, parent := range parents {
+		if len(subs[parent]) == 0 {
+			attributes = append(attributes, attribute(parent, "string", nil))
+			continue
+		}
+
+		var subAttributes []interface{}
+		for _, sub := range subs[parent] {
+			subAttributes = append(subAttributes, attribute(sub, "string", nil))
+		}
+		attributes = append(attributes, attribute(parent, "complex", map[string]interface{}{
+			"multiValued":   multiValued[strings.ToLower(parent)],
+			"subAttributes": subAttributes,
+		}))
+	}
+
+	return map[string]interface{}{
+		"schemas":     []string{SchemaSchema},
+		"id":          SchemaUser,
+		"name":        "User",
+		"description": "User account",
+		"attributes":  attributes,
+		"meta": map[string]interface{}{
+			"resourceType": "Schema",
+			"location":     baseURL + "/Schemas/" + SchemaUser,
+		},
+	}
+}
+
+// attribute describes a single schema attribute
+func attribute(name, attrType string, extra map[string]interface{}) map[string]interface{} {
+	attr := map[string]interface{}{
+		"name":        name,
+		"type":        attrType,
+		"multiValued": false,
+		"required":    false,
+		"caseExact":   false,
+		"mutability":  "readWrite",
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+	for k, v := range extra {
+		attr[k] = v
+	}
+	return attr
+}
+
+// ListResponse wraps resources in a SCIM list response
+func ListResponse(resources []interface{}, total, startIndex int) map[string]interface{} {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return map[string]interface{}{
+		"schemas":      []string{SchemaListResponse},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
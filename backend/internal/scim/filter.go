@@ -0,0 +1,346 @@
+package scim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2)
+type Filter interface {
+	// Match reports whether a resource, or an element of a multi-valued attribute, matches
+	Match(resource map[string]interface{}) bool
+}
+
+// comparison compares an attribute with a value, e.g. userName eq "bjensen"
+type comparison struct {
+	path  string
+	op    string
+	value interface{}
+}
+
+// logical combines two filters with "and" or "or"
+type logical struct {
+	op          string
+	left, right Filter
+}
+
+// negation inverts a filter
+type negation struct {
+	filter Filter
+}
+
+// Equality returns the attribute path and value of a filter of the form `path eq "value"`
+func Equality(f Filter) (string, string, bool) {
+	c, ok := f.(*comparison)
+	if !ok || c.op != "eq" {
+		return "", "", false
+	}
+	value, ok := c.value.(string)
+	return c.path, value, ok
+}
+
+// ParseFilter parses a SCIM filter
+func ParseFilter(input string) (Filter, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// token is a lexical element of a filter
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits a filter into words, quoted strings and brackets
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		ch := rune(input[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '(' || ch == ')' || ch == '[' || ch == ']':
+			tokens = append(tokens, token{text: string(ch)})
+			i++
+		case ch == '"':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(input) && input[j] != '"'; j++ {
+				if input[j] == '\\' && j+1 < len(input) {
+					j++
+				}
+				sb.WriteByte(input[j])
+			}
+			if j >= len(input) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{text: sb.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(input) && !unicode.IsSpace(rune(input[j])) && !strings.ContainsRune("()[]\"", rune(input[j])) {
+				j++
+			}
+			tokens = append(tokens, token{text: input[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser over filter tokens
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peekKeyword reports whether the next token is the given unquoted keyword
+func (p *parser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+// next returns the next token
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, fmt.Errorf("unexpected end of filter")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseFactor() (Filter, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		f, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{filter: f}, nil
+	}
+
+	if p.peekKeyword("(") {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, err := p.next(); err != nil || t.text != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return f, nil
+	}
+
+	path, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if path.quoted {
+		return nil, fmt.Errorf("expected an attribute path, got %q", path.text)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	c := &comparison{path: path.text, op: strings.ToLower(op.text)}
+
+	switch c.op {
+	case "pr":
+		return c, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op.text)
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	c.value = parseValue(value)
+	return c, nil
+}
+
+// parseValue converts a comparison value token to a string, bool, number or nil
+func parseValue(t token) interface{} {
+	if t.quoted {
+		return t.text
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return n
+	}
+	return t.text
+}
+
+// Match implements Filter
+func (l *logical) Match(resource map[string]interface{}) bool {
+	if l.op == "and" {
+		return l.left.Match(resource) && l.right.Match(resource)
+	}
+	return l.left.Match(resource) || l.right.Match(resource)
+}
+
+// Match implements Filter
+func (n *negation) Match(resource map[string]interface{}) bool {
+	return !n.filter.Match(resource)
+}
+
+// Match implements Filter. Multi-valued attributes match if any element matches.
+func (c *comparison) Match(resource map[string]interface{}) bool {
+	values := collect(resource, splitPath(c.path))
+	if c.op == "pr" {
+		for _, value := range values {
+			if value != nil && value != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if c.op == "ne" {
+		for _, value := range values {
+			if compare(value, "eq", c.value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, value := range values {
+		if compare(value, c.op, c.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// collect returns every value at a path, descending into all elements of arrays
+func collect(value interface{}, segments []string) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		var result []interface{}
+		for _, item := range list {
+			result = append(result, collect(item, segments)...)
+		}
+		return result
+	}
+
+	if len(segments) == 0 {
+		return []interface{}{value}
+	}
+
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	_, child, ok := lookup(obj, segments[0])
+	if !ok {
+		return nil
+	}
+	return collect(child, segments[1:])
+}
+
+// compare applies a comparison operator. Strings are compared case-insensitively.
+func compare(actual interface{}, op string, expected interface{}) bool {
+	switch a := actual.(type) {
+	case string:
+		e, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		a, e = strings.ToLower(a), strings.ToLower(e)
+		switch op {
+		case "eq":
+			return a == e
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case float64:
+		e, ok := expected.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return a == e
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case bool:
+		return op == "eq" && actual == expected
+	case nil:
+		return op == "eq" && expected == nil
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package scim
+
+import (
+	"testing"
+)
+
+// user is a SCIM User resource matched by the filter tests
+const user = `{
+	"userName": "bjensen",
+	"active": true,
+	"name": {"givenName": "Barbara", "familyName": "Jensen"},
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true},
+		{"value": "babs@jensen.org", "type": "home"}
+	],
+	"meta": {"resourceType": "User", "version": 3},
+	"title": "",
+	"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"employeeNumber": "701984"}
+}`
+
+func TestParseFilterMatch(t *testing.T) {
+	var resource map[string]interface{}
+	decode(t, user, &resource)
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "bjensen"`, true},
+		{`userName eq "BJensen"`, true},
+		{`USERNAME eq "bjensen"`, true},
+		{`userName eq "jsmith"`, false},
+		{`userName ne "jsmith"`, true},
+		{`userName co "jen"`, true},
+		{`userName sw "bj"`, true},
+		{`userName ew "sen"`, true},
+		{`userName gt "a"`, true},
+		{`userName lt "a"`, false},
+		{`name.familyName eq "Jensen"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber eq "701984"`, true},
+		{`emails.value eq "babs@jensen.org"`, true},
+		{`emails.value ne "babs@jensen.org"`, false},
+		{`emails.type eq "other"`, false},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`meta.version ge 3`, true},
+		{`meta.version gt 3`, false},
+		{`meta.version le 2`, false},
+		{`meta.version eq "3"`, false},
+		{`nickName eq null`, false},
+		{`title pr`, false},
+		{`name pr`, true},
+		{`nickName pr`, false},
+		{`userName eq "bjensen" and active eq true`, true},
+		{`userName eq "bjensen" and active eq false`, false},
+		{`userName eq "jsmith" or active eq true`, true},
+		{`not (active eq true)`, false},
+		{`not active eq false`, true},
+		{`userName eq "jsmith" or userName eq "bjensen" and active eq false`, false},
+		{`(userName eq "jsmith" or userName eq "bjensen") and active eq true`, true},
+		{`userName EQ "bjensen" AND active eq true`, true},
+		{`userName eq "say \"hi\""`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			if got := f.Match(resource); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "bjensen"`,
+		`"userName" eq "bjensen"`,
+		`userName eq "bjensen`,
+		`(userName eq "bjensen"`,
+		`userName eq "bjensen" active eq true`,
+		`userName eq "bjensen" and`,
+	}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			if _, err := ParseFilter(input); err == nil {
+				t.Errorf("ParseFilter(%q) succeeded, want an error", input)
+			}
+		})
+	}
+}
+
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		filter    string
+		wantPath  string
+		wantValue string
+		wantOK    bool
+	}{
+		{`userName eq "bjensen"`, "userName", "bjensen", true},
+		{`externalId eq "42"`, "externalId", "42", true},
+		{`userName co "bj"`, "", "", false},
+		{`active eq true`, "", "", false},
+		{`userName eq "a" or userName eq "b"`, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, value, ok := Equality(f)
+			if ok != tt.wantOK || ok && (path != tt.wantPath || value != tt.wantValue) {
+				t.Errorf("Equality = (%q, %q, %v), want (%q, %q, %v)", path, value, ok, tt.wantPath, tt.wantValue, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PatchOperation is a single operation of a PatchOp request
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// PatchRequest is a SCIM PatchOp request body
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// ApplyPatch applies PatchOp operations to a resource in place (RFC 7644 section 3.5.2)
+func ApplyPatch(resource map[string]interface{}, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return badRequest(ErrInvalidSyntax, fmt.Sprintf("unknown patch operation %q", operation.Op))
+		}
+
+		if operation.Path == "" {
+			if op == "remove" {
+				return badRequest(ErrNoTarget, "remove operations require a path")
+			}
+			values, ok := operation.Value.(map[string]interface{})
+			if !ok {
+				return badRequest(ErrInvalidValue, "operations without a path require an object value")
+			}
+			for path, value := range values {
+				if err := applyOperation(resource, op, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := applyOperation(resource, op, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOperation applies a single operation to a path, which may contain a value filter,
+// e.g. emails[type eq "work"].value
+func applyOperation(resource map[string]interface{}, op, path string, value interface{}) error {
+	start := strings.Index(path, "[")
+	if start < 0 {
+		return applySimple(resource, op, path, value)
+	}
+
+	end := strings.LastIndex(path, "]")
+	if end < start {
+		return badRequest(ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+	}
+	attribute, sub := path[:start], strings.TrimPrefix(path[end+1:], ".")
+	filter, err := ParseFilter(path[start+1 : end])
+	if err != nil {
+		return badRequest(ErrInvalidPath, fmt.Sprintf("invalid value filter in %q: %v", path, err))
+	}
+
+	key, current, _ := lookup(resource, attribute)
+	list, _ := current.([]interface{})
+
+	matched := false
+	kept := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		element, ok := item.(map[string]interface{})
+		if !ok || !filter.Match(element) {
+			kept = append(kept, item)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == "remove" && sub == "":
+			continue
+		case op == "remove":
+			subKey, _, _ := lookup(element, sub)
+			delete(element, subKey)
+		case sub == "":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return badRequest(ErrInvalidValue, fmt.Sprintf("%s requires an object value", path))
+			}
+			if op == "replace" {
+				for k := range element {
+					delete(element, k)
+				}
+			}
+			for k, v := range object {
+				element[k] = v
+			}
+		default:
+			subKey, _, _ := lookup(element, sub)
+			element[subKey] = value
+		}
+		kept = append(kept, element)
+	}
+
+	if !matched && op != "remove" {
+		// Create the element described by a simple equality filter, e.g. a missing work email
+		filterPath, filterValue, ok := Equality(filter)
+		if !ok || sub == "" {
+			return badRequest(ErrNoTarget, fmt.Sprintf("no value matches %q", path))
+		}
+		kept = append(kept, map[string]interface{}{filterPath: filterValue, sub: value})
+	}
+
+	resource[key] = kept
+	return nil
+}
+
+// applySimple applies an operation to an attribute path without a value filter
+func applySimple(resource map[string]interface{}, op, path string, value interface{}) error {
+	if op == "remove" {
+		removePath(resource, splitPath(path))
+		return nil
+	}
+
+	if op == "add" {
+		// Adding to a multi-valued attribute appends the new values
+		if existing, ok := getPath(resource, path); ok {
+			list, isList := existing.([]interface{})
+			added, addIsList := value.([]interface{})
+			if isList && addIsList {
+				setPath(resource, path, append(list, added...))
+				return nil
+			}
+		}
+	}
+
+	setPath(resource, path, value)
+	return nil
+}
+
+// removePath deletes the attribute at the given segments
+func removePath(obj map[string]interface{}, segments []string) {
+	key, value, ok := lookup(obj, segments[0])
+	if !ok {
+		return
+	}
+	if len(segments) == 1 {
+		delete(obj, key)
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		removePath(v, segments[1:])
+	case []interface{}:
+		for _, item := range v {
+			if element, ok := item.(map[string]interface{}); ok {
+				removePath(element, segments[1:])
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		resource   string
+		operations string
+		want       string
+	}{
+		{
+			name:       "replace attribute",
+			resource:   `{"userName":"bjensen","active":true}`,
+			operations: `[{"op":"replace","path":"active","value":false}]`,
+			want:       `{"userName":"bjensen","active":false}`,
+		},
+		{
+			name:       "operation names are case-insensitive",
+			resource:   `{"active":true}`,
+			operations: `[{"op":"Replace","path":"active","value":false}]`,
+			want:       `{"active":false}`,
+		},
+		{
+			name:       "attribute names are case-insensitive",
+			resource:   `{"displayName":"Babs"}`,
+			operations: `[{"op":"replace","path":"displayname","value":"Barbara"}]`,
+			want:       `{"displayName":"Barbara"}`,
+		},
+		{
+			name:       "replace sub-attribute",
+			resource:   `{"name":{"givenName":"Barbara","familyName":"Jensen"}}`,
+			operations: `[{"op":"replace","path":"name.familyName","value":"Smith"}]`,
+			want:       `{"name":{"givenName":"Barbara","familyName":"Smith"}}`,
+		},
+		{
+			name:       "add creates intermediate objects",
+			resource:   `{}`,
+			operations: `[{"op":"add","path":"name.givenName","value":"Barbara"}]`,
+			want:       `{"name":{"givenName":"Barbara"}}`,
+		},
+		{
+			name:       "core schema URN prefix",
+			resource:   `{"userName":"bjensen"}`,
+			operations: `[{"op":"replace","path":"urn:ietf:params:scim:schemas:core:2.0:User:userName","value":"barbara"}]`,
+			want:       `{"userName":"barbara"}`,
+		},
+		{
+			name:       "extension attribute",
+			resource:   `{}`,
+			operations: `[{"op":"add","path":"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber","value":"701984"}]`,
+			want:       `{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User":{"employeeNumber":"701984"}}`,
+		},
+		{
+			name:       "add appends to multi-valued attribute",
+			resource:   `{"emails":[{"value":"a@example.com"}]}`,
+			operations: `[{"op":"add","path":"emails","value":[{"value":"b@example.com"}]}]`,
+			want:       `{"emails":[{"value":"a@example.com"},{"value":"b@example.com"}]}`,
+		},
+		{
+			name:       "replace overwrites multi-valued attribute",
+			resource:   `{"emails":[{"value":"a@example.com"}]}`,
+			operations: `[{"op":"replace","path":"emails","value":[{"value":"b@example.com"}]}]`,
+			want:       `{"emails":[{"value":"b@example.com"}]}`,
+		},
+		{
+			name:       "sub-attribute of multi-valued attribute writes the primary element",
+			resource:   `{"emails":[{"value":"a@example.com"},{"value":"b@example.com","primary":true}]}`,
+			operations: `[{"op":"replace","path":"emails.value","value":"c@example.com"}]`,
+			want:       `{"emails":[{"value":"a@example.com"},{"value":"c@example.com","primary":true}]}`,
+		},
+		{
+			name:       "sub-attribute of missing multi-valued attribute creates a primary element",
+			resource:   `{}`,
+			operations: `[{"op":"add","path":"emails.value","value":"a@example.com"}]`,
+			want:       `{"emails":[{"primary":true,"value":"a@example.com"}]}`,
+		},
+		{
+			name:       "no path merges the value",
+			resource:   `{"userName":"bjensen","active":true}`,
+			operations: `[{"op":"replace","value":{"active":false,"name.givenName":"Barbara"}}]`,
+			want:       `{"userName":"bjensen","active":false,"name":{"givenName":"Barbara"}}`,
+		},
+		{
+			name:       "remove attribute",
+			resource:   `{"userName":"bjensen","title":"Tour Guide"}`,
+			operations: `[{"op":"remove","path":"title"}]`,
+			want:       `{"userName":"bjensen"}`,
+		},
+		{
+			name:       "remove missing attribute",
+			resource:   `{"userName":"bjensen"}`,
+			operations: `[{"op":"remove","path":"name.givenName"}]`,
+			want:       `{"userName":"bjensen"}`,
+		},
+		{
+			name:       "remove sub-attribute of every element",
+			resource:   `{"emails":[{"value":"a@example.com","display":"A"},{"value":"b@example.com","display":"B"}]}`,
+			operations: `[{"op":"remove","path":"emails.display"}]`,
+			want:       `{"emails":[{"value":"a@example.com"},{"value":"b@example.com"}]}`,
+		},
+		{
+			name:       "value filter replaces sub-attribute",
+			resource:   `{"emails":[{"value":"a@example.com","type":"work"},{"value":"b@example.com","type":"home"}]}`,
+			operations: `[{"op":"replace","path":"emails[type eq \"work\"].value","value":"c@example.com"}]`,
+			want:       `{"emails":[{"value":"c@example.com","type":"work"},{"value":"b@example.com","type":"home"}]}`,
+		},
+		{
+			name:       "value filter replaces element",
+			resource:   `{"emails":[{"value":"a@example.com","type":"work","display":"A"}]}`,
+			operations: `[{"op":"replace","path":"emails[type eq \"work\"]","value":{"value":"c@example.com","type":"work"}}]`,
+			want:       `{"emails":[{"value":"c@example.com","type":"work"}]}`,
+		},
+		{
+			name:       "value filter adds to element",
+			resource:   `{"emails":[{"value":"a@example.com","type":"work"}]}`,
+			operations: `[{"op":"add","path":"emails[type eq \"work\"]","value":{"primary":true}}]`,
+			want:       `{"emails":[{"value":"a@example.com","type":"work","primary":true}]}`,
+		},
+		{
+			name:       "value filter removes elements",
+			resource:   `{"emails":[{"value":"a@example.com","type":"work"},{"value":"b@example.com","type":"home"}]}`,
+			operations: `[{"op":"remove","path":"emails[type eq \"work\"]"}]`,
+			want:       `{"emails":[{"value":"b@example.com","type":"home"}]}`,
+		},
+		{
+			name:       "value filter removes sub-attribute",
+			resource:   `{"emails":[{"value":"a@example.com","type":"work","primary":true}]}`,
+			operations: `[{"op":"remove","path":"emails[type eq \"work\"].primary"}]`,
+			want:       `{"emails":[{"value":"a@example.com","type":"work"}]}`,
+		},
+		{
+			name:       "value filter creates missing element",
+			resource:   `{"emails":[{"value":"b@example.com","type":"home"}]}`,
+			operations: `[{"op":"add","path":"emails[type eq \"work\"].value","value":"a@example.com"}]`,
+			want:       `{"emails":[{"value":"b@example.com","type":"home"},{"type":"work","value":"a@example.com"}]}`,
+		},
+		{
+			name:     "operations apply in order",
+			resource: `{"active":true}`,
+			operations: `[
+				{"op":"replace","path":"active","value":false},
+				{"op":"add","path":"title","value":"Tour Guide"},
+				{"op":"remove","path":"title"}
+			]`,
+			want: `{"active":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resource, want map[string]interface{}
+			var operations []PatchOperation
+			decode(t, tt.resource, &resource)
+			decode(t, tt.operations, &operations)
+			decode(t, tt.want, &want)
+
+			if err := ApplyPatch(resource, operations); err != nil {
+				t.Fatalf("ApplyPatch: %v", err)
+			}
+			if !reflect.DeepEqual(resource, want) {
+				got, _ := json.Marshal(resource)
+				t.Errorf("resource = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations string
+		wantType   string
+	}{
+		{"unknown operation", `[{"op":"move","path":"active","value":false}]`, ErrInvalidSyntax},
+		{"remove without path", `[{"op":"remove"}]`, ErrNoTarget},
+		{"no path without object", `[{"op":"replace","value":false}]`, ErrInvalidValue},
+		{"unclosed value filter", `[{"op":"replace","path":"emails[type eq \"work\".value","value":"a"}]`, ErrInvalidPath},
+		{"invalid value filter", `[{"op":"replace","path":"emails[type xx \"work\"].value","value":"a"}]`, ErrInvalidPath},
+		{"element without object", `[{"op":"replace","path":"emails[type eq \"home\"]","value":"a"}]`, ErrInvalidValue},
+		{"no match without equality", `[{"op":"replace","path":"emails[type co \"other\"].value","value":"a"}]`, ErrNoTarget},
+		{"no match without sub-attribute", `[{"op":"add","path":"emails[type eq \"other\"]","value":{"value":"a"}}]`, ErrNoTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := map[string]interface{}{
+				"emails": []interface{}{map[string]interface{}{"value": "b@example.com", "type": "home"}},
+			}
+			var operations []PatchOperation
+			decode(t, tt.operations, &operations)
+
+			err := ApplyPatch(resource, operations)
+			var scimErr *Error
+			if !errors.As(err, &scimErr) {
+				t.Fatalf("ApplyPatch error = %v, want a SCIM error", err)
+			}
+			if scimErr.ScimType != tt.wantType {
+				t.Errorf("scimType = %q, want %q (%s)", scimErr.ScimType, tt.wantType, scimErr.Detail)
+			}
+		})
+	}
+}
+
+// decode parses a JSON literal of a test case
+func decode(t *testing.T, raw string, target interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(raw), target); err != nil {
+		t.Fatalf("invalid JSON %s: %v", raw, err)
+	}
+}
@@ -0,0 +1,74 @@
+package scim
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIM schema URNs
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// SCIM error types, sent as scimType
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidValue  = "invalidValue"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrUniqueness    = "uniqueness"
+	ErrNoTarget      = "noTarget"
+)
+
+// Error is a SCIM error with its HTTP status
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// badRequest creates a 400 error of the given SCIM type
+func badRequest(scimType, detail string) *Error {
+	return &Error{Status: http.StatusBadRequest, ScimType: scimType, Detail: detail}
+}
+
+// WriteError writes a SCIM error response
+func WriteError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{SchemaError},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	Write(c, status, body)
+}
+
+// Write writes a SCIM response with the SCIM content type
+func Write(c *gin.Context, status int, body interface{}) {
+	// gin keeps an explicitly set Content-Type
+	c.Header("Content-Type", ContentType)
+	c.JSON(status, body)
+}
+
+// Unauthorized writes the SCIM error for requests without a valid bearer token
+func Unauthorized(c *gin.Context) {
+	WriteError(c, http.StatusUnauthorized, "", "Invalid or missing bearer token")
+}
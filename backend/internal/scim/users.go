@@ -0,0 +1,187 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// externalIDKey is the admin metadata key storing the SCIM externalId of an identity
+const externalIDKey = "scim_external_id"
+
+// DefaultMapping maps SCIM User attributes to the traits of the Kratos preset identity schema
+var DefaultMapping = map[string]string{
+	"userName":        "/email",
+	"emails.value":    "/email",
+	"name.givenName":  "/name/first",
+	"name.familyName": "/name/last",
+}
+
+// Mapper converts between Kratos identities and SCIM User resources
+type Mapper struct {
+	schemaID string
+	mapping  map[string]string
+	paths    []string
+}
+
+// NewMapper creates a mapper for identities of schemaID.
+// mapping maps SCIM attribute paths to JSON pointers into the traits and must include userName.
+func NewMapper(schemaID string, mapping map[string]string) (*Mapper, error) {
+	m := &Mapper{schemaID: schemaID, mapping: map[string]string{}}
+	for path, pointer := range mapping {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("trait pointer %q of SCIM attribute %q must start with /", pointer, path)
+		}
+		m.mapping[path] = pointer
+		m.paths = append(m.paths, path)
+	}
+	sort.Strings(m.paths)
+
+	if m.Pointer("userName") == "" {
+		return nil, fmt.Errorf("the SCIM attribute mapping must include userName")
+	}
+	return m, nil
+}
+
+// SchemaID returns the identity schema of SCIM users
+func (m *Mapper) SchemaID() string {
+	return m.schemaID
+}
+
+// Pointer returns the trait pointer mapped to a SCIM attribute path, or an empty string
+func (m *Mapper) Pointer(path string) string {
+	for _, candidate := range m.paths {
+		if strings.EqualFold(candidate, path) {
+			return m.mapping[candidate]
+		}
+	}
+	return ""
+}
+
+// ToResource converts an identity to a SCIM User resource
+func (m *Mapper) ToResource(identity *ory.Identity, location string) map[string]interface{} {
+	resource := map[string]interface{}{
+		"schemas": []interface{}{SchemaUser},
+		"id":      identity.Id,
+		"active":  identity.State == nil || *identity.State == ory.IDENTITYSTATE_ACTIVE,
+	}
+
+	traits, _ := identity.Traits.(map[string]interface{})
+	for _, path := range m.paths {
+		if value, ok := schema.GetPointer(traits, m.mapping[path]); ok {
+			setPath(resource, path, value)
+		}
+	}
+	for key := range resource {
+		if strings.HasPrefix(key, "urn:") {
+			resource["schemas"] = append(resource["schemas"].([]interface{}), key)
+		}
+	}
+
+	if metadata, ok := identity.MetadataAdmin.(map[string]interface{}); ok {
+		if externalID, ok := metadata[externalIDKey].(string); ok {
+			resource["externalId"] = externalID
+		}
+	}
+
+	meta := map[string]interface{}{
+		"resourceType": "User",
+		"location":     location,
+	}
+	if identity.CreatedAt != nil {
+		meta["created"] = identity.CreatedAt.Format(time.RFC3339)
+	}
+	if identity.UpdatedAt != nil {
+		meta["lastModified"] = identity.UpdatedAt.Format(time.RFC3339)
+		meta["version"] = fmt.Sprintf(`W/"%d"`, identity.UpdatedAt.UnixNano())
+	}
+	resource["meta"] = meta
+
+	return resource
+}
+
+// UserName returns the userName attribute of a resource
+func UserName(resource map[string]interface{}) string {
+	_, value, _ := lookup(resource, "userName")
+	userName, _ := value.(string)
+	return userName
+}
+
+// Update holds the identity fields derived from a SCIM User resource
+type Update struct {
+	Traits        map[string]interface{}
+	State         ory.IdentityState
+	MetadataAdmin map[string]interface{}
+}
+
+// FromResource applies a SCIM User resource on top of an identity, which may be nil for new users.
+// Mapped traits missing from the resource are removed, other traits and metadata are kept.
+func (m *Mapper) FromResource(resource map[string]interface{}, identity *ory.Identity) (*Update, error) {
+	update := &Update{
+		Traits:        map[string]interface{}{},
+		State:         ory.IDENTITYSTATE_ACTIVE,
+		MetadataAdmin: map[string]interface{}{},
+	}
+	if identity != nil {
+		if err := copyJSON(identity.Traits, &update.Traits); err != nil {
+			return nil, err
+		}
+		if err := copyJSON(identity.MetadataAdmin, &update.MetadataAdmin); err != nil {
+			return nil, err
+		}
+		if identity.State != nil {
+			update.State = *identity.State
+		}
+	}
+
+	// Remove first so that a missing attribute does not clear a trait set through another one
+	values := map[string]interface{}{}
+	for _, path := range m.paths {
+		if value, ok := getPath(resource, path); ok {
+			values[path] = value
+			continue
+		}
+		schema.DeletePointer(update.Traits, m.mapping[path])
+	}
+	for _, path := range m.paths {
+		value, ok := values[path]
+		if !ok {
+			continue
+		}
+		if err := schema.SetPointer(update.Traits, m.mapping[path], value); err != nil {
+			return nil, badRequest(ErrInvalidValue, err.Error())
+		}
+	}
+
+	if _, active, ok := lookup(resource, "active"); ok && active != nil {
+		update.State = ory.IDENTITYSTATE_INACTIVE
+		if isTrue(active) {
+			update.State = ory.IDENTITYSTATE_ACTIVE
+		}
+	}
+
+	if _, externalID, ok := lookup(resource, "externalId"); ok && externalID != nil {
+		update.MetadataAdmin[externalIDKey] = externalID
+	} else {
+		delete(update.MetadataAdmin, externalIDKey)
+	}
+
+	return update, nil
+}
+
+// copyJSON copies a JSON-compatible value into dst through a JSON round trip
+func copyJSON(src interface{}, dst interface{}) error {
+	if src == nil {
+		return nil
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+)
+
+// DashboardCache serves the dashboard counts from the latest snapshot of the stats collector,
+// and takes a new snapshot on read only when that one is older than the TTL.
+// Reads never wait for Kratos once a first snapshot exists: stale values are served while a
+// refresh runs (stale-while-revalidate).
+type DashboardCache struct {
+	client  *kratos.Client
+	history *History
+	ttl     time.Duration
+
+	mu         sync.Mutex
+	snapshot   *Snapshot
+	refreshing bool
+
+	// refreshMu serializes refreshes so concurrent cold reads share a single scan
+	refreshMu sync.Mutex
+}
+
+// NewDashboardCache creates a new dashboard cache reusing the snapshots collected into history
+func NewDashboardCache(client *kratos.Client, history *History, ttl time.Duration) *DashboardCache {
+	return &DashboardCache{client: client, history: history, ttl: ttl}
+}
+
+// Get returns the cached counts. The first call blocks until counts are available,
+// later calls return immediately and trigger a background refresh if the counts are stale.
+func (c *DashboardCache) Get(ctx context.Context) (Snapshot, error) {
+	snapshot := c.latest()
+	if snapshot == nil {
+		if err := c.refresh(ctx); err != nil {
+			return Snapshot{}, err
+		}
+		return *c.latest(), nil
+	}
+
+	if time.Since(snapshot.Timestamp) > c.ttl {
+		c.mu.Lock()
+		if !c.refreshing {
+			c.refreshing = true
+			go func() {
+				if err := c.refresh(context.Background()); err != nil {
+					log.Printf("Failed to refresh dashboard stats: %v", err)
+				}
+			}()
+		}
+		c.mu.Unlock()
+	}
+
+	return *snapshot, nil
+}
+
+// latest returns the most recent of the cached and collected snapshots, or nil
+func (c *DashboardCache) latest() *Snapshot {
+	c.mu.Lock()
+	snapshot := c.snapshot
+	c.mu.Unlock()
+
+	if collected, ok := c.history.Latest(); ok && (snapshot == nil || collected.Timestamp.After(snapshot.Timestamp)) {
+		return &collected
+	}
+	return snapshot
+}
+
+// refresh takes a snapshot unless another refresh or the collector produced fresh counts meanwhile
+func (c *DashboardCache) refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.refreshing = false
+		c.mu.Unlock()
+	}()
+
+	current := c.latest()
+	if current != nil && time.Since(current.Timestamp) < c.ttl/2 {
+		return nil
+	}
+
+	since := time.Now().Add(-c.ttl)
+	if current != nil {
+		since = current.Timestamp
+	}
+
+	snapshot, err := TakeSnapshot(ctx, c.client, since)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.snapshot = &snapshot
+	c.mu.Unlock()
+	return nil
+}
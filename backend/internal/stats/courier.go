@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// dispatchFailed is the status of a failed delivery attempt
+const dispatchFailed = "failed"
+
+// errWindowEnd stops paging once messages are older than the requested window
+var errWindowEnd = errors.New("end of window")
+
+// CourierStats summarizes courier message delivery over a time window
+type CourierStats struct {
+	From              time.Time           `json:"from"`
+	To                time.Time           `json:"to"`
+	Total             int64               `json:"total"`
+	ByStatus          map[string]int64    `json:"by_status"`
+	ByTemplateType    map[string]int64    `json:"by_template_type"`
+	ByChannel         map[string]int64    `json:"by_channel"`
+	Dispatches        int64               `json:"dispatches"`
+	FailedDispatches  int64               `json:"failed_dispatches"`
+	FailureRatio      float64             `json:"failure_ratio"`
+	FailingRecipients []RecipientFailures `json:"failing_recipients"`
+}
+
+// RecipientFailures describes a recipient whose messages repeatedly failed to deliver
+type RecipientFailures struct {
+	Recipient        string    `json:"recipient"`
+	Messages         int64     `json:"messages"`
+	FailedDispatches int64     `json:"failed_dispatches"`
+	Abandoned        int64     `json:"abandoned"`
+	LastError        string    `json:"last_error,omitempty"`
+	LastFailureAt    time.Time `json:"last_failure_at"`
+}
+
+// ComputeCourierStats aggregates the courier messages created between from and to.
+// Recipients with at least minFailures failed dispatches or abandoned messages are listed, most failures first.
+func ComputeCourierStats(ctx context.Context, client *kratos.Client, from, to time.Time, minFailures int64) (*CourierStats, error) {
+	result := &CourierStats{
+		From:              from,
+		To:                to,
+		ByStatus:          map[string]int64{},
+		ByTemplateType:    map[string]int64{},
+		ByChannel:         map[string]int64{},
+		FailingRecipients: []RecipientFailures{},
+	}
+	for _, status := range ory.AllowedCourierMessageStatusEnumValues {
+		result.ByStatus[string(status)] = 0
+	}
+
+	recipients := map[string]*RecipientFailures{}
+	err := client.ForEachCourierMessagePage(ctx, func(messages []ory.Message) error {
+		for _, message := range messages {
+			// Kratos returns the newest messages first
+			if message.CreatedAt.Before(from) {
+				return errWindowEnd
+			}
+			if message.CreatedAt.After(to) {
+				continue
+			}
+
+			result.Total++
+			result.ByStatus[string(message.Status)]++
+			result.ByTemplateType[message.TemplateType]++
+			result.ByChannel[string(message.Type)]++
+
+			r, ok := recipients[message.Recipient]
+			if !ok {
+				r = &RecipientFailures{Recipient: message.Recipient}
+				recipients[message.Recipient] = r
+			}
+			r.Messages++
+			if message.Status == ory.COURIERMESSAGESTATUS_ABANDONED {
+				r.Abandoned++
+				if message.UpdatedAt.After(r.LastFailureAt) {
+					r.LastFailureAt = message.UpdatedAt
+				}
+			}
+
+			for _, dispatch := range message.Dispatches {
+				result.Dispatches++
+				if dispatch.Status != dispatchFailed {
+					continue
+				}
+				result.FailedDispatches++
+				r.FailedDispatches++
+				if !dispatch.CreatedAt.Before(r.LastFailureAt) {
+					r.LastFailureAt = dispatch.CreatedAt
+					r.LastError = dispatchError(dispatch)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errWindowEnd) {
+		return nil, err
+	}
+
+	if result.Dispatches > 0 {
+		result.FailureRatio = float64(result.FailedDispatches) / float64(result.Dispatches)
+	}
+
+	for _, r := range recipients {
+		if r.FailedDispatches+r.Abandoned >= minFailures {
+			result.FailingRecipients = append(result.FailingRecipients, *r)
+		}
+	}
+	sort.Slice(result.FailingRecipients, func(i, j int) bool {
+		a, b := result.FailingRecipients[i], result.FailingRecipients[j]
+		if a.FailedDispatches+a.Abandoned != b.FailedDispatches+b.Abandoned {
+			return a.FailedDispatches+a.Abandoned > b.FailedDispatches+b.Abandoned
+		}
+		return a.Recipient < b.Recipient
+	})
+
+	return result, nil
+}
+
+// dispatchError extracts a readable message from a dispatch error
+func dispatchError(dispatch ory.MessageDispatch) string {
+	if message, ok := dispatch.Error["message"].(string); ok {
+		return message
+	}
+	if reason, ok := dispatch.Error["reason"].(string); ok {
+		return reason
+	}
+	return ""
+}
@@ -0,0 +1,239 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// snapshotsLog is the store log holding stats snapshots
+const snapshotsLog = "stats_snapshots.jsonl"
+
+// Snapshot is a point-in-time count of identities and sessions
+type Snapshot struct {
+	Timestamp          time.Time `json:"timestamp"`
+	TotalIdentities    int64     `json:"total_identities"`
+	ActiveIdentities   int64     `json:"active_identities"`
+	InactiveIdentities int64     `json:"inactive_identities"`
+	ActiveSessions     int64     `json:"active_sessions"`
+	// NewIdentities counts identities created since the previous snapshot
+	NewIdentities int64 `json:"new_identities"`
+}
+
+// Point is a single value in a time series
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     int64     `json:"value"`
+}
+
+// Series names returned by History.Series
+const (
+	SeriesTotalIdentities    = "total_identities"
+	SeriesActiveIdentities   = "active_identities"
+	SeriesInactiveIdentities = "inactive_identities"
+	SeriesActiveSessions     = "active_sessions"
+	SeriesSignups            = "signups"
+)
+
+// History keeps snapshots in memory and persists them in the local store
+type History struct {
+	store     *store.Store
+	retention time.Duration
+
+	mu        sync.RWMutex
+	snapshots []Snapshot
+}
+
+// NewHistory loads previously stored snapshots
+func NewHistory(st *store.Store, retention time.Duration) (*History, error) {
+	h := &History{store: st, retention: retention}
+
+	err := st.ReadLines(snapshotsLog, func(line []byte) error {
+		var s Snapshot
+		if err := json.Unmarshal(line, &s); err != nil {
+			// Skip entries that were only partially written
+			return nil
+		}
+		h.snapshots = append(h.snapshots, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Add records a snapshot and drops those older than the retention period
+func (h *History) Add(s Snapshot) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots = append(h.snapshots, s)
+
+	cutoff := s.Timestamp.Add(-h.retention)
+	keep := 0
+	for keep < len(h.snapshots) && h.snapshots[keep].Timestamp.Before(cutoff) {
+		keep++
+	}
+	if keep == 0 {
+		return h.store.Append(snapshotsLog, s)
+	}
+
+	h.snapshots = append([]Snapshot(nil), h.snapshots[keep:]...)
+	entries := make([]interface{}, len(h.snapshots))
+	for i, snapshot := range h.snapshots {
+		entries[i] = snapshot
+	}
+	return h.store.Rewrite(snapshotsLog, entries)
+}
+
+// Latest returns the most recent snapshot
+func (h *History) Latest() (Snapshot, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.snapshots) == 0 {
+		return Snapshot{}, false
+	}
+	return h.snapshots[len(h.snapshots)-1], true
+}
+
+// Series buckets the snapshots between from and to by interval.
+// Gauges use the last snapshot in each bucket, signups are summed. Empty buckets are omitted.
+func (h *History) Series(from, to time.Time, interval time.Duration) map[string][]Point {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	series := map[string][]Point{
+		SeriesTotalIdentities:    {},
+		SeriesActiveIdentities:   {},
+		SeriesInactiveIdentities: {},
+		SeriesActiveSessions:     {},
+		SeriesSignups:            {},
+	}
+
+	var (
+		bucketStart time.Time
+		last        *Snapshot
+		signups     int64
+	)
+	flush := func() {
+		if last == nil {
+			return
+		}
+		series[SeriesTotalIdentities] = append(series[SeriesTotalIdentities], Point{bucketStart, last.TotalIdentities})
+		series[SeriesActiveIdentities] = append(series[SeriesActiveIdentities], Point{bucketStart, last.ActiveIdentities})
+		series[SeriesInactiveIdentities] = append(series[SeriesInactiveIdentities], Point{bucketStart, last.InactiveIdentities})
+		series[SeriesActiveSessions] = append(series[SeriesActiveSessions], Point{bucketStart, last.ActiveSessions})
+		series[SeriesSignups] = append(series[SeriesSignups], Point{bucketStart, signups})
+		last = nil
+		signups = 0
+	}
+
+	for i := range h.snapshots {
+		s := &h.snapshots[i]
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+
+		start := from.Add(s.Timestamp.Sub(from) / interval * interval)
+		if !start.Equal(bucketStart) {
+			flush()
+			bucketStart = start
+		}
+		last = s
+		signups += s.NewIdentities
+	}
+	flush()
+
+	return series
+}
+
+// Collector periodically snapshots identity and session counts into a history
+type Collector struct {
+	client   *kratos.Client
+	history  *History
+	interval time.Duration
+}
+
+// NewCollector creates a new snapshot collector
+func NewCollector(client *kratos.Client, history *History, interval time.Duration) *Collector {
+	return &Collector{client: client, history: history, interval: interval}
+}
+
+// Run collects a snapshot immediately and then on every interval until ctx is cancelled
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Collect(ctx); err != nil {
+			log.Printf("Failed to collect stats snapshot: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect takes a single snapshot and records it
+func (c *Collector) Collect(ctx context.Context) (Snapshot, error) {
+	since := time.Now().Add(-c.interval)
+	if previous, ok := c.history.Latest(); ok {
+		since = previous.Timestamp
+	}
+
+	snapshot, err := TakeSnapshot(ctx, c.client, since)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := c.history.Add(snapshot); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// TakeSnapshot counts identities and active sessions.
+// NewIdentities counts identities created after since.
+func TakeSnapshot(ctx context.Context, client *kratos.Client, since time.Time) (Snapshot, error) {
+	snapshot := Snapshot{Timestamp: time.Now()}
+	err := client.ForEachIdentityPage(ctx, func(identities []ory.Identity) error {
+		for _, identity := range identities {
+			snapshot.TotalIdentities++
+			if identity.State != nil && *identity.State == ory.IDENTITYSTATE_ACTIVE {
+				snapshot.ActiveIdentities++
+			} else {
+				snapshot.InactiveIdentities++
+			}
+			if identity.CreatedAt != nil && identity.CreatedAt.After(since) {
+				snapshot.NewIdentities++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	active := true
+	err = client.ForEachSessionPage(ctx, &active, nil, func(sessions []ory.Session) error {
+		snapshot.ActiveSessions += int64(len(sessions))
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return snapshot, nil
+}
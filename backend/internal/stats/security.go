@@ -0,0 +1,254 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// credentialFetchConcurrency limits parallel GetIdentityWithCredentials calls
+const credentialFetchConcurrency = 10
+
+// Security report categories, used as keys of SecurityReport.Counts and SecurityReport.Identities
+const (
+	CategoryTOTP            = "totp"
+	CategoryWebAuthn        = "webauthn"
+	CategoryLookupSecret    = "lookup_secret"
+	CategoryMFA             = "mfa"
+	CategoryNoMFA           = "no_mfa"
+	CategoryPasswordOnly    = "password_only"
+	CategoryOIDCOnly        = "oidc_only"
+	CategoryUnverifiedEmail = "unverified_email"
+)
+
+// SecurityReport summarizes MFA adoption and address verification across all identities
+type SecurityReport struct {
+	TotalIdentities int64               `json:"total_identities"`
+	Counts          map[string]int64    `json:"counts"`
+	Ratios          map[string]float64  `json:"ratios"`
+	Addresses       AddressStats        `json:"addresses"`
+	Identities      map[string][]string `json:"identities"`
+	ComputedAt      time.Time           `json:"computed_at"`
+}
+
+// AddressStats counts verifiable addresses by verification status
+type AddressStats struct {
+	Total         int64   `json:"total"`
+	Verified      int64   `json:"verified"`
+	Unverified    int64   `json:"unverified"`
+	VerifiedRatio float64 `json:"verified_ratio"`
+}
+
+// Truncate returns a copy of the report with at most limit identity IDs per category
+func (r *SecurityReport) Truncate(limit int) *SecurityReport {
+	copied := *r
+	copied.Identities = make(map[string][]string, len(r.Identities))
+	for category, ids := range r.Identities {
+		if len(ids) > limit {
+			ids = ids[:limit]
+		}
+		copied.Identities[category] = ids
+	}
+	return &copied
+}
+
+// ComputeSecurityReport loads every identity with its credentials and classifies it. Identities
+// deleted while the report is computed are left out.
+func ComputeSecurityReport(ctx context.Context, client *kratos.Client) (*SecurityReport, error) {
+	var ids []string
+	err := client.ForEachIdentityPage(ctx, func(identities []ory.Identity) error {
+		for _, identity := range identities {
+			ids = append(ids, identity.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SecurityReport{
+		TotalIdentities: int64(len(ids)),
+		Counts:          map[string]int64{},
+		Ratios:          map[string]float64{},
+		Identities:      map[string][]string{},
+		ComputedAt:      time.Now(),
+	}
+	for _, category := range []string{CategoryTOTP, CategoryWebAuthn, CategoryLookupSecret, CategoryMFA, CategoryNoMFA, CategoryPasswordOnly, CategoryOIDCOnly, CategoryUnverifiedEmail} {
+		report.Counts[category] = 0
+		report.Identities[category] = []string{}
+	}
+
+	// The first failure cancels the fetches still running and stops launching new ones
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		sem      = make(chan struct{}, credentialFetchConcurrency)
+	)
+launch:
+	for _, id := range ids {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break launch
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			identity, err := client.GetIdentityWithCredentials(ctx, id)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case kratos.StatusCode(err) == http.StatusNotFound:
+				report.TotalIdentities--
+			case err != nil:
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+			default:
+				report.classify(identity)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		// The caller's context was cancelled before every identity was fetched
+		firstErr = ctx.Err()
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	for category, list := range report.Identities {
+		sort.Strings(list)
+		report.Counts[category] = int64(len(list))
+		if report.TotalIdentities > 0 {
+			report.Ratios[category] = float64(len(list)) / float64(report.TotalIdentities)
+		}
+	}
+	if report.Addresses.Total > 0 {
+		report.Addresses.VerifiedRatio = float64(report.Addresses.Verified) / float64(report.Addresses.Total)
+	}
+
+	return report, nil
+}
+
+// classify adds an identity to the categories it belongs to
+func (r *SecurityReport) classify(identity *ory.Identity) {
+	add := func(category string) {
+		r.Identities[category] = append(r.Identities[category], identity.Id)
+	}
+
+	has := func(credType string) bool {
+		return HasCredential(identity, credType)
+	}
+
+	mfa := false
+	for _, credType := range []string{CategoryTOTP, CategoryWebAuthn, CategoryLookupSecret} {
+		if has(credType) {
+			add(credType)
+			mfa = true
+		}
+	}
+	if mfa {
+		add(CategoryMFA)
+	} else {
+		add(CategoryNoMFA)
+	}
+
+	password, oidc := has("password"), has("oidc")
+	if password && !oidc && !mfa {
+		add(CategoryPasswordOnly)
+	}
+	if oidc && !password && !mfa {
+		add(CategoryOIDCOnly)
+	}
+
+	unverifiedEmail := false
+	for _, address := range identity.VerifiableAddresses {
+		r.Addresses.Total++
+		if address.Verified {
+			r.Addresses.Verified++
+		} else {
+			r.Addresses.Unverified++
+			if address.Via == "email" {
+				unverifiedEmail = true
+			}
+		}
+	}
+	if unverifiedEmail {
+		add(CategoryUnverifiedEmail)
+	}
+}
+
+// HasCredential reports whether an identity has a usable credential of the given type.
+// Kratos may keep empty credential entries, e.g. after all WebAuthn keys were removed.
+func HasCredential(identity *ory.Identity, credType string) bool {
+	if identity.Credentials == nil {
+		return false
+	}
+
+	cred, ok := (*identity.Credentials)[credType]
+	if !ok {
+		return false
+	}
+
+	switch credType {
+	case "webauthn":
+		if list, ok := cred.Config["credentials"].([]interface{}); ok {
+			return len(list) > 0
+		}
+	case "lookup_secret":
+		if codes, ok := cred.Config["recovery_codes"].([]interface{}); ok {
+			return len(codes) > 0
+		}
+	}
+
+	return len(cred.Identifiers) > 0 || len(cred.Config) > 0
+}
+
+// SecurityCache caches the security report for a fixed duration
+type SecurityCache struct {
+	client *kratos.Client
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	report *SecurityReport
+}
+
+// NewSecurityCache creates a new security report cache
+func NewSecurityCache(client *kratos.Client, ttl time.Duration) *SecurityCache {
+	return &SecurityCache{client: client, ttl: ttl}
+}
+
+// Get returns the cached report, computing it if it is missing or expired
+func (c *SecurityCache) Get(ctx context.Context) (*SecurityReport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.report != nil && time.Since(c.report.ComputedAt) < c.ttl {
+		return c.report, nil
+	}
+
+	report, err := ComputeSecurityReport(ctx, c.client)
+	if err != nil {
+		return nil, err
+	}
+
+	c.report = report
+	return report, nil
+}
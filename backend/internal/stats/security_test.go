@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+)
+
+// newSecurityClient returns a client for a Kratos admin API listing count identities, where
+// fetching identity b answers status right away and the others, after delay, have a password
+// and a verified email
+func newSecurityClient(t *testing.T, count int, status int, delay time.Duration, fetches *int32) *kratos.Client {
+	t.Helper()
+	ids := []string{"a", "b"}
+	for i := len(ids); i < count; i++ {
+		ids = append(ids, fmt.Sprintf("identity-%d", i))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/admin/identities" {
+			var items []string
+			for _, id := range ids {
+				items = append(items, `{"id":"`+id+`","schema_id":"default","schema_url":"","traits":{}}`)
+			}
+			w.Write([]byte("[" + strings.Join(items, ",") + "]"))
+			return
+		}
+
+		atomic.AddInt32(fetches, 1)
+		id := strings.TrimPrefix(r.URL.Path, "/admin/identities/")
+		if id == "b" {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"error":{"code":%d,"message":%q}}`, status, http.StatusText(status))
+			return
+		}
+		time.Sleep(delay)
+		w.Write([]byte(`{"id":"` + id + `","schema_id":"default","schema_url":"","traits":{},
+			"credentials":{"password":{"type":"password","identifiers":["x@example.com"]}},
+			"verifiable_addresses":[{"id":"x","value":"x@example.com","verified":true,"via":"email","status":"completed"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return kratos.NewClient(server.URL)
+}
+
+func TestComputeSecurityReportSkipsDeletedIdentities(t *testing.T) {
+	var fetches int32
+	report, err := ComputeSecurityReport(context.Background(), newSecurityClient(t, 10, http.StatusNotFound, 0, &fetches))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.TotalIdentities != 9 {
+		t.Errorf("TotalIdentities = %d, want 9", report.TotalIdentities)
+	}
+	if report.Counts[CategoryPasswordOnly] != 9 || report.Ratios[CategoryPasswordOnly] != 1 {
+		t.Errorf("password only = %d (ratio %v), want 9 (ratio 1)", report.Counts[CategoryPasswordOnly], report.Ratios[CategoryPasswordOnly])
+	}
+	if report.Addresses.Verified != 9 {
+		t.Errorf("verified addresses = %d, want 9", report.Addresses.Verified)
+	}
+}
+
+func TestComputeSecurityReportStopsOnError(t *testing.T) {
+	var fetches int32
+	_, err := ComputeSecurityReport(context.Background(), newSecurityClient(t, 200, http.StatusInternalServerError, 50*time.Millisecond, &fetches))
+	if kratos.StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("error = %v, want the 500 of the failed fetch", err)
+	}
+	// Only the fetches running when b failed were started
+	if n := atomic.LoadInt32(&fetches); n > 2*credentialFetchConcurrency {
+		t.Errorf("fetched %d identities after the failure, want at most %d", n, 2*credentialFetchConcurrency)
+	}
+}
+
+func TestComputeSecurityReportCancelled(t *testing.T) {
+	var fetches int32
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newSecurityClient(t, 10, http.StatusNotFound, 0, &fetches)
+	cancel()
+
+	if _, err := ComputeSecurityReport(ctx, client); err == nil {
+		t.Fatal("ComputeSecurityReport succeeded with a cancelled context")
+	}
+}
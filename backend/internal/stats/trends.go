@@ -0,0 +1,171 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// Trend bucket sizes
+const (
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+// TrendSeries holds the bucketed trends of a set of identities
+type TrendSeries struct {
+	Signups          []Point `json:"signups"`
+	FirstLogins      []Point `json:"first_logins"`
+	ActiveIdentities []Point `json:"active_identities"`
+}
+
+// Trends holds signup and activity trends, overall and per schema
+type Trends struct {
+	From    time.Time               `json:"from"`
+	To      time.Time               `json:"to"`
+	Bucket  string                  `json:"bucket"`
+	Total   *TrendSeries            `json:"total"`
+	Schemas map[string]*TrendSeries `json:"schemas"`
+}
+
+// ComputeTrends derives trends from identity creation times and session authentication times.
+// First logins and active identities only see sessions Kratos still stores.
+func ComputeTrends(ctx context.Context, client *kratos.Client, from, to time.Time, bucket string) (*Trends, error) {
+	starts, err := bucketStarts(from, to, bucket)
+	if err != nil {
+		return nil, err
+	}
+	from = starts[0]
+
+	trends := &Trends{
+		From:    from,
+		To:      to,
+		Bucket:  bucket,
+		Total:   newTrendSeries(starts),
+		Schemas: map[string]*TrendSeries{},
+	}
+	series := func(schemaID string) *TrendSeries {
+		s, ok := trends.Schemas[schemaID]
+		if !ok {
+			s = newTrendSeries(starts)
+			trends.Schemas[schemaID] = s
+		}
+		return s
+	}
+	index := func(t time.Time) int {
+		if t.Before(from) || t.After(to) {
+			return -1
+		}
+		for i := len(starts) - 1; i >= 0; i-- {
+			if !t.Before(starts[i]) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	schemas := map[string]string{}
+	err = client.ForEachIdentityPage(ctx, func(identities []ory.Identity) error {
+		for _, identity := range identities {
+			schemas[identity.Id] = identity.SchemaId
+			if identity.CreatedAt == nil {
+				continue
+			}
+			if i := index(*identity.CreatedAt); i >= 0 {
+				trends.Total.Signups[i].Value++
+				series(identity.SchemaId).Signups[i].Value++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+
+	firstLogin := map[string]time.Time{}
+	active := map[int]map[string]bool{}
+	err = client.ForEachSessionPage(ctx, nil, []string{"identity"}, func(sessions []ory.Session) error {
+		for _, session := range sessions {
+			identityID := session.Identity.Id
+			if identityID == "" || session.AuthenticatedAt == nil {
+				continue
+			}
+			at := *session.AuthenticatedAt
+
+			if first, ok := firstLogin[identityID]; !ok || at.Before(first) {
+				firstLogin[identityID] = at
+			}
+			if i := index(at); i >= 0 {
+				if active[i] == nil {
+					active[i] = map[string]bool{}
+				}
+				active[i][identityID] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for identityID, at := range firstLogin {
+		if i := index(at); i >= 0 {
+			trends.Total.FirstLogins[i].Value++
+			series(schemas[identityID]).FirstLogins[i].Value++
+		}
+	}
+	for i, identities := range active {
+		for identityID := range identities {
+			trends.Total.ActiveIdentities[i].Value++
+			series(schemas[identityID]).ActiveIdentities[i].Value++
+		}
+	}
+
+	return trends, nil
+}
+
+// newTrendSeries creates zero-valued series for the given bucket starts
+func newTrendSeries(starts []time.Time) *TrendSeries {
+	points := func() []Point {
+		p := make([]Point, len(starts))
+		for i, start := range starts {
+			p[i] = Point{Timestamp: start}
+		}
+		return p
+	}
+	return &TrendSeries{
+		Signups:          points(),
+		FirstLogins:      points(),
+		ActiveIdentities: points(),
+	}
+}
+
+// bucketStarts returns the UTC start of every day or week (starting Monday) between from and to
+func bucketStarts(from, to time.Time, bucket string) ([]time.Time, error) {
+	from = from.UTC()
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+
+	var step time.Duration
+	switch bucket {
+	case BucketDay:
+		step = 24 * time.Hour
+	case BucketWeek:
+		step = 7 * 24 * time.Hour
+		offset := (int(start.Weekday()) + 6) % 7
+		start = start.AddDate(0, 0, -offset)
+	default:
+		return nil, fmt.Errorf("unknown bucket %q, expected %q or %q", bucket, BucketDay, BucketWeek)
+	}
+
+	var starts []time.Time
+	for t := start; !t.After(to); t = t.Add(step) {
+		starts = append(starts, t)
+	}
+	if len(starts) == 0 {
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// SchemaUsage holds identity counts for a single identity schema
+type SchemaUsage struct {
+	SchemaID         string           `json:"schema_id"`
+	Total            int64            `json:"total"`
+	ByState          map[string]int64 `json:"by_state"`
+	ByCredentialType map[string]int64 `json:"by_credential_type"`
+	ComputedAt       time.Time        `json:"computed_at"`
+}
+
+// newSchemaUsage creates an empty usage record for a schema
+func newSchemaUsage(schemaID string, computedAt time.Time) *SchemaUsage {
+	return &SchemaUsage{
+		SchemaID:         schemaID,
+		ByState:          map[string]int64{},
+		ByCredentialType: map[string]int64{},
+		ComputedAt:       computedAt,
+	}
+}
+
+// add counts a single identity
+func (u *SchemaUsage) add(identity ory.Identity) {
+	u.Total++
+
+	state := "unknown"
+	if identity.State != nil {
+		state = string(*identity.State)
+	}
+	u.ByState[state]++
+
+	if identity.Credentials != nil {
+		for credType := range *identity.Credentials {
+			u.ByCredentialType[credType]++
+		}
+	}
+}
+
+// UsageCache computes schema usage by paging through all identities and caches the result
+type UsageCache struct {
+	client *kratos.Client
+	ttl    time.Duration
+
+	mu         sync.Mutex
+	usage      map[string]*SchemaUsage
+	computedAt time.Time
+}
+
+// NewUsageCache creates a new schema usage cache
+func NewUsageCache(client *kratos.Client, ttl time.Duration) *UsageCache {
+	return &UsageCache{client: client, ttl: ttl}
+}
+
+// All returns usage for every schema that has at least one identity
+func (c *UsageCache) All(ctx context.Context) (map[string]*SchemaUsage, error) {
+	usage, _, err := c.all(ctx)
+	return usage, err
+}
+
+// all returns the cached usage and when it was computed, recomputing it once expired
+func (c *UsageCache) all(ctx context.Context) (map[string]*SchemaUsage, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.usage != nil && time.Since(c.computedAt) < c.ttl {
+		return c.usage, c.computedAt, nil
+	}
+
+	usage, err := ComputeSchemaUsage(ctx, c.client)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	c.usage = usage
+	c.computedAt = time.Now()
+	return usage, c.computedAt, nil
+}
+
+// Get returns usage for a single schema. Schemas without identities return zero counts.
+func (c *UsageCache) Get(ctx context.Context, schemaID string) (*SchemaUsage, error) {
+	all, computedAt, err := c.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if usage, ok := all[schemaID]; ok {
+		return usage, nil
+	}
+	return newSchemaUsage(schemaID, computedAt), nil
+}
+
+// Invalidate drops the cached usage so the next call recomputes it
+func (c *UsageCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage = nil
+}
+
+// ComputeSchemaUsage pages through all identities and counts them per schema
+func ComputeSchemaUsage(ctx context.Context, client *kratos.Client) (map[string]*SchemaUsage, error) {
+	now := time.Now()
+	usage := map[string]*SchemaUsage{}
+
+	err := client.ForEachIdentityPage(ctx, func(identities []ory.Identity) error {
+		for _, identity := range identities {
+			u, ok := usage[identity.SchemaId]
+			if !ok {
+				u = newSchemaUsage(identity.SchemaId, now)
+				usage[identity.SchemaId] = u
+			}
+			u.add(identity)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
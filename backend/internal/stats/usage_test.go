@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+)
+
+// newTestClient returns a client for a Kratos admin API listing two identities of the default schema
+func newTestClient(t *testing.T) *kratos.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/identities" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"id":"a","schema_id":"default","schema_url":"","traits":{},"state":"active","credentials":{"password":{}}},
+			{"id":"b","schema_id":"default","schema_url":"","traits":{},"state":"inactive"}
+		]`))
+	}))
+	t.Cleanup(server.Close)
+	return kratos.NewClient(server.URL)
+}
+
+func TestUsageCacheGet(t *testing.T) {
+	cache := NewUsageCache(newTestClient(t), time.Minute)
+	ctx := context.Background()
+
+	usage, err := cache.Get(ctx, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Total != 2 || usage.ByState["active"] != 1 || usage.ByState["inactive"] != 1 || usage.ByCredentialType["password"] != 1 {
+		t.Errorf("usage = %+v, want 2 identities, 1 active, 1 inactive, 1 password", usage)
+	}
+
+	unused, err := cache.Get(ctx, "customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unused.Total != 0 || unused.ComputedAt.IsZero() {
+		t.Errorf("unused schema = %+v, want no identities and a computation time", unused)
+	}
+}
+
+// TestUsageCacheConcurrentGet recomputes usage on every call, run it with -race
+func TestUsageCacheConcurrentGet(t *testing.T) {
+	cache := NewUsageCache(newTestClient(t), 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := cache.Get(context.Background(), "customer"); err != nil {
+					t.Error(err)
+					return
+				}
+				cache.Invalidate()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,51 @@
+package transfer
+
+import (
+	"sort"
+	"strings"
+
+	ory "github.com/ory/kratos-client-go"
+)
+
+// identifiers returns the credential identifiers of an identity, password identifiers first
+func identifiers(identity *ory.Identity) []string {
+	if identity.Credentials == nil {
+		return nil
+	}
+
+	var result []string
+	seen := map[string]bool{}
+	add := func(values []string) {
+		for _, value := range values {
+			if value != "" && !seen[value] {
+				seen[value] = true
+				result = append(result, value)
+			}
+		}
+	}
+
+	creds := *identity.Credentials
+	add(creds["password"].Identifiers)
+
+	types := make([]string, 0, len(creds))
+	for credType := range creds {
+		types = append(types, credType)
+	}
+	sort.Strings(types)
+	for _, credType := range types {
+		add(creds[credType].Identifiers)
+	}
+
+	return result
+}
+
+// hasSuffix reports whether any of the values ends with suffix, ignoring case
+func hasSuffix(values []string, suffix string) bool {
+	suffix = strings.ToLower(suffix)
+	for _, value := range values {
+		if strings.HasSuffix(strings.ToLower(value), suffix) {
+			return true
+		}
+	}
+	return false
+}
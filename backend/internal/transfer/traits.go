@@ -0,0 +1,22 @@
+package transfer
+
+// mergeTraits returns dst with the values of src applied on top of it.
+// Nested objects are merged recursively, any other value from src replaces the one in dst.
+func mergeTraits(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		merged[key] = value
+	}
+
+	for key, value := range src {
+		srcObject, srcOK := value.(map[string]interface{})
+		dstObject, dstOK := merged[key].(map[string]interface{})
+		if srcOK && dstOK {
+			merged[key] = mergeTraits(dstObject, srcObject)
+			continue
+		}
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,329 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/jobs"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/schema"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/webhooks"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// JobKind identifies identity transfer jobs in the job manager
+const JobKind = "identity_transfer"
+
+// Conflict strategies, applied when an identity already exists in the destination
+const (
+	StrategySkip      = "skip"
+	StrategyOverwrite = "overwrite"
+	StrategyMerge     = "merge"
+)
+
+// Actions taken for a source identity
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionSkip   = "skip"
+)
+
+const (
+	defaultBatchSize = 10
+	maxFailures      = 1000
+	maxChanges       = 1000
+)
+
+// Filter selects the source identities to transfer. Empty fields match every identity.
+type Filter struct {
+	IDs      []string `json:"ids,omitempty"`
+	SchemaID string   `json:"schema_id,omitempty"`
+	State    string   `json:"state,omitempty"`
+	// IdentifierSuffix matches identities with a credential identifier ending with it, e.g. "@example.com"
+	IdentifierSuffix string `json:"identifier_suffix,omitempty"`
+}
+
+// Plan describes a copy of identities from one Kratos target to another
+type Plan struct {
+	Source             string `json:"source" binding:"required"`
+	Destination        string `json:"destination" binding:"required"`
+	Strategy           string `json:"strategy" binding:"required,oneof=skip overwrite merge"`
+	Filter             Filter `json:"filter"`
+	IncludeCredentials bool   `json:"include_credentials"`
+	BatchSize          int    `json:"batch_size,omitempty"`
+	DryRun             bool   `json:"dry_run"`
+}
+
+// Failure describes an identity that could not be transferred
+type Failure struct {
+	SourceID string `json:"source_id"`
+	Error    string `json:"error"`
+}
+
+// Change describes what is, or would be in a dry run, done with a source identity
+type Change struct {
+	SourceID      string               `json:"source_id"`
+	DestinationID string               `json:"destination_id,omitempty"`
+	Identifier    string               `json:"identifier,omitempty"`
+	Action        string               `json:"action"`
+	Traits        []schema.FieldChange `json:"traits,omitempty"`
+	// Credentials lists the credential types copied, Unsupported those Kratos cannot import
+	Credentials []string `json:"credentials,omitempty"`
+	Unsupported []string `json:"unsupported_credentials,omitempty"`
+}
+
+// Report summarizes a transfer run
+type Report struct {
+	DryRun      bool      `json:"dry_run"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Strategy    string    `json:"strategy"`
+	Matched     int64     `json:"matched"`
+	Created     int64     `json:"created"`
+	Updated     int64     `json:"updated"`
+	Skipped     int64     `json:"skipped"`
+	Failed      int64     `json:"failed"`
+	Failures    []Failure `json:"failures"`
+	Changes     []Change  `json:"changes"`
+}
+
+// record adds the outcome of a source identity to the report
+func (r *Report) record(change *Change, err error) {
+	if err != nil {
+		r.Failed++
+		if len(r.Failures) < maxFailures {
+			r.Failures = append(r.Failures, Failure{SourceID: change.SourceID, Error: err.Error()})
+		}
+		return
+	}
+
+	switch change.Action {
+	case ActionCreate:
+		r.Created++
+	case ActionUpdate:
+		r.Updated++
+	case ActionSkip:
+		r.Skipped++
+	}
+	if len(r.Changes) < maxChanges {
+		r.Changes = append(r.Changes, *change)
+	}
+}
+
+// Copier copies identities between two Kratos targets
+type Copier struct {
+	source      *kratos.Client
+	destination *kratos.Client
+	events      *webhooks.Publisher
+}
+
+// NewCopier creates a new copier publishing the identities it writes to the destination's events
+func NewCopier(source, destination *kratos.Client, events *webhooks.Publisher) *Copier {
+	return &Copier{source: source, destination: destination, events: events}
+}
+
+// Run copies the identities matching the plan filter. In a dry run nothing is written and
+// the report lists what would be created or updated. Identities are matched in the
+// destination by credential identifier, as Kratos does not allow choosing identity IDs.
+// actor is the admin who started the transfer. The report is returned even when the run
+// fails part way through.
+func (c *Copier) Run(ctx context.Context, plan Plan, actor string, progress *jobs.Progress) (*Report, error) {
+	report := &Report{
+		DryRun:      plan.DryRun,
+		Source:      plan.Source,
+		Destination: plan.Destination,
+		Strategy:    plan.Strategy,
+		Failures:    []Failure{},
+		Changes:     []Change{},
+	}
+
+	ids := map[string]bool{}
+	for _, id := range plan.Filter.IDs {
+		ids[id] = true
+	}
+
+	var identities []ory.Identity
+	err := c.source.ForEachIdentityPage(ctx, func(page []ory.Identity) error {
+		for _, identity := range page {
+			if len(ids) > 0 && !ids[identity.Id] {
+				continue
+			}
+			if plan.Filter.SchemaID != "" && identity.SchemaId != plan.Filter.SchemaID {
+				continue
+			}
+			if plan.Filter.State != "" && (identity.State == nil || string(*identity.State) != plan.Filter.State) {
+				continue
+			}
+			identities = append(identities, identity)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list source identities: %w", err)
+	}
+	progress.SetTotal(int64(len(identities)))
+
+	batchSize := plan.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	var mu sync.Mutex
+	for start := 0; start < len(identities); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		end := start + batchSize
+		if end > len(identities) {
+			end = len(identities)
+		}
+
+		var wg sync.WaitGroup
+		var created, updated []interface{}
+		for _, identity := range identities[start:end] {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				change, written, matched, err := c.transfer(ctx, plan, id)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if matched {
+					report.Matched++
+					report.record(change, err)
+				}
+				if written != nil && err == nil {
+					kratos.RedactCredentials(written)
+					if change.Action == ActionCreate {
+						created = append(created, written)
+					} else {
+						updated = append(updated, written)
+					}
+				}
+				progress.Add(1)
+			}(identity.Id)
+		}
+		wg.Wait()
+		c.events.PublishBatch(webhooks.EventIdentityCreated, actor, created)
+		c.events.PublishBatch(webhooks.EventIdentityUpdated, actor, updated)
+	}
+
+	return report, nil
+}
+
+// transfer copies a single identity and returns the identity written to the destination, nil
+// for dry runs and skipped identities. matched is false if it is excluded by the identifier filter.
+func (c *Copier) transfer(ctx context.Context, plan Plan, id string) (*Change, *ory.Identity, bool, error) {
+	change := &Change{SourceID: id}
+
+	identity, err := c.source.GetIdentityWithCredentials(ctx, id)
+	if err != nil {
+		return change, nil, true, fmt.Errorf("failed to fetch source identity: %w", err)
+	}
+
+	idents := identifiers(identity)
+	if plan.Filter.IdentifierSuffix != "" && !hasSuffix(idents, plan.Filter.IdentifierSuffix) {
+		return change, nil, false, nil
+	}
+
+	var creds *ory.IdentityWithCredentials
+	if plan.IncludeCredentials {
+		creds, change.Credentials, change.Unsupported = kratos.ExportCredentials(identity)
+	}
+
+	existing, identifier, err := c.find(ctx, idents)
+	if err != nil {
+		return change, nil, true, fmt.Errorf("failed to look up destination identity: %w", err)
+	}
+	change.Identifier = identifier
+
+	traits, _ := identity.Traits.(map[string]interface{})
+
+	if existing == nil {
+		change.Action = ActionCreate
+		change.Traits = schema.DiffTraits(nil, traits)
+		if plan.DryRun {
+			return change, nil, true, nil
+		}
+
+		created, err := c.destination.CreateIdentity(ctx, ory.CreateIdentityBody{
+			SchemaId:            identity.SchemaId,
+			Traits:              traits,
+			State:               identity.State,
+			MetadataPublic:      identity.MetadataPublic,
+			MetadataAdmin:       identity.MetadataAdmin,
+			VerifiableAddresses: kratos.VerifiableAddresses(identity),
+			Credentials:         creds,
+		})
+		if err != nil {
+			return change, nil, true, err
+		}
+		change.DestinationID = created.Id
+		return change, created, true, nil
+	}
+
+	change.DestinationID = existing.Id
+	if plan.Strategy == StrategySkip {
+		change.Action = ActionSkip
+		change.Credentials, change.Unsupported = nil, nil
+		return change, nil, true, nil
+	}
+
+	current, _ := existing.Traits.(map[string]interface{})
+	body := ory.UpdateIdentityBody{
+		SchemaId:       identity.SchemaId,
+		Traits:         traits,
+		State:          stateOf(identity),
+		MetadataPublic: identity.MetadataPublic,
+		MetadataAdmin:  identity.MetadataAdmin,
+		Credentials:    creds,
+	}
+	if plan.Strategy == StrategyMerge {
+		// Keep the destination's schema, state and metadata and only add or replace traits
+		body = ory.UpdateIdentityBody{
+			SchemaId:       existing.SchemaId,
+			Traits:         mergeTraits(current, traits),
+			State:          stateOf(existing),
+			MetadataPublic: existing.MetadataPublic,
+			MetadataAdmin:  existing.MetadataAdmin,
+			Credentials:    creds,
+		}
+	}
+
+	change.Action = ActionUpdate
+	change.Traits = schema.DiffTraits(current, body.Traits)
+	if plan.DryRun {
+		return change, nil, true, nil
+	}
+
+	updated, err := c.destination.UpdateIdentity(ctx, existing.Id, body)
+	return change, updated, true, err
+}
+
+// find returns the destination identity sharing one of the identifiers, and that identifier
+func (c *Copier) find(ctx context.Context, identifiers []string) (*ory.Identity, string, error) {
+	for _, identifier := range identifiers {
+		found, err := c.destination.FindIdentitiesByIdentifier(ctx, identifier)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(found) > 0 {
+			return &found[0], identifier, nil
+		}
+	}
+
+	if len(identifiers) > 0 {
+		return nil, identifiers[0], nil
+	}
+	return nil, "", nil
+}
+
+// stateOf returns the state of an identity, defaulting to active
+func stateOf(identity *ory.Identity) ory.IdentityState {
+	if identity.State != nil {
+		return *identity.State
+	}
+	return ory.IDENTITYSTATE_ACTIVE
+}
@@ -0,0 +1,380 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/kratos"
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+	ory "github.com/ory/kratos-client-go"
+)
+
+// entriesDocument is the store document holding soft-deleted identities
+const entriesDocument = "deleted_identities.json"
+
+var (
+	// ErrNotFound is returned when an identity is not in the trash
+	ErrNotFound = errors.New("identity is not soft-deleted")
+	// ErrAlreadyDeleted is returned when soft-deleting an identity that is already in the trash
+	ErrAlreadyDeleted = errors.New("identity is already soft-deleted")
+	// ErrExpired is returned when restoring an identity after its restore window
+	ErrExpired = errors.New("restore window has expired")
+)
+
+// Entry is the snapshot of a soft-deleted identity, taken before it was deactivated
+type Entry struct {
+	IdentityID string `json:"identity_id"`
+	// Identity includes the credentials, with password hashes when Kratos exports them
+	Identity ory.Identity `json:"identity"`
+	// Sessions are the identity's sessions when it was deleted. Revoked sessions cannot be restored.
+	Sessions  []ory.Session `json:"sessions"`
+	DeletedBy string        `json:"deleted_by"`
+	DeletedAt time.Time     `json:"deleted_at"`
+	PurgeAt   time.Time     `json:"purge_at"`
+}
+
+// Redacted returns the entry without credential configs, which hold password hashes
+func (e Entry) Redacted() Entry {
+	kratos.RedactCredentials(&e.Identity)
+	return e
+}
+
+// Restored is the result of restoring a soft-deleted identity
+type Restored struct {
+	Identity *ory.Identity `json:"identity"`
+	// Recreated is true when the identity had been removed from Kratos and was created again, with a new ID
+	Recreated bool `json:"recreated"`
+	// Credentials lists the credential types imported into a recreated identity, Unsupported those that were lost
+	Credentials []string `json:"credentials,omitempty"`
+	Unsupported []string `json:"unsupported_credentials,omitempty"`
+}
+
+// Bin soft-deletes identities of a Kratos target and purges them after the retention period
+type Bin struct {
+	client    *kratos.Client
+	store     *store.Store
+	retention time.Duration
+	interval  time.Duration
+
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+// NewBin loads the soft-deleted identities. Expired ones are purged every interval by Run.
+func NewBin(client *kratos.Client, s *store.Store, retention, interval time.Duration) (*Bin, error) {
+	b := &Bin{client: client, store: s, retention: retention, interval: interval}
+	if err := s.Load(entriesDocument, &b.entries); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Delete snapshots an identity and its sessions, deactivates it and revokes its sessions.
+// The identity can be restored until the retention period ends.
+func (b *Bin) Delete(ctx context.Context, id, admin string) (Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, err := b.snapshot(ctx, id, admin)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	identity := entry.Identity
+	traits, _ := identity.Traits.(map[string]interface{})
+	_, err = b.client.UpdateIdentity(ctx, id, ory.UpdateIdentityBody{
+		SchemaId:       identity.SchemaId,
+		Traits:         traits,
+		State:          ory.IDENTITYSTATE_INACTIVE,
+		MetadataPublic: identity.MetadataPublic,
+		MetadataAdmin:  identity.MetadataAdmin,
+	})
+	if err != nil {
+		b.drop(id)
+		return Entry{}, fmt.Errorf("failed to deactivate identity: %w", err)
+	}
+
+	// Kratos answers 404 when the identity has no session to revoke
+	if err := b.client.RevokeIdentitySessions(ctx, id); err != nil && kratos.StatusCode(err) != http.StatusNotFound {
+		return *entry, fmt.Errorf("identity deactivated but failed to revoke its sessions: %w", err)
+	}
+
+	return *entry, nil
+}
+
+// Discard snapshots an identity and its sessions like Delete, then deletes it from Kratos so its
+// identifiers are free, e.g. for the loser of a merge. Restoring it creates it again.
+func (b *Bin) Discard(ctx context.Context, id, admin string) (Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, err := b.snapshot(ctx, id, admin)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if err := b.client.DeleteIdentity(ctx, id); err != nil {
+		b.drop(id)
+		return Entry{}, fmt.Errorf("failed to delete identity: %w", err)
+	}
+	return *entry, nil
+}
+
+// snapshot fetches an identity and its sessions and stores them in the trash before anything
+// changes in Kratos. Callers must hold b.mu.
+func (b *Bin) snapshot(ctx context.Context, id, admin string) (*Entry, error) {
+	if _, ok := b.find(id); ok {
+		return nil, ErrAlreadyDeleted
+	}
+
+	identity, err := b.client.GetIdentityWithCredentials(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := b.client.GetIdentitySessions(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+	if sessions == nil {
+		sessions = []ory.Session{}
+	}
+
+	now := time.Now().UTC()
+	entry := &Entry{
+		IdentityID: id,
+		Identity:   *identity,
+		Sessions:   sessions,
+		DeletedBy:  admin,
+		DeletedAt:  now,
+		PurgeAt:    now.Add(b.retention),
+	}
+
+	b.entries = append(b.entries, entry)
+	if err := b.save(); err != nil {
+		b.entries = b.entries[:len(b.entries)-1]
+		return nil, err
+	}
+	return entry, nil
+}
+
+// drop removes the snapshot of an identity that could not be deleted. Callers must hold b.mu.
+func (b *Bin) drop(id string) {
+	b.remove(id)
+	if err := b.save(); err != nil {
+		log.Printf("Failed to save soft-deleted identities: %v", err)
+	}
+}
+
+// List returns the soft-deleted identities, most recently deleted first
+func (b *Bin) List() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make([]Entry, 0, len(b.entries))
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		result = append(result, *b.entries[i])
+	}
+	return result
+}
+
+// Get returns a soft-deleted identity
+func (b *Bin) Get(id string) (Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.find(id)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return *entry, nil
+}
+
+// Restore brings back the snapshot of a soft-deleted identity, reactivating it when it still
+// exists in Kratos and creating it again with its exportable credentials otherwise
+func (b *Bin) Restore(ctx context.Context, id string) (Restored, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.find(id)
+	if !ok {
+		return Restored{}, ErrNotFound
+	}
+	if time.Now().After(entry.PurgeAt) {
+		return Restored{}, ErrExpired
+	}
+
+	snapshot := entry.Identity
+	traits, _ := snapshot.Traits.(map[string]interface{})
+	state := ory.IDENTITYSTATE_ACTIVE
+	if snapshot.State != nil {
+		state = *snapshot.State
+	}
+
+	var restored Restored
+	_, err := b.client.GetIdentity(ctx, id)
+	switch {
+	case err == nil:
+		restored.Identity, err = b.client.UpdateIdentity(ctx, id, ory.UpdateIdentityBody{
+			SchemaId:       snapshot.SchemaId,
+			Traits:         traits,
+			State:          state,
+			MetadataPublic: snapshot.MetadataPublic,
+			MetadataAdmin:  snapshot.MetadataAdmin,
+		})
+		if err != nil {
+			return Restored{}, fmt.Errorf("failed to reactivate identity: %w", err)
+		}
+	case kratos.StatusCode(err) == http.StatusNotFound:
+		var creds *ory.IdentityWithCredentials
+		creds, restored.Credentials, restored.Unsupported = kratos.ExportCredentials(&snapshot)
+		restored.Identity, err = b.client.CreateIdentity(ctx, ory.CreateIdentityBody{
+			SchemaId:            snapshot.SchemaId,
+			Traits:              traits,
+			State:               &state,
+			MetadataPublic:      snapshot.MetadataPublic,
+			MetadataAdmin:       snapshot.MetadataAdmin,
+			VerifiableAddresses: kratos.VerifiableAddresses(&snapshot),
+			Credentials:         creds,
+		})
+		if err != nil {
+			return Restored{}, fmt.Errorf("failed to recreate identity: %w", err)
+		}
+		restored.Recreated = true
+	default:
+		return Restored{}, err
+	}
+
+	b.remove(id)
+	return restored, b.save()
+}
+
+// Purge permanently deletes a soft-deleted identity from Kratos without waiting for the retention period
+func (b *Bin) Purge(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.find(id); !ok {
+		return ErrNotFound
+	}
+	if err := b.client.DeleteIdentity(ctx, id); err != nil && kratos.StatusCode(err) != http.StatusNotFound {
+		return err
+	}
+
+	b.remove(id)
+	return b.save()
+}
+
+// Forget drops the snapshot of a soft-deleted identity without deleting it from Kratos,
+// e.g. when its personal data is erased. Identities that are not in the trash are ignored.
+func (b *Bin) Forget(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.find(id); !ok {
+		return nil
+	}
+	b.remove(id)
+	return b.save()
+}
+
+// PurgeExpired permanently deletes the identities whose retention period has ended and returns
+// how many were purged. Identities reactivated in Kratos in the meantime are kept and only
+// dropped from the trash. The trash stays usable while Kratos is called.
+func (b *Bin) PurgeExpired(ctx context.Context) (int, error) {
+	now := time.Now()
+	var expired []string
+	b.mu.Lock()
+	for _, entry := range b.entries {
+		if !now.Before(entry.PurgeAt) {
+			expired = append(expired, entry.IdentityID)
+		}
+	}
+	b.mu.Unlock()
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	purged := 0
+	var done []string
+	var errs []error
+	for _, id := range expired {
+		identity, err := b.client.GetIdentity(ctx, id)
+		switch {
+		case err == nil && identity.State != nil && *identity.State == ory.IDENTITYSTATE_ACTIVE:
+			log.Printf("Not purging identity %s, which was reactivated outside of the trash", id)
+		case err == nil:
+			if err := b.client.DeleteIdentity(ctx, id); err != nil && kratos.StatusCode(err) != http.StatusNotFound {
+				errs = append(errs, fmt.Errorf("identity %s: %w", id, err))
+				continue
+			}
+			purged++
+		case kratos.StatusCode(err) != http.StatusNotFound:
+			errs = append(errs, fmt.Errorf("identity %s: %w", id, err))
+			continue
+		}
+		done = append(done, id)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range done {
+		b.remove(id)
+	}
+	if err := b.save(); err != nil {
+		errs = append(errs, err)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// Run purges expired identities immediately and then on every interval until ctx is cancelled
+func (b *Bin) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := b.PurgeExpired(ctx)
+		if err != nil {
+			log.Printf("Failed to purge soft-deleted identities: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d soft-deleted identities", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// find returns a soft-deleted identity. Callers must hold b.mu.
+func (b *Bin) find(id string) (*Entry, bool) {
+	for _, entry := range b.entries {
+		if entry.IdentityID == id {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// remove drops an identity from the trash. Callers must hold b.mu.
+func (b *Bin) remove(id string) {
+	kept := b.entries[:0]
+	for _, entry := range b.entries {
+		if entry.IdentityID != id {
+			kept = append(kept, entry)
+		}
+	}
+	b.entries = kept
+}
+
+// save persists the soft-deleted identities. Callers must hold b.mu.
+func (b *Bin) save() error {
+	return b.store.Save(entriesDocument, b.entries)
+}
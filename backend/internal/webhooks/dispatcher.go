@@ -0,0 +1,553 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+)
+
+const (
+	subscriptionsDocument = "webhooks.json"
+	deliveriesDocument    = "webhook_deliveries.json"
+
+	// maxDeliveries is the number of finished deliveries kept in the log
+	maxDeliveries = 1000
+	// maxBackoff caps the delay between two attempts
+	maxBackoff = time.Hour
+	// idleWait is the longest the dispatcher sleeps without checking for due deliveries
+	idleWait = time.Minute
+)
+
+// Dispatcher stores webhook subscriptions and delivers events to them with retries
+type Dispatcher struct {
+	store       *store.Store
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+
+	mu            sync.Mutex
+	subscriptions []Subscription
+	deliveries    []*Delivery
+	inFlight      map[string]bool
+	wake          chan struct{}
+}
+
+// NewDispatcher loads subscriptions and the delivery log from the store.
+// Failed attempts are retried up to maxAttempts times, waiting backoff and then twice as long each time.
+func NewDispatcher(s *store.Store, maxAttempts int, backoff, timeout time.Duration) (*Dispatcher, error) {
+	d := &Dispatcher{
+		store:       s,
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		inFlight:    map[string]bool{},
+		wake:        make(chan struct{}, 1),
+	}
+
+	if err := s.Load(subscriptionsDocument, &d.subscriptions); err != nil {
+		return nil, err
+	}
+	if err := s.Load(deliveriesDocument, &d.deliveries); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Subscriptions returns all subscriptions, without their secrets
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		result = append(result, sub.Redacted())
+	}
+	return result
+}
+
+// Subscription returns a subscription without its secret
+func (d *Dispatcher) Subscription(id string) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, sub := range d.subscriptions {
+		if sub.ID == id {
+			return sub.Redacted(), nil
+		}
+	}
+	return Subscription{}, ErrNotFound
+}
+
+// CreateSubscription stores a new subscription and returns it with its secret.
+// A secret is generated when none is given.
+func (d *Dispatcher) CreateSubscription(sub Subscription) (Subscription, error) {
+	now := time.Now().UTC()
+	sub.ID = newID()
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+	if sub.Secret == "" {
+		sub.Secret = NewSecret()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.subscriptions = append(d.subscriptions, sub)
+	if err := d.store.Save(subscriptionsDocument, d.subscriptions); err != nil {
+		d.subscriptions = d.subscriptions[:len(d.subscriptions)-1]
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// UpdateSubscription replaces the URL, events, targets and active flag of a subscription.
+// The secret is only replaced when a new one is given.
+func (d *Dispatcher) UpdateSubscription(id string, update Subscription) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, sub := range d.subscriptions {
+		if sub.ID != id {
+			continue
+		}
+
+		previous := sub
+		sub.URL = update.URL
+		sub.Events = update.Events
+		sub.Targets = update.Targets
+		sub.Active = update.Active
+		if update.Secret != "" {
+			sub.Secret = update.Secret
+		}
+		sub.UpdatedAt = time.Now().UTC()
+
+		d.subscriptions[i] = sub
+		if err := d.store.Save(subscriptionsDocument, d.subscriptions); err != nil {
+			d.subscriptions[i] = previous
+			return Subscription{}, err
+		}
+		return sub.Redacted(), nil
+	}
+	return Subscription{}, ErrNotFound
+}
+
+// DeleteSubscription removes a subscription. Its pending deliveries are abandoned.
+func (d *Dispatcher) DeleteSubscription(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	kept := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		if sub.ID != id {
+			kept = append(kept, sub)
+		}
+	}
+	if len(kept) == len(d.subscriptions) {
+		return ErrNotFound
+	}
+
+	if err := d.store.Save(subscriptionsDocument, kept); err != nil {
+		return err
+	}
+	d.subscriptions = kept
+
+	for _, delivery := range d.deliveries {
+		if delivery.SubscriptionID == id && delivery.Status == DeliveryPending {
+			delivery.Status = DeliveryFailed
+			delivery.NextAttemptAt = nil
+		}
+	}
+	return d.saveDeliveries()
+}
+
+// Publish queues an event for every active subscription selecting it
+func (d *Dispatcher) Publish(target, actor, eventType string, data interface{}) {
+	d.PublishBatch(target, actor, eventType, []interface{}{data})
+}
+
+// PublishBatch queues one event per item of data and saves the deliveries once, so bulk
+// operations do not rewrite them for every identity
+func (d *Dispatcher) PublishBatch(target, actor, eventType string, data []interface{}) {
+	events := make([]Event, 0, len(data))
+	payloads := make([][]byte, 0, len(data))
+	for _, item := range data {
+		event := Event{
+			ID:         newID(),
+			Type:       eventType,
+			Target:     target,
+			Actor:      actor,
+			OccurredAt: time.Now().UTC(),
+			Data:       item,
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to encode %s webhook event: %v", eventType, err)
+			continue
+		}
+		events = append(events, event)
+		payloads = append(payloads, payload)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queued := false
+	for i, event := range events {
+		for _, sub := range d.subscriptions {
+			if !sub.matches(event) {
+				continue
+			}
+			d.deliveries = append(d.deliveries, newDelivery(sub, event.ID, event.Type, payloads[i]))
+			queued = true
+		}
+	}
+	if !queued {
+		return
+	}
+
+	if err := d.saveDeliveries(); err != nil {
+		log.Printf("Failed to save webhook deliveries: %v", err)
+	}
+	d.notify()
+}
+
+// newDelivery creates a delivery due immediately
+func newDelivery(sub Subscription, eventID, eventType string, payload []byte) *Delivery {
+	now := time.Now().UTC()
+	return &Delivery{
+		ID:             newID(),
+		SubscriptionID: sub.ID,
+		URL:            sub.URL,
+		EventID:        eventID,
+		EventType:      eventType,
+		Payload:        payload,
+		Status:         DeliveryPending,
+		Attempts:       []Attempt{},
+		NextAttemptAt:  &now,
+		CreatedAt:      now,
+	}
+}
+
+// DeliveryFilter selects deliveries of the log. Empty fields match every delivery.
+type DeliveryFilter struct {
+	SubscriptionID string
+	EventType      string
+	Status         string
+}
+
+// Deliveries returns up to limit deliveries matching the filter, newest first
+func (d *Dispatcher) Deliveries(filter DeliveryFilter, limit int) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := []Delivery{}
+	for i := len(d.deliveries) - 1; i >= 0 && len(result) < limit; i-- {
+		delivery := d.deliveries[i]
+		if filter.SubscriptionID != "" && delivery.SubscriptionID != filter.SubscriptionID {
+			continue
+		}
+		if filter.EventType != "" && delivery.EventType != filter.EventType {
+			continue
+		}
+		if filter.Status != "" && delivery.Status != filter.Status {
+			continue
+		}
+		result = append(result, *delivery)
+	}
+	return result
+}
+
+// Delivery returns a delivery of the log
+func (d *Dispatcher) Delivery(id string) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, delivery := range d.deliveries {
+		if delivery.ID == id {
+			return *delivery, nil
+		}
+	}
+	return Delivery{}, ErrNotFound
+}
+
+// Redeliver queues the payload of a delivery again as a new delivery to the same subscription
+func (d *Dispatcher) Redeliver(id string) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var original *Delivery
+	for _, delivery := range d.deliveries {
+		if delivery.ID == id {
+			original = delivery
+		}
+	}
+	if original == nil {
+		return Delivery{}, ErrNotFound
+	}
+
+	sub, ok := d.subscription(original.SubscriptionID)
+	if !ok {
+		return Delivery{}, fmt.Errorf("subscription %s: %w", original.SubscriptionID, ErrNotFound)
+	}
+
+	delivery := newDelivery(sub, original.EventID, original.EventType, original.Payload)
+	delivery.RedeliveryOf = original.ID
+	d.deliveries = append(d.deliveries, delivery)
+	if err := d.saveDeliveries(); err != nil {
+		d.deliveries = d.deliveries[:len(d.deliveries)-1]
+		return Delivery{}, err
+	}
+
+	d.notify()
+	return *delivery, nil
+}
+
+// Forget redacts the payloads of the deliveries about an identity of a Kratos target, e.g. when
+// its personal data is erased. The event data is replaced with the identity ID, also for pending
+// deliveries and redeliveries.
+func (d *Dispatcher) Forget(target, identityID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	redacted := false
+	for _, delivery := range d.deliveries {
+		var event Event
+		if err := json.Unmarshal(delivery.Payload, &event); err != nil || event.Target != target {
+			continue
+		}
+		data, _ := event.Data.(map[string]interface{})
+		if data["identity_id"] != identityID && data["id"] != identityID {
+			continue
+		}
+
+		event.Data = map[string]interface{}{"identity_id": identityID, "redacted": true}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		delivery.Payload = payload
+		redacted = true
+	}
+	if !redacted {
+		return nil
+	}
+	return d.saveDeliveries()
+}
+
+// subscription returns a subscription including its secret. Callers must hold d.mu.
+func (d *Dispatcher) subscription(id string) (Subscription, bool) {
+	for _, sub := range d.subscriptions {
+		if sub.ID == id {
+			return sub, true
+		}
+	}
+	return Subscription{}, false
+}
+
+// Run delivers due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		wait := d.dispatchDue(ctx)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// dispatchDue starts an attempt for every due delivery and returns how long to wait for the next one
+func (d *Dispatcher) dispatchDue(ctx context.Context) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	wait := idleWait
+	for _, delivery := range d.deliveries {
+		if delivery.Status != DeliveryPending || delivery.NextAttemptAt == nil || d.inFlight[delivery.ID] {
+			continue
+		}
+		if until := delivery.NextAttemptAt.Sub(now); until > 0 {
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		sub, ok := d.subscription(delivery.SubscriptionID)
+		if !ok {
+			continue
+		}
+		d.inFlight[delivery.ID] = true
+		go d.attempt(ctx, delivery.ID, sub.Secret, delivery.URL, delivery.EventType, delivery.EventID, delivery.Payload)
+	}
+	return wait
+}
+
+// attempt sends a delivery once and schedules a retry on failure
+func (d *Dispatcher) attempt(ctx context.Context, id, secret, url, eventType, eventID string, payload []byte) {
+	start := time.Now()
+	statusCode, err := d.send(ctx, id, secret, url, eventType, eventID, payload)
+	result := Attempt{
+		At:         start.UTC(),
+		StatusCode: statusCode,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlight, id)
+
+	var delivery *Delivery
+	for _, candidate := range d.deliveries {
+		if candidate.ID == id {
+			delivery = candidate
+		}
+	}
+	if delivery == nil || delivery.Status != DeliveryPending {
+		return
+	}
+
+	delivery.Attempts = append(delivery.Attempts, result)
+	switch {
+	case err == nil:
+		delivery.Status = DeliverySucceeded
+		delivery.NextAttemptAt = nil
+	case len(delivery.Attempts) >= d.maxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = nil
+	default:
+		next := time.Now().UTC().Add(d.retryDelay(len(delivery.Attempts)))
+		delivery.NextAttemptAt = &next
+	}
+
+	d.prune()
+	if err := d.saveDeliveries(); err != nil {
+		log.Printf("Failed to save webhook deliveries: %v", err)
+	}
+	d.notify()
+}
+
+// send posts a signed payload and returns the response status code
+func (d *Dispatcher) send(ctx context.Context, id, secret, url, eventType, eventID string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kratos-admin-ui-webhooks")
+	req.Header.Set("X-Webhook-Id", eventID)
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set("X-Webhook-Delivery", id)
+	req.Header.Set("X-Webhook-Signature", Sign(secret, time.Now(), payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryDelay returns the delay before the attempt following the given number of attempts
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// prune drops the oldest finished deliveries beyond maxDeliveries. Callers must hold d.mu.
+func (d *Dispatcher) prune() {
+	finished := 0
+	for _, delivery := range d.deliveries {
+		if delivery.Status != DeliveryPending {
+			finished++
+		}
+	}
+	if finished <= maxDeliveries {
+		return
+	}
+
+	kept := make([]*Delivery, 0, len(d.deliveries))
+	for _, delivery := range d.deliveries {
+		if delivery.Status != DeliveryPending && finished > maxDeliveries {
+			finished--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	d.deliveries = kept
+}
+
+// saveDeliveries persists the delivery log in creation order. Callers must hold d.mu.
+func (d *Dispatcher) saveDeliveries() error {
+	sort.SliceStable(d.deliveries, func(i, j int) bool { return d.deliveries[i].CreatedAt.Before(d.deliveries[j].CreatedAt) })
+	return d.store.Save(deliveriesDocument, d.deliveries)
+}
+
+// notify wakes up Run without blocking
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Publisher publishes the events of one Kratos target
+type Publisher struct {
+	dispatcher *Dispatcher
+	target     string
+}
+
+// Publisher returns a publisher for events of a Kratos target
+func (d *Dispatcher) Publisher(target string) *Publisher {
+	return &Publisher{dispatcher: d, target: target}
+}
+
+// Publish queues an event caused by an admin or a provisioning actor. A nil publisher drops it.
+func (p *Publisher) Publish(eventType, actor string, data interface{}) {
+	if p == nil {
+		return
+	}
+	p.dispatcher.Publish(p.target, actor, eventType, data)
+}
+
+// PublishBatch queues one event per item of data. A nil publisher drops them.
+func (p *Publisher) PublishBatch(eventType, actor string, data []interface{}) {
+	if p == nil || len(data) == 0 {
+		return
+	}
+	p.dispatcher.PublishBatch(p.target, actor, eventType, data)
+}
+
+// Forget redacts the payloads of the deliveries about an identity of the target
+func (p *Publisher) Forget(identityID string) error {
+	return p.dispatcher.Forget(p.target, identityID)
+}
@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benoit-sauvere/kratos-admin-ui/backend/internal/store"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("whsec_test", time.Unix(1700000000, 0), []byte(`{"id":"evt"}`))
+	want := "t=1700000000,v1=a94cea056df1fbb92eadafcf2c5cd541dbe0c6ef736e4748202dd53f86694a3e"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	d := &Dispatcher{backoff: 10 * time.Minute}
+	want := []time.Duration{10 * time.Minute, 20 * time.Minute, 40 * time.Minute, time.Hour, time.Hour, time.Hour}
+	for i, delay := range want {
+		if got := d.retryDelay(i + 1); got != delay {
+			t.Errorf("retryDelay(%d) = %v, want %v", i+1, got, delay)
+		}
+	}
+
+	// The cap also applies to a backoff above it
+	d = &Dispatcher{backoff: 2 * time.Hour}
+	if got := d.retryDelay(1); got != maxBackoff {
+		t.Errorf("retryDelay(1) with a 2h backoff = %v, want %v", got, maxBackoff)
+	}
+}
+
+// newTestDispatcher returns a running dispatcher with a subscription to identity.created events
+// sent to a server answering the status stored in status
+func newTestDispatcher(t *testing.T, maxAttempts int, status *int32, requests *int32) *Dispatcher {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+	}))
+	t.Cleanup(server.Close)
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDispatcher(s, maxAttempts, time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateSubscription(Subscription{URL: server.URL, Events: []string{EventIdentityCreated}, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go d.Run(ctx)
+	return d
+}
+
+// waitForStatus waits until a delivery is no longer pending
+func waitForStatus(t *testing.T, d *Dispatcher, id string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		delivery, err := d.Delivery(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != DeliveryPending {
+			return delivery
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("delivery %s is still pending", id)
+	return Delivery{}
+}
+
+func TestDispatcherFailsAfterMaxAttempts(t *testing.T) {
+	status, requests := int32(http.StatusInternalServerError), int32(0)
+	d := newTestDispatcher(t, 3, &status, &requests)
+
+	d.Publish("default", "admin", EventIdentityCreated, map[string]interface{}{"identity_id": "abc"})
+	queued := d.Deliveries(DeliveryFilter{}, 10)
+	if len(queued) != 1 {
+		t.Fatalf("deliveries = %d, want 1", len(queued))
+	}
+
+	delivery := waitForStatus(t, d, queued[0].ID)
+	if delivery.Status != DeliveryFailed || len(delivery.Attempts) != 3 || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery = %+v, want it failed after 3 attempts", delivery)
+	}
+	for _, attempt := range delivery.Attempts {
+		if attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+			t.Errorf("attempt = %+v, want a 500 error", attempt)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestDispatcherRedeliver(t *testing.T) {
+	status, requests := int32(http.StatusInternalServerError), int32(0)
+	d := newTestDispatcher(t, 1, &status, &requests)
+
+	d.Publish("default", "admin", EventIdentityCreated, map[string]interface{}{"identity_id": "abc"})
+	original := waitForStatus(t, d, d.Deliveries(DeliveryFilter{}, 10)[0].ID)
+	if original.Status != DeliveryFailed {
+		t.Fatalf("delivery = %+v, want it failed", original)
+	}
+
+	atomic.StoreInt32(&status, http.StatusNoContent)
+	redelivery, err := d.Redeliver(original.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redelivery.ID == original.ID || redelivery.RedeliveryOf != original.ID || redelivery.Status != DeliveryPending ||
+		len(redelivery.Attempts) != 0 || redelivery.EventID != original.EventID || string(redelivery.Payload) != string(original.Payload) {
+		t.Errorf("redelivery = %+v, want a new pending delivery of %s", redelivery, original.ID)
+	}
+
+	if delivered := waitForStatus(t, d, redelivery.ID); delivered.Status != DeliverySucceeded {
+		t.Errorf("redelivery = %+v, want it succeeded", delivered)
+	}
+	if unchanged, _ := d.Delivery(original.ID); unchanged.Status != DeliveryFailed || len(unchanged.Attempts) != 1 {
+		t.Errorf("original delivery = %+v, want it left failed", unchanged)
+	}
+
+	if _, err := d.Redeliver("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Redeliver of a missing delivery = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	type received struct {
+		signature, event string
+		body             []byte
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Header.Get("X-Webhook-Signature"), r.Header.Get("X-Webhook-Event"), body}
+	}))
+	defer server.Close()
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDispatcher(s, 1, time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := d.CreateSubscription(Subscription{URL: server.URL, Events: []string{EventIdentityCreated}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Publish("default", "admin", EventIdentityCreated, map[string]interface{}{"identity_id": "abc"})
+
+	select {
+	case r := <-requests:
+		var unix int64
+		if _, err := fmt.Sscanf(r.signature, "t=%d,", &unix); err != nil {
+			t.Fatalf("signature %q has no timestamp: %v", r.signature, err)
+		}
+		if want := Sign(sub.Secret, time.Unix(unix, 0), r.body); r.signature != want {
+			t.Errorf("signature = %s, want %s", r.signature, want)
+		}
+		if r.event != EventIdentityCreated {
+			t.Errorf("X-Webhook-Event = %q, want %q", r.event, EventIdentityCreated)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery received")
+	}
+}
@@ -0,0 +1,160 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Event types published by the admin API
+const (
+	EventIdentityCreated    = "identity.created"
+	EventIdentityUpdated    = "identity.updated"
+	EventIdentityDeleted    = "identity.deleted"
+	EventIdentityRestored   = "identity.restored"
+	EventIdentityAnonymized = "identity.anonymized"
+	EventIdentityMerged     = "identity.merged"
+	EventPasswordReset      = "password.reset"
+	EventCredentialDeleted  = "credential.deleted"
+	EventSessionRevoked     = "session.revoked"
+)
+
+// EventTypes lists the event types subscriptions can select
+var EventTypes = []string{
+	EventIdentityCreated,
+	EventIdentityUpdated,
+	EventIdentityDeleted,
+	EventIdentityRestored,
+	EventIdentityAnonymized,
+	EventIdentityMerged,
+	EventPasswordReset,
+	EventCredentialDeleted,
+	EventSessionRevoked,
+}
+
+// ErrNotFound is returned when a subscription or delivery does not exist
+var ErrNotFound = errors.New("not found")
+
+// Actors of events caused by provisioning rather than by an admin
+const (
+	ActorSCIM     = "scim"
+	ActorLDAPSync = "ldap-sync"
+)
+
+// Event is the JSON payload delivered to subscribers
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Target is the Kratos target the event happened on, Actor the admin who caused it
+	Target     string      `json:"target"`
+	Actor      string      `json:"actor"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// Subscription delivers events of the selected types to a URL
+type Subscription struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Targets restricts the subscription to events of these Kratos targets, empty for every target
+	Targets []string `json:"targets"`
+	// Secret is the HMAC key of the payload signatures. It is only returned when the subscription is created.
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// matches reports whether the subscription receives an event
+func (s Subscription) matches(event Event) bool {
+	if !s.Active || !contains(s.Events, event.Type) {
+		return false
+	}
+	return len(s.Targets) == 0 || contains(s.Targets, event.Target)
+}
+
+// Redacted returns the subscription without its secret
+func (s Subscription) Redacted() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Attempt is a single HTTP request of a delivery
+type Attempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// Delivery tracks the delivery of an event to a subscription
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	URL            string          `json:"url"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       []Attempt       `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	// RedeliveryOf is the ID of the delivery this one repeats
+	RedeliveryOf string `json:"redelivery_of,omitempty"`
+}
+
+// Sign returns the signature header of a payload, "t=<unix time>,v1=<hex HMAC-SHA256>".
+// The HMAC covers the timestamp and the payload joined by a dot.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	t := fmt.Sprint(timestamp.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(payload)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEventType reports whether an event type is supported
+func IsEventType(eventType string) bool {
+	return contains(EventTypes, eventType)
+}
+
+// contains reports whether a list contains a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newID returns a random identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}